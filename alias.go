@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 模型别名规则：mapping / proxyMapping 只能精确匹配，modelAliases / proxyAliases 按顺序用通配符或正则匹配模型名，
+// target 中可用 $1、${name} 引用捕获的内容。精确映射优先，其次是第一条匹配的规则。
+
+// AliasRule modelAliases / proxyAliases 配置项，match 与 regex 二选一
+type AliasRule struct {
+	Match    string `json:"match"`    // 通配符，* 匹配任意个字符、? 匹配一个字符，依次捕获为 $1、$2…
+	Regex    string `json:"regex"`    // 正则，需要整名匹配时自行加 ^$
+	Target   string `json:"target"`   // 上游模型名，为空时保持原名
+	Provider string `json:"provider"` // 直接路由到 providers 中同名的后端，不再按 models 查找；proxyAliases 不使用
+}
+
+// aliasResolution 模型名的解析过程，/admin/resolve 原样输出
+type aliasResolution struct {
+	Model    string   `json:"model"`
+	Target   string   `json:"target"`
+	Source   string   `json:"source,omitempty"` // 命中的配置：mapping / modelAliases / proxyMapping / proxyAliases
+	Rule     *int     `json:"rule,omitempty"`   // 命中规则的下标
+	Pattern  string   `json:"pattern,omitempty"`
+	Captures []string `json:"captures,omitempty"`
+	Provider string   `json:"provider,omitempty"`
+	Steps    []string `json:"steps"`
+}
+
+// matched 是否命中映射或规则
+func (r *aliasResolution) matched() bool {
+	return r.Source != ""
+}
+
+// aliasPatterns 编译后的规则，键为 match: / regex: 加原文
+var aliasPatterns sync.Map
+
+type aliasPattern struct {
+	re  *regexp.Regexp
+	err error
+}
+
+// globRegexp 通配符转为整名匹配的正则，* 与 ? 为捕获分组
+func globRegexp(glob string) string {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range glob {
+		switch r {
+		case '*':
+			sb.WriteString("(.*)")
+		case '?':
+			sb.WriteString("(.)")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return sb.String()
+}
+
+// pattern 规则的正则与用于展示的原文
+func (r *AliasRule) pattern() (*regexp.Regexp, string, error) {
+	key, expr := "regex:"+r.Regex, r.Regex
+	display := r.Regex
+	if r.Match != "" {
+		key, expr, display = "match:"+r.Match, globRegexp(r.Match), r.Match
+	} else if r.Regex == "" {
+		return nil, "", fmt.Errorf("match or regex is required")
+	}
+	if cached, ok := aliasPatterns.Load(key); ok {
+		p := cached.(aliasPattern)
+		return p.re, display, p.err
+	}
+	re, err := regexp.Compile(expr)
+	aliasPatterns.Store(key, aliasPattern{re: re, err: err})
+	return re, display, err
+}
+
+// resolveAlias 精确映射优先，其次按顺序匹配规则，都没有命中时 target 为原名
+func resolveAlias(exactName string, exact map[string]string, rulesName string, rules []AliasRule, model string) aliasResolution {
+	res := aliasResolution{Model: model, Target: model, Steps: []string{}}
+	if mapped, ok := exact[model]; ok {
+		res.Target, res.Source = mapped, exactName
+		res.Steps = append(res.Steps, fmt.Sprintf("%s: %s -> %s", exactName, model, mapped))
+		return res
+	}
+	for i := range rules {
+		rule := &rules[i]
+		re, display, err := rule.pattern()
+		if err != nil {
+			res.Steps = append(res.Steps, fmt.Sprintf("%s[%d]: invalid pattern %q: %v", rulesName, i, display, err))
+			continue
+		}
+		match := re.FindStringSubmatchIndex(model)
+		if match == nil {
+			res.Steps = append(res.Steps, fmt.Sprintf("%s[%d] %q: no match", rulesName, i, display))
+			continue
+		}
+		index := i
+		res.Source, res.Rule, res.Pattern, res.Provider = rulesName, &index, display, rule.Provider
+		for g := 1; 2*g+1 < len(match); g++ {
+			if match[2*g] < 0 {
+				res.Captures = append(res.Captures, "")
+			} else {
+				res.Captures = append(res.Captures, model[match[2*g]:match[2*g+1]])
+			}
+		}
+		if rule.Target != "" {
+			res.Target = string(re.ExpandString(nil, rule.Target, model, match))
+		}
+		step := fmt.Sprintf("%s[%d] %q: matched, %s -> %s", rulesName, i, display, model, res.Target)
+		if rule.Provider != "" {
+			step += ", provider " + rule.Provider
+		}
+		res.Steps = append(res.Steps, step)
+		return res
+	}
+	if len(res.Steps) == 0 {
+		res.Steps = append(res.Steps, "no alias configured")
+	}
+	return res
+}
+
+// resolveModelAlias 各入口按模型选择后端时使用的映射
+func resolveModelAlias(model string) aliasResolution {
+	return resolveAlias("mapping", XConfig.Mapping, "modelAliases", XConfig.ModelAliases, model)
+}
+
+// resolveProxyAlias ProxyChatHandle 与透传代理使用的映射，规则中的 provider 不生效
+func resolveProxyAlias(model string) aliasResolution {
+	res := resolveAlias("proxyMapping", XConfig.ProxyMapping, "proxyAliases", XConfig.ProxyAliases, model)
+	res.Provider = ""
+	return res
+}
+
+// hasProxyAliases 是否配置了透传代理的模型映射
+func hasProxyAliases() bool {
+	return len(XConfig.ProxyMapping) > 0 || len(XConfig.ProxyAliases) > 0
+}
+
+// adminAuth 校验 Authorization: Bearer <adminToken>
+func adminAuth(c *gin.Context) {
+	token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if !ok || XConfig == nil || XConfig.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(XConfig.AdminToken)) != 1 {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+	c.Next()
+}
+
+// AdminResolveHandler GET /admin/resolve?model= 查看模型名命中了哪条映射以及最终路由到的后端
+func AdminResolveHandler(c *gin.Context) {
+	model := c.Query("model")
+	if model == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+	out := gin.H{"model": model}
+	// 虚拟模型沿 FROM 找到基础模型后再映射
+	var chain []string
+	for vm, ok := lookupVirtualModel(model); ok && len(chain) < maxVirtualModelDepth; vm, ok = lookupVirtualModel(model) {
+		chain = append(chain, model)
+		model = vm.From
+	}
+	if len(chain) > 0 {
+		out["virtualModels"] = chain
+	}
+	res := resolveModelAlias(model)
+	out["mapping"] = res
+	out["proxy"] = resolveProxyAlias(c.Query("model"))
+	switch cfg, err := modelProviderConfig(&res); {
+	case err != nil:
+		out["error"] = err.Error()
+	case cfg != nil:
+		out["provider"] = gin.H{"name": cfg.Name, "type": cfg.Type}
+	default:
+		out["chatType"] = XConfig.ChatType
+	}
+	c.JSON(http.StatusOK, out)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestResolveAlias(t *testing.T) {
+	XConfig = &Config{
+		Mapping: map[string]string{"deepseek-chat": "exact"},
+		ModelAliases: []AliasRule{
+			{Regex: "(["},
+			{Match: "deepseek-*", Target: "claude-4-sonnet-latest"},
+			{Regex: `^gpt-(.*)-mini$`, Target: "$1-fast"},
+			{Match: "qwen?-*", Target: "q${1}-${2}"},
+			{Regex: `^(?P<family>llama)-`, Target: "${family}-local", Provider: "local"},
+			{Match: "kimi-*"},
+		},
+	}
+	defer func() { XConfig = nil }()
+	cases := []struct {
+		model, target, source string
+		rule                  int
+	}{
+		{"deepseek-chat", "exact", "mapping", -1},
+		{"deepseek-reasoner", "claude-4-sonnet-latest", "modelAliases", 1},
+		{"gpt-4o-mini", "4o-fast", "modelAliases", 2},
+		{"gpt-4o", "gpt-4o", "", -1},
+		{"qwen3-coder", "q3-coder", "modelAliases", 3},
+		{"llama-3.1-8b", "llama-local", "modelAliases", 4},
+		{"kimi-k2", "kimi-k2", "modelAliases", 5},
+	}
+	for _, tc := range cases {
+		res := resolveModelAlias(tc.model)
+		rule := -1
+		if res.Rule != nil {
+			rule = *res.Rule
+		}
+		if res.Target != tc.target || res.Source != tc.source || rule != tc.rule {
+			t.Errorf("%s: %+v", tc.model, res)
+		}
+	}
+	if res := resolveModelAlias("llama-3.1-8b"); res.Provider != "local" || len(res.Steps) != 5 || !strings.Contains(res.Steps[0], "invalid pattern") {
+		t.Errorf("steps = %q, provider = %s", res.Steps, res.Provider)
+	}
+}
+
+func TestAliasProviderRouting(t *testing.T) {
+	server := newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		var body ChatCompletionRequest
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"id":"1","object":"chat.completion","model":%q,"choices":[{"index":0,"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}]}`, body.Model)
+	})
+	defer server.Close()
+	XConfig = &Config{
+		ChatType:     "dify",
+		BaseUrl:      server.URL,
+		ModelAliases: []AliasRule{{Regex: `^gpt-(.*)-mini$`, Target: "$1-fast", Provider: "fast"}},
+		ProxyAliases: []AliasRule{{Match: "deepseek-*", Target: "claude-4-sonnet-latest"}},
+		Providers:    []ProviderConfig{{Name: "fast", Type: "openai", BaseUrl: server.URL}},
+		AdminToken:   "secret",
+	}
+	defer func() { XConfig = nil }()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/chat/completions", OpenaiHandler)
+	router.POST("/proxy/chat/completions", ProxyChatHandle)
+	router.GET("/admin/resolve", adminAuth, AdminResolveHandler)
+
+	for _, tc := range []struct{ path, model, upstream string }{
+		{"/v1/chat/completions", "gpt-4o-mini", "4o-fast"},
+		{"/proxy/chat/completions", "deepseek-reasoner", "claude-4-sonnet-latest"},
+	} {
+		server.reset()
+		w := httptest.NewRecorder()
+		body := fmt.Sprintf(`{"model":%q,"messages":[{"role":"user","content":"hi"}]}`, tc.model)
+		router.ServeHTTP(w, httptest.NewRequest("POST", tc.path, strings.NewReader(body)))
+		sent := standInBodies[ChatCompletionRequest](server)
+		if len(sent) != 1 || sent[0].Model != tc.upstream || !strings.Contains(w.Body.String(), fmt.Sprintf(`"model":%q`, tc.model)) {
+			t.Fatalf("%s: upstream requests %+v, response %s", tc.path, sent, w.Body.String())
+		}
+	}
+
+	// 管理接口需要 adminToken
+	for _, auth := range []string{"", "Bearer wrong"} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/admin/resolve?model=gpt-4o-mini", nil)
+		req.Header.Set("Authorization", auth)
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("auth %q: %d %s", auth, w.Code, w.Body.String())
+		}
+	}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/admin/resolve?model=gpt-4o-mini", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	router.ServeHTTP(w, req)
+	var out struct {
+		Mapping  aliasResolution   `json:"mapping"`
+		Proxy    aliasResolution   `json:"proxy"`
+		Provider map[string]string `json:"provider"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &out)
+	if out.Mapping.Target != "4o-fast" || out.Mapping.Pattern != `^gpt-(.*)-mini$` || strings.Join(out.Mapping.Captures, ",") != "4o" ||
+		out.Provider["name"] != "fast" || out.Proxy.matched() {
+		t.Fatalf("resolve: %s", w.Body.String())
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const azureDefaultAPIVersion = "2024-10-21"
+
+// AzureProvider 通过 Azure OpenAI 的部署接口对话
+// {baseUrl}/openai/deployments/{deployment}/chat/completions?api-version=...
+type AzureProvider struct {
+	Config *ProviderConfig
+}
+
+func (p *AzureProvider) ChatStream(ctx context.Context, req *ChatCompletionRequest, emit func(ChatEvent) error) error {
+	req, ignored := adaptSampling(req, openaiSampling)
+	if err := reportIgnoredParams(emit, ignored); err != nil {
+		return err
+	}
+	return azureError(openaiChatStream(ctx, p.deploymentURL(req.Model, "chat/completions"), p.header(), req, emit))
+}
+
+// Embed 通过部署的 /embeddings 接口向量化
+func (p *AzureProvider) Embed(ctx context.Context, req *EmbeddingRequest) (*EmbeddingResult, error) {
+	result, err := openaiEmbed(ctx, p.deploymentURL(req.Model, "embeddings"), p.header(), req)
+	return result, azureError(err)
+}
+
+// azureError 上游错误体统一为 OpenAI 格式
+func azureError(err error) error {
+	var upstream *UpstreamError
+	if errors.As(err, &upstream) {
+		return &UpstreamError{StatusCode: upstream.StatusCode, Body: normalizeAzureError(upstream.StatusCode, upstream.Body)}
+	}
+	return err
+}
+
+// deployment 模型别名对应的部署名，未配置时直接使用模型名
+func (p *AzureProvider) deployment(model string) string {
+	if deployment, ok := p.Config.Deployments[model]; ok {
+		return deployment
+	}
+	return model
+}
+
+// deploymentURL 部署下的接口地址，operation 为 chat/completions 或 embeddings
+func (p *AzureProvider) deploymentURL(model, operation string) string {
+	apiVersion := p.Config.APIVersion
+	if apiVersion == "" {
+		apiVersion = azureDefaultAPIVersion
+	}
+	return fmt.Sprintf("%s/openai/deployments/%s/%s?api-version=%s",
+		strings.TrimSuffix(p.Config.BaseUrl, "/"), url.PathEscape(p.deployment(model)), operation, url.QueryEscape(apiVersion))
+}
+
+// header 默认使用 api-key 头，authType 为 bearer 时使用 Entra ID 令牌
+func (p *AzureProvider) header() http.Header {
+	header := http.Header{}
+	if p.Config.AuthType == "bearer" {
+		header.Set("Authorization", "Bearer "+p.Config.APIKey)
+	} else {
+		header.Set("api-key", p.Config.APIKey)
+	}
+	return header
+}
+
+// normalizeAzureError Azure 的错误体统一为 OpenAI 格式 {"error":{"message","type","param","code"}}
+// Azure 常见的几种形态：
+//   - {"error":{"code":"DeploymentNotFound","message":"..."}}
+//   - {"error":{"code":"content_filter","message":"...","param":"prompt","status":400,"innererror":{...}}}
+//   - {"statusCode":401,"message":"Access denied due to invalid subscription key..."}
+func normalizeAzureError(status int, body string) string {
+	var raw struct {
+		Error *struct {
+			Message    string          `json:"message"`
+			Type       *string         `json:"type"`
+			Param      *string         `json:"param"`
+			Code       interface{}     `json:"code"`
+			InnerError json.RawMessage `json:"innererror"`
+		} `json:"error"`
+		StatusCode int    `json:"statusCode"`
+		Message    string `json:"message"`
+	}
+	out := map[string]interface{}{
+		"message": strings.TrimSpace(body),
+		"type":    azureErrorType(status),
+		"param":   nil,
+		"code":    nil,
+	}
+	if err := json.Unmarshal([]byte(body), &raw); err == nil {
+		switch {
+		case raw.Error != nil:
+			out["message"] = raw.Error.Message
+			if raw.Error.Type != nil && *raw.Error.Type != "" {
+				out["type"] = *raw.Error.Type
+			}
+			if raw.Error.Param != nil {
+				out["param"] = *raw.Error.Param
+			}
+			if raw.Error.Code != nil {
+				out["code"] = fmt.Sprint(raw.Error.Code)
+			}
+			if len(raw.Error.InnerError) > 0 {
+				// 内容过滤的详细结果在 innererror.content_filter_result 中，保留给客户端
+				out["innererror"] = raw.Error.InnerError
+			}
+		case raw.Message != "":
+			out["message"] = raw.Message
+		}
+	}
+	data, _ := json.Marshal(map[string]interface{}{"error": out})
+	return string(data)
+}
+
+func azureErrorType(status int) string {
+	switch {
+	case status == http.StatusUnauthorized:
+		return "authentication_error"
+	case status == http.StatusForbidden:
+		return "permission_error"
+	case status == http.StatusNotFound:
+		return "not_found_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status >= 500:
+		return "server_error"
+	default:
+		return "invalid_request_error"
+	}
+}
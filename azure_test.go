@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAzureChatThroughOpenaiHandler(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/openai/deployments/gpt4o-prod/chat/completions" || r.URL.Query().Get("api-version") != "2024-06-01" {
+			t.Errorf("unexpected url: %s", r.URL.String())
+		}
+		if r.Header.Get("api-key") != "azure-key" || r.Header.Get("Authorization") != "" {
+			t.Errorf("unexpected auth headers: %v", r.Header)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"choices":[],"prompt_filter_results":[{"prompt_index":0,"content_filter_results":{"hate":{"filtered":false,"severity":"safe"}}}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"choices":[{"index":0,"delta":{"role":"assistant","content":"Hi"},"finish_reason":null,"content_filter_results":{"violence":{"filtered":false,"severity":"low"}}}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_a","type":"function","function":{"name":"f","arguments":"{\"a\":"}}]}}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"1}"}}]},"finish_reason":"tool_calls"}]}`+"\n\n")
+		fmt.Fprint(w, `data: {"choices":[],"usage":{"prompt_tokens":3,"completion_tokens":4,"total_tokens":7}}`+"\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	XConfig = &Config{Providers: []ProviderConfig{{
+		Type:        "azure",
+		BaseUrl:     server.URL,
+		APIKey:      "azure-key",
+		APIVersion:  "2024-06-01",
+		Deployments: map[string]string{"gpt-4o": "gpt4o-prod"},
+	}}}
+	defer func() { XConfig = nil }()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/openai/v1/chat/completions", OpenaiHandler)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/openai/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`)))
+
+	var resp ChatCompletionResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decode %q: %v", w.Body.String(), err)
+	}
+	choice := resp.Choices[0]
+	if resp.Model != "gpt-4o" || choice.Message.Content != "Hi" || choice.FinishReason != FinishReasonToolCalls {
+		t.Errorf("resp = %+v", resp)
+	}
+	if len(choice.Message.ToolCalls) != 1 || choice.Message.ToolCalls[0].Function.Arguments != `{"a":1}` {
+		t.Errorf("tool calls = %+v", choice.Message.ToolCalls)
+	}
+	if choice.ContentFilterResults.Violence.Severity != "low" {
+		t.Errorf("content filter = %+v", choice.ContentFilterResults)
+	}
+	if len(resp.PromptFilterResults) != 1 || resp.PromptFilterResults[0].ContentFilterResults.Hate.Severity != "safe" {
+		t.Errorf("prompt filter = %+v", resp.PromptFilterResults)
+	}
+	if resp.Usage.TotalTokens != 7 {
+		t.Errorf("usage = %+v", resp.Usage)
+	}
+}
+
+func TestNormalizeAzureError(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   map[string]interface{}
+	}{
+		{
+			name:   "deployment not found",
+			status: 404,
+			body:   `{"error":{"code":"DeploymentNotFound","message":"The API deployment for this resource does not exist."}}`,
+			want:   map[string]interface{}{"code": "DeploymentNotFound", "type": "not_found_error", "message": "The API deployment for this resource does not exist."},
+		},
+		{
+			name:   "content filter",
+			status: 400,
+			body:   `{"error":{"message":"filtered","type":null,"param":"prompt","code":"content_filter","status":400,"innererror":{"code":"ResponsibleAIPolicyViolation"}}}`,
+			want:   map[string]interface{}{"code": "content_filter", "type": "invalid_request_error", "param": "prompt"},
+		},
+		{
+			name:   "apim access denied",
+			status: 401,
+			body:   `{"statusCode":401,"message":"Access denied due to invalid subscription key."}`,
+			want:   map[string]interface{}{"type": "authentication_error", "message": "Access denied due to invalid subscription key."},
+		},
+		{
+			name:   "plain text",
+			status: 502,
+			body:   "Bad Gateway",
+			want:   map[string]interface{}{"type": "server_error", "message": "Bad Gateway"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got struct {
+				Error map[string]interface{} `json:"error"`
+			}
+			if err := json.Unmarshal([]byte(normalizeAzureError(tt.status, tt.body)), &got); err != nil {
+				t.Fatal(err)
+			}
+			for key, value := range tt.want {
+				if got.Error[key] != value {
+					t.Errorf("%s = %v, want %v", key, got.Error[key], value)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenAIStreamToolCallIndex(t *testing.T) {
+	for _, index := range []string{"-1", "100000000"} {
+		body := "data: " + openaiChunk(`{"tool_calls":[{"index":`+index+`,"id":"call_a","function":{"name":"f","arguments":"{}"}}]}`, "") + "\n\n"
+		err := readOpenAIStream(strings.NewReader(body), func(ChatEvent) error { return nil })
+		if err == nil || !strings.Contains(err.Error(), "invalid tool call index") {
+			t.Fatalf("index %s: err = %v", index, err)
+		}
+	}
+}
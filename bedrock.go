@@ -0,0 +1,370 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const bedrockAnthropicVersion = "bedrock-2023-05-31"
+
+// BedrockProvider 通过 AWS Bedrock InvokeModelWithResponseStream 调用 Claude
+type BedrockProvider struct {
+	Config *ProviderConfig
+}
+
+// awsCredentials AWS 访问凭证
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// credentials 优先使用配置中的密钥，未配置时读取 AWS_* 环境变量
+func (p *BedrockProvider) credentials() awsCredentials {
+	creds := awsCredentials{
+		AccessKeyID:     p.Config.AccessKeyID,
+		SecretAccessKey: p.Config.SecretAccessKey,
+		SessionToken:    p.Config.SessionToken,
+	}
+	if creds.AccessKeyID == "" {
+		creds.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		creds.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		creds.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	return creds
+}
+
+func (p *BedrockProvider) region() string {
+	if p.Config.Region != "" {
+		return p.Config.Region
+	}
+	if region := os.Getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	if region := os.Getenv("AWS_DEFAULT_REGION"); region != "" {
+		return region
+	}
+	return "us-east-1"
+}
+
+// modelID 模型别名对应的 Bedrock modelId
+func (p *BedrockProvider) modelID(model string) string {
+	if id, ok := p.Config.ModelIDs[model]; ok {
+		return id
+	}
+	return model
+}
+
+func (p *BedrockProvider) ChatStream(ctx context.Context, req *ChatCompletionRequest, emit func(ChatEvent) error) error {
+	if structuredOutputRequested(req) {
+		return emulateStructuredOutput(ctx, p, req, emit)
+	}
+	req, ignored := adaptSampling(req, claudeSampling(req))
+	if err := reportIgnoredParams(emit, ignored); err != nil {
+		return err
+	}
+	// Bedrock 的请求体不含 model/stream，版本号放在 anthropic_version
+	body := map[string]interface{}{}
+	data, err := json.Marshal(ChatToClaudeRequest(req))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &body); err != nil {
+		return err
+	}
+	delete(body, "model")
+	delete(body, "stream")
+	body["anthropic_version"] = bedrockAnthropicVersion
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	region := p.region()
+	baseUrl := strings.TrimSuffix(p.Config.BaseUrl, "/")
+	if baseUrl == "" {
+		baseUrl = fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", region)
+	}
+	// modelId 中的 ':' 等字符必须编码进路径，签名时再按规范二次编码
+	rawPath := "/model/" + awsURIEncode(p.modelID(req.Model), true) + "/invoke-with-response-stream"
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", baseUrl+rawPath, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/vnd.amazon.eventstream")
+	httpReq.Header.Set("X-Amzn-Bedrock-Accept", "application/json")
+	signSigV4(httpReq, payload, p.credentials(), region, "bedrock", time.Now())
+
+	resp, err := providerClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body)
+		return &UpstreamError{StatusCode: resp.StatusCode, Body: string(data)}
+	}
+
+	state := claudeStreamState{}
+	decoder := newEventStreamDecoder(resp.Body)
+	for {
+		msg, err := decoder.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch msg.Headers[":message-type"] {
+		case "exception", "error":
+			var e struct {
+				Message string `json:"message"`
+			}
+			_ = json.Unmarshal(msg.Payload, &e)
+			kind := msg.Headers[":exception-type"]
+			if kind == "" {
+				kind = msg.Headers[":error-code"]
+			}
+			return fmt.Errorf("bedrock %s: %s", kind, e.Message)
+		}
+		if msg.Headers[":event-type"] != "chunk" {
+			continue
+		}
+		// chunk 的 payload 为 {"bytes":"<base64 编码的 Anthropic 事件>"}
+		var chunk struct {
+			Bytes string `json:"bytes"`
+		}
+		if err := json.Unmarshal(msg.Payload, &chunk); err != nil {
+			return err
+		}
+		event, err := base64.StdEncoding.DecodeString(chunk.Bytes)
+		if err != nil {
+			return err
+		}
+		if err := state.handle("", event, emit); err != nil {
+			return err
+		}
+	}
+}
+
+// signSigV4 按 AWS Signature Version 4 给请求签名，签名 host、content-type 以及所有 x-amz-* 头
+// https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+func signSigV4(req *http.Request, payload []byte, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for key, values := range req.Header {
+		name := strings.ToLower(key)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.Join(values, ",")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + strings.Join(strings.Fields(headers[name]), " ") + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	// 非 S3 服务的 canonical URI 是对已编码路径再编码一次
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		segments[i] = awsURIEncode(segment, true)
+	}
+
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, awsURIEncode(key, true)+"="+awsURIEncode(value, true))
+		}
+	}
+
+	payloadHash := sha256.Sum256(payload)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		strings.Join(segments, "/"),
+		strings.Join(pairs, "&"),
+		canonicalHeaders.String(),
+		signedHeaders,
+		hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// awsURIEncode 按 AWS 规则编码，只保留 A-Z a-z 0-9 - _ . ~ 不编码
+func awsURIEncode(s string, encodeSlash bool) string {
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			sb.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&sb, "%%%02X", c)
+	}
+	return sb.String()
+}
+
+// eventStreamMessage application/vnd.amazon.eventstream 的一帧
+type eventStreamMessage struct {
+	Headers map[string]string
+	Payload []byte
+}
+
+// eventStreamDecoder 解码 AWS 二进制事件流：
+// total_length(4) headers_length(4) prelude_crc(4) headers payload message_crc(4)，整数均为大端序
+type eventStreamDecoder struct {
+	r *bufio.Reader
+}
+
+func newEventStreamDecoder(r io.Reader) *eventStreamDecoder {
+	return &eventStreamDecoder{r: bufio.NewReader(r)}
+}
+
+const eventStreamMaxMessageSize = 16 << 20
+
+func (d *eventStreamDecoder) Next() (*eventStreamMessage, error) {
+	prelude := make([]byte, 12)
+	if _, err := io.ReadFull(d.r, prelude); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("eventstream: truncated prelude")
+		}
+		return nil, err
+	}
+	totalLen := binary.BigEndian.Uint32(prelude[0:4])
+	headersLen := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return nil, errors.New("eventstream: prelude checksum mismatch")
+	}
+	if totalLen < 16+headersLen || totalLen > eventStreamMaxMessageSize {
+		return nil, fmt.Errorf("eventstream: invalid message length %d", totalLen)
+	}
+
+	rest := make([]byte, totalLen-12)
+	if _, err := io.ReadFull(d.r, rest); err != nil {
+		return nil, errors.New("eventstream: truncated message")
+	}
+	body := rest[:len(rest)-4]
+	crc := crc32.NewIEEE()
+	crc.Write(prelude)
+	crc.Write(body)
+	if crc.Sum32() != binary.BigEndian.Uint32(rest[len(rest)-4:]) {
+		return nil, errors.New("eventstream: message checksum mismatch")
+	}
+
+	headers, err := decodeEventStreamHeaders(body[:headersLen])
+	if err != nil {
+		return nil, err
+	}
+	return &eventStreamMessage{Headers: headers, Payload: body[headersLen:]}, nil
+}
+
+// decodeEventStreamHeaders 解析帧头，字符串类型之外的值只跳过不保留
+func decodeEventStreamHeaders(b []byte) (map[string]string, error) {
+	headers := map[string]string{}
+	for len(b) > 0 {
+		nameLen := int(b[0])
+		if len(b) < 1+nameLen+1 {
+			return nil, errors.New("eventstream: truncated header")
+		}
+		name := string(b[1 : 1+nameLen])
+		valueType := b[1+nameLen]
+		b = b[2+nameLen:]
+		var size int
+		switch valueType {
+		case 0, 1: // bool true / false
+			size = 0
+		case 2: // byte
+			size = 1
+		case 3: // short
+			size = 2
+		case 4: // int
+			size = 4
+		case 5, 8: // long / timestamp
+			size = 8
+		case 9: // uuid
+			size = 16
+		case 6, 7: // bytes / string
+			if len(b) < 2 {
+				return nil, errors.New("eventstream: truncated header")
+			}
+			n := int(binary.BigEndian.Uint16(b[:2]))
+			if len(b) < 2+n {
+				return nil, errors.New("eventstream: truncated header")
+			}
+			if valueType == 7 {
+				headers[name] = string(b[2 : 2+n])
+			}
+			b = b[2+n:]
+			continue
+		default:
+			return nil, fmt.Errorf("eventstream: unknown header type %d", valueType)
+		}
+		if len(b) < size {
+			return nil, errors.New("eventstream: truncated header")
+		}
+		b = b[size:]
+	}
+	return headers, nil
+}
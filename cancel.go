@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// errClientGone 写回客户端失败，用作取消上游请求的原因
+var errClientGone = errors.New("client disconnected")
+
+// watchTick 检查超时与保活的间隔
+const watchTick = 100 * time.Millisecond
+
+// clientWatch 把上游请求绑定到客户端连接：客户端断开或写回失败时立即取消上游请求，不再继续读取和计费。
+// 断开检测使用请求 context（net/http 在连接关闭时取消它，用于取代已弃用的 CloseNotify），
+// 写回失败由 emit 发现后主动取消。同时按 timeouts 规则检查上游超时，并在客户端空闲时发送保活。
+type clientWatch struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+	model  string
+	start  time.Time
+	// 已收到的输出，上游没有返回用量时用来估算
+	output int
+	usage  *Usage
+
+	rule  TimeoutRule
+	clock *upstreamClock
+	// mu 串行化写回与保活，保活在 supervise 协程中发送
+	mu        sync.Mutex
+	lastWrite time.Time
+	ping      func() error
+	stopOnce  sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+func watchClient(c *gin.Context, model string) *clientWatch {
+	ctx, cancel := context.WithCancelCause(c.Request.Context())
+	rule := timeoutRule(c, model)
+	clock := &upstreamClock{connect: time.Duration(rule.Connect)}
+	w := &clientWatch{
+		ctx:    withUpstreamClock(ctx, clock),
+		cancel: cancel,
+		model:  model,
+		start:  time.Now(),
+		rule:   rule,
+		clock:  clock,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	w.lastWrite = w.start
+	go w.supervise()
+	return w
+}
+
+// keepalive 设置保活的写法，只用于流式响应；ping 可以自行决定是否写出（返回 nil 即可跳过）
+func (w *clientWatch) keepalive(ping func() error) {
+	w.mu.Lock()
+	w.ping = ping
+	w.mu.Unlock()
+}
+
+// write 串行化写回，与保活互斥；写回失败时取消上游并返回 errClientGone
+func (w *clientWatch) write(fn func() error) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := fn(); err != nil {
+		w.cancel(errClientGone)
+		return fmt.Errorf("%w: %v", errClientGone, err)
+	}
+	w.lastWrite = time.Now()
+	return nil
+}
+
+// emit 包装写回函数，统计输出
+func (w *clientWatch) emit(fn func(ChatEvent) error) func(ChatEvent) error {
+	return func(ev ChatEvent) error {
+		w.output += estimateTokens(ev.Content) + estimateTokens(ev.Reasoning)
+		for _, call := range ev.ToolCalls {
+			w.output += estimateTokens(call.Function.Arguments)
+		}
+		if ev.Usage != nil {
+			w.usage = ev.Usage
+		}
+		return w.write(func() error { return fn(ev) })
+	}
+}
+
+func (w *clientWatch) supervise() {
+	defer close(w.done)
+	ticker := time.NewTicker(watchTick)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-w.ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := w.timedOut(now); err != nil {
+				log.Printf("上游超时，已取消请求: model=%s %v", w.model, err)
+				w.cancel(err)
+				return
+			}
+			w.sendKeepalive(now)
+		}
+	}
+}
+
+func (w *clientWatch) timedOut(now time.Time) error {
+	if w.rule.Total > 0 && now.Sub(w.start) > time.Duration(w.rule.Total) {
+		return fmt.Errorf("%w (total %s)", errUpstreamTimeout, time.Duration(w.rule.Total))
+	}
+	return w.clock.check(&w.rule, now)
+}
+
+func (w *clientWatch) sendKeepalive(now time.Time) {
+	interval := w.rule.keepaliveInterval()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	// 后端在发出上游请求之前通过事件报告响应头（如被忽略的参数），此前保活会提前提交响应头
+	if w.ping == nil || interval == 0 || now.Sub(w.lastWrite) < interval || !w.clock.sent() {
+		return
+	}
+	if err := w.ping(); err != nil {
+		w.cancel(errClientGone)
+		return
+	}
+	w.lastWrite = now
+}
+
+// halt 停止超时检查与保活，返回后不会再有保活写入
+func (w *clientWatch) halt() {
+	w.stopOnce.Do(func() { close(w.stop) })
+	<-w.done
+}
+
+// gone 上游调用返回后检查客户端是否已断开，断开时记录已消耗的 token，调用方不再写回响应
+func (w *clientWatch) gone() bool {
+	w.halt()
+	if w.ctx.Err() == nil || errors.Is(context.Cause(w.ctx), errUpstreamTimeout) {
+		return false
+	}
+	if w.usage != nil {
+		log.Printf("客户端断开，已取消上游请求: model=%s 用时=%s 输入 %d tokens，输出 %d tokens（%v）",
+			w.model, time.Since(w.start).Round(time.Millisecond), w.usage.PromptTokens, w.usage.CompletionTokens, context.Cause(w.ctx))
+	} else {
+		log.Printf("客户端断开，已取消上游请求: model=%s 用时=%s 已输出约 %d tokens（%v）",
+			w.model, time.Since(w.start).Round(time.Millisecond), w.output, context.Cause(w.ctx))
+	}
+	return true
+}
+
+// failure 上游因超时被取消时把错误替换为 504，其它错误原样返回
+func (w *clientWatch) failure(err error) error {
+	if err == nil {
+		return nil
+	}
+	if cause := context.Cause(w.ctx); errors.Is(cause, errUpstreamTimeout) {
+		return &UpstreamError{StatusCode: http.StatusGatewayTimeout, Body: cause.Error()}
+	}
+	return err
+}
+
+// estimateTokens 粗略估算 token 数：ASCII 约 4 个字符一个 token，其它字符（中文等）约一个字符一个 token
+func estimateTokens(text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < 0x80 {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	return other + (ascii+3)/4
+}
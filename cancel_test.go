@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestClientDisconnectCancelsUpstream(t *testing.T) {
+	cancelled := make(chan struct{}, 1)
+	stopped := make(chan string, 1)
+	upstream := newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		if strings.HasSuffix(r.URL.Path, "/stop") {
+			stopped <- r.URL.Path
+			return
+		}
+		writeSSE(w, `{"event":"message","task_id":"t1","answer":"Hi"}`)
+		// 一直生成，直到请求被取消
+		<-r.Context().Done()
+		cancelled <- struct{}{}
+	})
+	defer upstream.Close()
+	XConfig = &Config{ChatType: "dify", APIURL: upstream.URL + "/chat-messages", DifyTokenMap: map[string]string{"dify-test": "token"}}
+	defer func() { XConfig = nil }()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/chat", chatHandlerSteam)
+	proxy := httptest.NewServer(router)
+	defer proxy.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "POST", proxy.URL+"/api/chat",
+		strings.NewReader(`{"model":"dify-test","messages":[{"role":"user","content":"hi"}]}`))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || !strings.Contains(line, "Hi") {
+		t.Fatalf("first chunk = %q, %v", line, err)
+	}
+	cancel()
+	resp.Body.Close()
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream request was not cancelled")
+	}
+	select {
+	case path := <-stopped:
+		if path != "/chat-messages/t1/stop" {
+			t.Fatalf("stop path = %s", path)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("dify task was not stopped")
+	}
+}
@@ -1,14 +1,15 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"strings"
-	"time"
+
+	"ollamaproxy/sse"
 
 	"github.com/gin-gonic/gin"
 )
@@ -27,11 +28,6 @@ type ClaudeModelResponse struct {
 	LastID  string           `json:"last_id"`
 }
 
-type ClaudeBlockResponse struct {
-	Type  string       `json:"type"`
-	Index int          `json:"index"`
-	Delta *ClaudeDelta `json:"delta"`
-}
 type ClaudeStartResponse struct {
 	Type    string `json:"type"`
 	Message struct {
@@ -44,17 +40,60 @@ type ClaudeStartResponse struct {
 }
 
 type ClaudeDelta struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+	Type        string `json:"type"`
+	Text        string `json:"text"`
+	Thinking    string `json:"thinking,omitempty"`
+	PartialJSON string `json:"partial_json,omitempty"`
+	StopReason  string `json:"stop_reason,omitempty"`
+}
+
+type ClaudeUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// ClaudeStreamEvent 上游 messages 流式接口的通用事件
+type ClaudeStreamEvent struct {
+	Type  string       `json:"type"`
+	Index int          `json:"index"`
+	Delta *ClaudeDelta `json:"delta"`
+	Usage *ClaudeUsage `json:"usage"`
+	// ContentBlock content_block_start 中新开的块，tool_use 块带 id 和 name
+	ContentBlock *struct {
+		Type  string          `json:"type"`
+		ID    string          `json:"id"`
+		Name  string          `json:"name"`
+		Input json.RawMessage `json:"input"`
+	} `json:"content_block"`
+	Message *struct {
+		Usage ClaudeUsage `json:"usage"`
+	} `json:"message"`
+	Error *struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
 }
 
 // Claude 请求体
 type ClaudeRequest struct {
-	Model       string              `json:"model"`
-	Messages    []ClaudeMessageItem `json:"messages"`
-	Stream      bool                `json:"stream"`
-	MaxTokens   int                 `json:"max_tokens"`
-	Temperature float32             `json:"temperature"`
+	Model         string              `json:"model"`
+	System        string              `json:"system,omitempty"`
+	Messages      []ClaudeMessageItem `json:"messages"`
+	Stream        bool                `json:"stream"`
+	MaxTokens     int                 `json:"max_tokens"`
+	Temperature   *float32            `json:"temperature,omitempty"`
+	TopP          float32             `json:"top_p,omitempty"`
+	TopK          *int                `json:"top_k,omitempty"`
+	StopSequences []string            `json:"stop_sequences,omitempty"`
+	Thinking      *ClaudeThinking     `json:"thinking,omitempty"`
+	Tools         []ClaudeTool        `json:"tools,omitempty"`
+	ToolChoice    *ClaudeToolChoice   `json:"tool_choice,omitempty"`
+}
+
+// ClaudeThinking 扩展思考配置，type 为 enabled / disabled
+type ClaudeThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
 }
 
 type ClaudeMessageItem struct {
@@ -62,138 +101,693 @@ type ClaudeMessageItem struct {
 	Content []ClaudeMessageContent `json:"content"`
 }
 
+// ClaudeMessageContent 发往上游的内容块：text / image / tool_use / tool_result
 type ClaudeMessageContent struct {
-	Type string `json:"type"`
-	Text string `json:"text"`
+	Type      string             `json:"type"`
+	Text      string             `json:"text,omitempty"`
+	Source    *ClaudeImageSource `json:"source,omitempty"`
+	ID        string             `json:"id,omitempty"`
+	Name      string             `json:"name,omitempty"`
+	Input     json.RawMessage    `json:"input,omitempty"`
+	ToolUseID string             `json:"tool_use_id,omitempty"`
+	Content   string             `json:"content,omitempty"`
 }
 
+// ClaudeImageSource 图片来源，type 为 base64 时带 media_type 和 data，为 url 时带 url
+type ClaudeImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+// ToClaudeRequest Ollama 请求经中立请求转换，options 中的采样参数一并生效
 func ToClaudeRequest(input *OllamaChatRequest) *ClaudeRequest {
-	claudeReq := ClaudeRequest{
-		Model:     input.Model, //XConfig.Model,
-		Messages:  toClaudeRequest(input.Messages),
-		Stream:    true,
-		MaxTokens: 1024,
+	req := OllamaToChatRequest(input)
+	req, _ = adaptSampling(req, claudeSampling(req))
+	return ChatToClaudeRequest(req)
+}
+
+// ClaudeProvider 通过 Anthropic messages 接口对话
+type ClaudeProvider struct {
+	URL    string
+	APIKey string
+}
+
+func (p *ClaudeProvider) ChatStream(ctx context.Context, req *ChatCompletionRequest, emit func(ChatEvent) error) error {
+	if structuredOutputRequested(req) {
+		return emulateStructuredOutput(ctx, p, req, emit)
+	}
+	req, ignored := adaptSampling(req, claudeSampling(req))
+	if err := reportIgnoredParams(emit, ignored); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(ChatToClaudeRequest(req))
+	if err != nil {
+		return err
 	}
-	return &claudeReq
+	header := http.Header{}
+	header.Set("x-api-key", p.APIKey)
+	header.Set("Authorization", "Bearer "+p.APIKey)
+	header.Set("anthropic-version", "2023-06-01")
+	resp, err := postUpstream(ctx, p.URL, payload, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	state := claudeStreamState{}
+	return readSSE(resp.Body, func(ev sse.Event) error {
+		return state.handle(ev.Event, []byte(ev.Data), emit)
+	})
+}
+
+// claudeDefaultMaxTokens Anthropic 必须指定 max_tokens，客户端未指定时使用
+const claudeDefaultMaxTokens = 4096
+
+// ChatToClaudeRequest 中立请求转为 Anthropic messages 请求，采样参数需先经 adaptSampling 处理
+func ChatToClaudeRequest(req *ChatCompletionRequest) *ClaudeRequest {
+	maxTokens := req.MaxTokens
+	if req.MaxCompletionTokens > 0 {
+		maxTokens = req.MaxCompletionTokens
+	}
+	if maxTokens == 0 {
+		maxTokens = claudeDefaultMaxTokens
+	}
+	system, messages := splitSystem(req.Messages)
+	out := &ClaudeRequest{
+		Model:         req.Model,
+		System:        system,
+		Messages:      GpttoClaudeRequest(messages),
+		Stream:        true,
+		MaxTokens:     maxTokens,
+		Temperature:   req.Temperature,
+		TopP:          req.TopP,
+		TopK:          req.TopK,
+		StopSequences: req.Stop,
+	}
+	out.Tools, out.ToolChoice = claudeTools(req)
+	// 开启思考时 max_tokens 必须大于预算，temperature 只能为 1，tool_choice 只能为 auto / none
+	if budget, ok := reasoningBudget(req); ok && budget > 0 {
+		out.Thinking = &ClaudeThinking{Type: "enabled", BudgetTokens: budget}
+		if out.MaxTokens <= budget {
+			out.MaxTokens += budget
+		}
+		one := float32(1)
+		out.Temperature = &one
+		if choice := out.ToolChoice; choice != nil && (choice.Type == "any" || choice.Type == "tool") {
+			choice.Type, choice.Name = "auto", ""
+		}
+	}
+	// 只有 system 时作为用户输入发送，messages 不能为空
+	if len(out.Messages) == 0 && system != "" {
+		out.System = ""
+		out.Messages = []ClaudeMessageItem{{Role: ChatMessageRoleUser, Content: []ClaudeMessageContent{{Type: "text", Text: system}}}}
+	}
+	return out
 }
 
-func ClaudeBlockToOllamaResponse(input []byte, req *OllamaChatRequest) (*OllamaResponse, error) {
-	msg := OllamaResponse{}
-	claudeResponse := ClaudeBlockResponse{}
-	if len(input) < 6 {
+// claudeTools OpenAI 格式的 tools / tool_choice / parallel_tool_calls 转为 Anthropic 格式，没有工具时都不发送
+func claudeTools(req *ChatCompletionRequest) ([]ClaudeTool, *ClaudeToolChoice) {
+	var tools []ClaudeTool
+	for _, tool := range req.Tools {
+		if tool.Function == nil {
+			continue
+		}
+		// input_schema 是必填项
+		schema := tool.Function.Parameters
+		if schema == nil {
+			schema = map[string]interface{}{"type": "object"}
+		}
+		tools = append(tools, ClaudeTool{Name: tool.Function.Name, Description: tool.Function.Description, InputSchema: schema})
+	}
+	if len(tools) == 0 {
 		return nil, nil
 	}
-	log.Println("Received request:", string(input[6:]))
-	err := json.Unmarshal(input[6:], &claudeResponse)
+	var choice *ClaudeToolChoice
+	switch v := req.ToolChoice.(type) {
+	case nil:
+	case string:
+		switch v {
+		case "auto", "none":
+			choice = &ClaudeToolChoice{Type: v}
+		case "required":
+			choice = &ClaudeToolChoice{Type: "any"}
+		}
+	default:
+		// {"type":"function","function":{"name":"..."}}
+		var named struct {
+			Function struct {
+				Name string `json:"name"`
+			} `json:"function"`
+		}
+		data, _ := json.Marshal(v)
+		if json.Unmarshal(data, &named) == nil && named.Function.Name != "" {
+			choice = &ClaudeToolChoice{Type: "tool", Name: named.Function.Name}
+		}
+	}
+	if parallel, ok := req.ParallelToolCalls.(bool); ok && !parallel {
+		if choice == nil {
+			choice = &ClaudeToolChoice{Type: "auto"}
+		}
+		if choice.Type != "none" {
+			choice.DisableParallelToolUse = true
+		}
+	}
+	return tools, choice
+}
+
+// claudeToolInput tool_use 的 input 必须是 JSON 对象，参数为空或无法解析时发送空对象
+func claudeToolInput(arguments string) json.RawMessage {
+	arguments = strings.TrimSpace(arguments)
+	if !strings.HasPrefix(arguments, "{") || !json.Valid([]byte(arguments)) {
+		return json.RawMessage("{}")
+	}
+	return json.RawMessage(arguments)
+}
+
+// chatPartToClaude 图片分片中 data URL 转为 base64 来源，其它 URL 按 url 来源发送
+func chatPartToClaude(part ChatMessagePart) (ClaudeMessageContent, bool) {
+	if part.ImageURL == nil {
+		if part.Text == "" {
+			return ClaudeMessageContent{}, false
+		}
+		return ClaudeMessageContent{Type: "text", Text: part.Text}, true
+	}
+	url := part.ImageURL.URL
+	if strings.HasPrefix(url, "data:") {
+		meta, data, ok := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+		if !ok {
+			return ClaudeMessageContent{}, false
+		}
+		source := &ClaudeImageSource{Type: "base64", MediaType: strings.TrimSuffix(meta, ";base64"), Data: data}
+		return ClaudeMessageContent{Type: "image", Source: source}, true
+	}
+	return ClaudeMessageContent{Type: "image", Source: &ClaudeImageSource{Type: "url", URL: url}}, true
+}
+
+// claudeStreamState 解析 Anthropic 流式事件，Anthropic 直连与 Bedrock 共用
+type claudeStreamState struct {
+	usage Usage
+	// tools 进行中的 tool_use 块，按块序号拼接参数，块结束时作为完整的工具调用发出
+	tools map[int]*ToolCall
+}
+
+// handle name 为 SSE 的 event 字段，data 中没有 type 时以它为准（Bedrock 的事件没有 event 字段，传空）
+func (s *claudeStreamState) handle(name string, data []byte, emit func(ChatEvent) error) error {
+	event := ClaudeStreamEvent{}
+	if err := json.Unmarshal(data, &event); err != nil {
+		log.Println("Unmarshal error:", err)
+		return nil
+	}
+	if event.Type == "" {
+		event.Type = name
+	}
+	switch event.Type {
+	case "message_start":
+		if event.Message != nil {
+			s.usage.PromptTokens = event.Message.Usage.InputTokens
+		}
+	case "content_block_start":
+		if block := event.ContentBlock; block != nil && block.Type == "tool_use" {
+			if s.tools == nil {
+				s.tools = map[int]*ToolCall{}
+			}
+			s.tools[event.Index] = &ToolCall{ID: block.ID, Type: "function", Function: FunctionCall{Name: block.Name}}
+		}
+	case "content_block_delta":
+		if event.Delta == nil {
+			return nil
+		}
+		if event.Delta.Type == "input_json_delta" {
+			if call := s.tools[event.Index]; call != nil {
+				call.Function.Arguments += event.Delta.PartialJSON
+			}
+			return nil
+		}
+		if event.Delta.Type == "thinking_delta" && event.Delta.Thinking != "" {
+			return emit(ChatEvent{Reasoning: event.Delta.Thinking})
+		}
+		if event.Delta.Text != "" {
+			return emit(ChatEvent{Content: event.Delta.Text})
+		}
+	case "content_block_stop":
+		call := s.tools[event.Index]
+		if call == nil {
+			return nil
+		}
+		delete(s.tools, event.Index)
+		if call.Function.Arguments == "" {
+			call.Function.Arguments = "{}"
+		}
+		return emit(ChatEvent{ToolCalls: []ToolCall{*call}})
+	case "message_delta":
+		if event.Usage != nil {
+			s.usage.CompletionTokens = event.Usage.OutputTokens
+		}
+		s.usage.TotalTokens = s.usage.PromptTokens + s.usage.CompletionTokens
+		if event.Delta != nil && event.Delta.StopReason != "" {
+			usage := s.usage
+			return emit(ChatEvent{FinishReason: claudeStopReason(event.Delta.StopReason), Usage: &usage})
+		}
+	case "error":
+		if event.Error != nil {
+			return fmt.Errorf("claude error: %s", event.Error.Message)
+		}
+	}
+	return nil
+}
+
+// claudeStopReason Anthropic stop_reason 转为 OpenAI finish_reason
+func claudeStopReason(reason string) FinishReason {
+	switch reason {
+	case "max_tokens":
+		return FinishReasonLength
+	case "tool_use":
+		return FinishReasonToolCalls
+	default:
+		return FinishReasonStop
+	}
+}
+
+// ClaudeMessagesRequest Anthropic /v1/messages 入站请求
+type ClaudeMessagesRequest struct {
+	Model         string               `json:"model"`
+	System        json.RawMessage      `json:"system,omitempty"` // 字符串或 text 块数组
+	Messages      []ClaudeInputMessage `json:"messages"`
+	MaxTokens     int                  `json:"max_tokens"`
+	Temperature   *float32             `json:"temperature,omitempty"`
+	TopP          *float32             `json:"top_p,omitempty"`
+	TopK          *int                 `json:"top_k,omitempty"`
+	StopSequences []string             `json:"stop_sequences,omitempty"`
+	Stream        bool                 `json:"stream,omitempty"`
+	Tools         []ClaudeTool         `json:"tools,omitempty"`
+	ToolChoice    *ClaudeToolChoice    `json:"tool_choice,omitempty"`
+	Thinking      *ClaudeThinking      `json:"thinking,omitempty"`
+}
+
+type ClaudeInputMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"` // 字符串或内容块数组
+}
+
+// ClaudeContentBlock 入站消息中的内容块：text / image / tool_use / tool_result / thinking
+type ClaudeContentBlock struct {
+	Type      string             `json:"type"`
+	Text      string             `json:"text,omitempty"`
+	ID        string             `json:"id,omitempty"`
+	Name      string             `json:"name,omitempty"`
+	Input     json.RawMessage    `json:"input,omitempty"`
+	ToolUseID string             `json:"tool_use_id,omitempty"`
+	Content   json.RawMessage    `json:"content,omitempty"`
+	Source    *ClaudeImageSource `json:"source,omitempty"`
+}
+
+type ClaudeTool struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	InputSchema interface{} `json:"input_schema,omitempty"`
+}
+
+type ClaudeToolChoice struct {
+	Type                   string `json:"type"` // auto / any / tool / none
+	Name                   string `json:"name,omitempty"`
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use,omitempty"`
+}
+
+// claudeBlocks 内容可以是字符串或内容块数组，字符串视为一个 text 块
+func claudeBlocks(raw json.RawMessage) ([]ClaudeContentBlock, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return []ClaudeContentBlock{{Type: "text", Text: text}}, nil
+	}
+	var blocks []ClaudeContentBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return nil, fmt.Errorf("content must be a string or an array of content blocks")
+	}
+	return blocks, nil
+}
+
+// claudeBlocksText 拼接内容块中的文本，用于 system 和 tool_result
+func claudeBlocksText(raw json.RawMessage) (string, error) {
+	blocks, err := claudeBlocks(raw)
+	if err != nil {
+		return "", err
+	}
+	var texts []string
+	for _, block := range blocks {
+		if block.Type == "text" {
+			texts = append(texts, block.Text)
+		}
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// ClaudeToChatRequest Anthropic 请求转为中立请求；thinking 块不回传给上游（签名只对 Anthropic 有效）
+func ClaudeToChatRequest(in *ClaudeMessagesRequest) (*ChatCompletionRequest, error) {
+	req := &ChatCompletionRequest{Model: in.Model, MaxTokens: in.MaxTokens, Stop: in.StopSequences, Stream: true}
+	req.Temperature = in.Temperature
+	if in.TopP != nil {
+		req.TopP = *in.TopP
+	}
+	req.TopK = in.TopK
+	if in.Thinking != nil {
+		switch in.Thinking.Type {
+		case "enabled":
+			req.ThinkingBudget = in.Thinking.BudgetTokens
+		case "disabled":
+			req.ReasoningEffort = reasoningEffortNone
+		}
+	}
+	system, err := claudeBlocksText(in.System)
 	if err != nil {
 		return nil, err
 	}
-	if claudeResponse.Type == "content_block_stop" || claudeResponse.Type == "message_stop" {
-		msg.Done = true
-		msg.Model = req.Model
-		msg.CreatedAt = time.Now().UTC().Format(time.RFC3339Nano)
-		msg.Message = OllamaMessage{
-			Role:    "assistant",
-			Content: "",
-		}
-		msg.DoneReason = "stop"
-		msg.TotalDuration = 13937866250
-		msg.LoadDuration = 5978299625
-		msg.PromptEvalCount = 9
-		msg.PromptEvalDuration = 3912791542
-		msg.EvalCount = 12
-		msg.EvalDuration = 10937866250
-		return &msg, err
-	}
-	if claudeResponse.Type == "message_start" || claudeResponse.Type == "content_block_start" {
-		msg.Done = false
+	if system != "" {
+		req.Messages = append(req.Messages, ChatCompletionMessage{Role: ChatMessageRoleSystem, Content: system})
+	}
+	for _, m := range in.Messages {
+		blocks, err := claudeBlocks(m.Content)
+		if err != nil {
+			return nil, err
+		}
+		msg := ChatCompletionMessage{Role: m.Role}
+		var parts []ChatMessagePart
+		for _, block := range blocks {
+			switch block.Type {
+			case "text":
+				parts = append(parts, ChatMessagePart{Type: "text", Text: block.Text})
+			case "image":
+				if block.Source == nil {
+					continue
+				}
+				url := block.Source.URL
+				if block.Source.Type == "base64" {
+					url = "data:" + block.Source.MediaType + ";base64," + block.Source.Data
+				}
+				parts = append(parts, ChatMessagePart{Type: "image_url", ImageURL: &ChatMessageImageURL{URL: url}})
+			case "tool_use":
+				args := string(block.Input)
+				if args == "" {
+					args = "{}"
+				}
+				msg.ToolCalls = append(msg.ToolCalls, ToolCall{ID: block.ID, Type: "function", Function: FunctionCall{Name: block.Name, Arguments: args}})
+			case "tool_result":
+				// 工具结果在 OpenAI 格式中是独立的 tool 消息，放在同一轮用户输入之前
+				output, err := claudeBlocksText(block.Content)
+				if err != nil {
+					return nil, err
+				}
+				req.Messages = append(req.Messages, ChatCompletionMessage{Role: ChatMessageRoleTool, ToolCallID: block.ToolUseID, Content: output})
+			}
+		}
+		if len(parts) == 0 && len(msg.ToolCalls) == 0 {
+			continue
+		}
+		msg.Content = messageText(parts)
+		for _, part := range parts {
+			if part.Type != "text" {
+				msg.Content = parts
+				break
+			}
+		}
+		req.Messages = append(req.Messages, msg)
+	}
+	for _, tool := range in.Tools {
+		req.Tools = append(req.Tools, Tool{Type: "function", Function: &FunctionDefinition{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  tool.InputSchema,
+		}})
+	}
+	if choice := in.ToolChoice; choice != nil {
+		switch choice.Type {
+		case "auto", "none":
+			req.ToolChoice = choice.Type
+		case "any":
+			req.ToolChoice = "required"
+		case "tool":
+			req.ToolChoice = map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": choice.Name}}
+		}
+		if choice.DisableParallelToolUse {
+			req.ParallelToolCalls = false
+		}
+	}
+	return req, nil
+}
+
+// claudeFinishStopReason OpenAI finish_reason 转为 Anthropic stop_reason
+func claudeFinishStopReason(reason FinishReason) string {
+	switch reason {
+	case FinishReasonLength:
+		return "max_tokens"
+	case FinishReasonToolCalls, FinishReasonFunctionCall:
+		return "tool_use"
+	case FinishReasonContentFilter:
+		return "refusal"
+	default:
+		return "end_turn"
+	}
+}
+
+// claudeMessageBuilder 把中立事件组装为 Anthropic 内容块；write 为 true 时同时推送流式事件
+type claudeMessageBuilder struct {
+	c       *gin.Context
+	write   bool
+	started bool
+	id      string
+	model   string
+
+	blocks []gin.H
+	open   string // 当前打开的块类型：thinking / text
+	buf    strings.Builder
+
+	toolCalls []ToolCall
+	reason    FinishReason
+	usage     Usage
+}
+
+func (b *claudeMessageBuilder) send(event string, payload gin.H) error {
+	if !b.write {
+		return nil
+	}
+	if !b.started {
+		b.started = true
+		b.c.Header("content-Type", "text/event-stream")
+		b.c.Header("cache-control", "no-cache")
+		b.c.Header("Connection", "keep-alive")
+		start := gin.H{"type": "message_start", "message": gin.H{
+			"id": b.id, "type": "message", "role": "assistant", "model": b.model, "content": []gin.H{},
+			"stop_reason": nil, "stop_sequence": nil, "usage": gin.H{"input_tokens": 0, "output_tokens": 0},
+		}}
+		if err := EventData(b.c, "message_start", start); err != nil {
+			return err
+		}
+	}
+	payload["type"] = event
+	return EventData(b.c, event, payload)
+}
+
+// openBlock 切换到指定类型的块，类型不同时先关闭当前块
+func (b *claudeMessageBuilder) openBlock(kind string) error {
+	if b.open == kind {
+		return nil
+	}
+	if err := b.closeBlock(); err != nil {
+		return err
+	}
+	b.open = kind
+	b.buf.Reset()
+	block := gin.H{"type": kind, kind: ""}
+	if kind == "thinking" {
+		block["signature"] = ""
+	}
+	return b.send("content_block_start", gin.H{"index": len(b.blocks), "content_block": block})
+}
+
+func (b *claudeMessageBuilder) closeBlock() error {
+	if b.open == "" {
+		return nil
+	}
+	block := gin.H{"type": b.open, b.open: b.buf.String()}
+	if b.open == "thinking" {
+		block["signature"] = ""
+	}
+	b.open = ""
+	b.blocks = append(b.blocks, block)
+	return b.send("content_block_stop", gin.H{"index": len(b.blocks) - 1})
+}
+
+func (b *claudeMessageBuilder) delta(kind, text string) error {
+	if err := b.openBlock(kind); err != nil {
+		return err
+	}
+	b.buf.WriteString(text)
+	return b.send("content_block_delta", gin.H{"index": len(b.blocks), "delta": gin.H{"type": kind + "_delta", kind: text}})
+}
+
+func (b *claudeMessageBuilder) add(ev ChatEvent) error {
+	if ev.Usage != nil {
+		b.usage = *ev.Usage
+	}
+	if ev.FinishReason != "" {
+		b.reason = ev.FinishReason
+	}
+	if ev.Reasoning != "" {
+		if err := b.delta("thinking", ev.Reasoning); err != nil {
+			return err
+		}
+	}
+	if ev.Content != "" {
+		if err := b.delta("text", ev.Content); err != nil {
+			return err
+		}
+	}
+	b.toolCalls = append(b.toolCalls, ev.ToolCalls...)
+	return nil
+}
+
+// finish 关闭打开的块，输出工具调用和结束事件，返回非流式响应体
+func (b *claudeMessageBuilder) finish() (gin.H, error) {
+	if err := b.closeBlock(); err != nil {
+		return nil, err
+	}
+	for _, call := range b.toolCalls {
+		id := call.ID
+		if id == "" {
+			id = "toolu_" + RandString(24)
+		}
+		input := json.RawMessage(call.Function.Arguments)
+		if !json.Valid(input) {
+			input = json.RawMessage("{}")
+		}
+		index := len(b.blocks)
+		if err := b.send("content_block_start", gin.H{"index": index, "content_block": gin.H{"type": "tool_use", "id": id, "name": call.Function.Name, "input": gin.H{}}}); err != nil {
+			return nil, err
+		}
+		if err := b.send("content_block_delta", gin.H{"index": index, "delta": gin.H{"type": "input_json_delta", "partial_json": string(input)}}); err != nil {
+			return nil, err
+		}
+		b.blocks = append(b.blocks, gin.H{"type": "tool_use", "id": id, "name": call.Function.Name, "input": input})
+		if err := b.send("content_block_stop", gin.H{"index": index}); err != nil {
+			return nil, err
+		}
+	}
+	reason := b.reason
+	if reason == "" && len(b.toolCalls) > 0 {
+		reason = FinishReasonToolCalls
+	}
+	stopReason := claudeFinishStopReason(reason)
+	usage := gin.H{"input_tokens": b.usage.PromptTokens, "output_tokens": b.usage.CompletionTokens}
+	if err := b.send("message_delta", gin.H{"delta": gin.H{"stop_reason": stopReason, "stop_sequence": nil}, "usage": usage}); err != nil {
 		return nil, err
 	}
-	if claudeResponse.Delta == nil {
+	if err := b.send("message_stop", gin.H{}); err != nil {
 		return nil, err
 	}
-	msg.Model = req.Model
-	msg.CreatedAt = time.Now().UTC().Format(time.RFC3339)
-	msg.Message = OllamaMessage{
-		Role:    "assistant",
-		Content: claudeResponse.Delta.Text,
+	content := b.blocks
+	if content == nil {
+		content = []gin.H{}
+	}
+	return gin.H{
+		"id": b.id, "type": "message", "role": "assistant", "model": b.model, "content": content,
+		"stop_reason": stopReason, "stop_sequence": nil, "usage": usage,
+	}, nil
+}
+
+// claudeError Anthropic 格式的错误响应
+func claudeError(c *gin.Context, code int, errType, message string) {
+	c.JSON(code, gin.H{"type": "error", "error": gin.H{"type": errType, "message": message}})
+}
+
+func claudeUpstreamError(c *gin.Context, err error) {
+	log.Println("Request error:", err)
+	var upstream *UpstreamError
+	if errors.As(err, &upstream) {
+		// 上游本身是 Anthropic 时错误格式一致，原样返回
+		var body struct {
+			Type string `json:"type"`
+		}
+		if json.Unmarshal([]byte(upstream.Body), &body) == nil && body.Type == "error" {
+			c.Data(upstream.StatusCode, "application/json", []byte(upstream.Body))
+			return
+		}
+		claudeError(c, upstream.StatusCode, "api_error", upstream.Body)
+		return
 	}
-	msg.Done = false
-	return &msg, nil
+	claudeError(c, http.StatusBadGateway, "api_error", err.Error())
 }
 
+// ClaudeHandlerSteam POST /claude/v1/messages，Anthropic Messages 接口，后端可以是任意可路由的模型
 func ClaudeHandlerSteam(c *gin.Context) {
-	var input ClaudeRequest
+	var input ClaudeMessagesRequest
 	if err := c.ShouldBindJSON(&input); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		claudeError(c, http.StatusBadRequest, "invalid_request_error", "Invalid request: "+err.Error())
 		return
 	}
 
 	if XConfig != nil && XConfig.Mock {
-		//c.Header("content-Type", "application/x-ndjson")
 		c.Header("content-Type", "application/json")
-		//c.Header("content-Type", "text/event-stream")
 		c.Header("cache-control", "no-cache")
 		c.Header("Connection", "keep-alive")
-		//c.Writer.WriteHeader(http.StatusOK)
-		//jsonStr, err := json.Marshal(MockGPTResponse())
-		//if err != nil {
-		//	log.Println("Encode error:", err)
-		//}
-		//_, _ = c.Writer.Write(jsonStr)
 		c.JSON(200, MockGPTResponse())
 		return
 	}
 
-	req, err := http.NewRequest("POST", XConfig.APIURL, bytes.NewBuffer([]byte{}))
+	if input.Stream && resumeGeneration(c) {
+		return
+	}
+	req, err := ClaudeToChatRequest(&input)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		claudeError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
 	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("anthropic-version", "2023-06-01")
-	req.Header.Set("x-api-key", "no-cache")
-
-	// 发起请求
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	provider, upstreamModel, err := selectProvider(input.Model)
 	if err != nil {
-		log.Println("Request error:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "API request failed"})
+		claudeError(c, http.StatusBadRequest, "invalid_request_error", err.Error())
 		return
 	}
-	defer resp.Body.Close()
-	scanner := bufio.NewScanner(resp.Body)
-	scanner.Buffer(make([]byte, InitialScannerBufferSize), MaxScannerBufferSize)
-	scanner.Split(bufio.ScanLines)
-
-	for scanner.Scan() {
-		data := scanner.Text()
-		if XConfig.Debug {
-			println(data)
-		}
-		if len(data) < 6 {
-			continue
-		}
-		if data[:5] != "data:" && data[:6] != "[DONE]" {
-			continue
-		}
-		data = data[5:]
-		data = strings.TrimLeft(data, " ")
-		data = strings.TrimSuffix(data, "\r")
-
-		if !strings.HasPrefix(data, "[DONE]") {
-			log.Println("Received data:", string(data))
-			// success := dataHandler(data)
-			// if !success {
-			// 	break
-			// }
+	if input.Stream {
+		if gen := startGeneration(c); gen != nil {
+			defer gen.finish()
 		}
 	}
+	provider = withThinkTags(c, input.Model, withContinuation(c, input.Model, provider))
+	req.Model = upstreamModel
 
-	if err := scanner.Err(); err != nil {
-		if err != io.EOF {
-			log.Println("scanner error: " + err.Error())
-		}
+	b := &claudeMessageBuilder{c: c, write: input.Stream, id: "msg_" + RandString(24), model: input.Model}
+	watch := watchClient(c, input.Model)
+	if input.Stream {
+		watch.keepalive(func() error {
+			return b.send("ping", gin.H{})
+		})
+	}
+	err = provider.ChatStream(watch.ctx, req, watch.emit(func(ev ChatEvent) error {
+		applyEventHeader(c, ev.Header)
+		return b.add(ev)
+	}))
+	if watch.gone() {
+		return
+	}
+	err = watch.failure(err)
+	if err != nil && !b.started {
+		claudeUpstreamError(c, err)
+		return
+	}
+	if err != nil {
+		log.Println("Stream error:", err)
+		_ = b.send("error", gin.H{"error": gin.H{"type": "api_error", "message": err.Error()}})
+		return
+	}
+	msg, err := b.finish()
+	if err != nil {
+		log.Println("Stream error:", err)
+		return
+	}
+	if !input.Stream {
+		c.JSON(http.StatusOK, msg)
 	}
-
 }
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestClaudeMessagesTools(t *testing.T) {
+	server := newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		writeSSE(w,
+			openaiContent("Checking", ""),
+			openaiChunk(`{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Paris\"}"}}]}`, "tool_calls"),
+			openaiUsage(5, 3),
+			"[DONE]")
+	})
+	defer server.Close()
+
+	XConfig = &Config{
+		ChatType:  "dify",
+		Providers: []ProviderConfig{{Name: "local", Type: "openai", BaseUrl: server.URL, Models: []string{"coder"}}},
+	}
+	defer func() { XConfig = nil }()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/claude/v1/messages", ClaudeHandlerSteam)
+
+	request := `{"model":"coder","max_tokens":1024,"stream":%s,
+		"system":"be brief",
+		"tools":[{"name":"weather","description":"current weather","input_schema":{"type":"object"}}],
+		"tool_choice":{"type":"any"},
+		"messages":[
+			{"role":"user","content":[{"type":"text","text":"weather here?"},{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBO"}}]},
+			{"role":"assistant","content":[{"type":"tool_use","id":"toolu_0","name":"weather","input":{"city":"Rome"}}]},
+			{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_0","content":[{"type":"text","text":"sunny"}]},{"type":"text","text":"and Paris?"}]}
+		]}`
+
+	// 非流式：请求按 OpenAI 格式转发，工具调用作为 tool_use 块返回
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/claude/v1/messages", strings.NewReader(fmt.Sprintf(request, "false"))))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	sent := server.bodies()[0]
+	messages := sent["messages"].([]interface{})
+	var roles []string
+	for _, m := range messages {
+		roles = append(roles, m.(map[string]interface{})["role"].(string))
+	}
+	if strings.Join(roles, ",") != "system,user,assistant,tool,user" || sent["tool_choice"] != "required" {
+		t.Fatalf("roles = %v, tool_choice = %v", roles, sent["tool_choice"])
+	}
+	image := messages[1].(map[string]interface{})["content"].([]interface{})[1].(map[string]interface{})
+	call := messages[2].(map[string]interface{})["tool_calls"].([]interface{})[0].(map[string]interface{})
+	result := messages[3].(map[string]interface{})
+	if image["image_url"].(map[string]interface{})["url"] != "data:image/png;base64,iVBO" ||
+		call["function"].(map[string]interface{})["arguments"] != `{"city":"Rome"}` ||
+		result["tool_call_id"] != "toolu_0" || result["content"] != "sunny" {
+		t.Fatalf("messages = %v", messages)
+	}
+	tools := sent["tools"].([]interface{})
+	if fn := tools[0].(map[string]interface{})["function"].(map[string]interface{}); fn["name"] != "weather" || fn["description"] != "current weather" {
+		t.Fatalf("tools = %v", tools)
+	}
+
+	var msg struct {
+		Content []map[string]interface{} `json:"content"`
+		Stop    string                   `json:"stop_reason"`
+		Usage   map[string]int           `json:"usage"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &msg)
+	if len(msg.Content) != 2 || msg.Content[0]["text"] != "Checking" || msg.Content[1]["type"] != "tool_use" ||
+		msg.Content[1]["input"].(map[string]interface{})["city"] != "Paris" || msg.Stop != "tool_use" || msg.Usage["output_tokens"] != 3 {
+		t.Fatalf("message = %s", w.Body.String())
+	}
+
+	// 流式：按 Anthropic 事件顺序推送
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/claude/v1/messages", strings.NewReader(fmt.Sprintf(request, "true"))))
+	var events []string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(line, "event: ") {
+			events = append(events, strings.TrimPrefix(line, "event: "))
+		}
+	}
+	want := "message_start,content_block_start,content_block_delta,content_block_stop," +
+		"content_block_start,content_block_delta,content_block_stop,message_delta,message_stop"
+	if strings.Join(events, ",") != want || !strings.Contains(w.Body.String(), `"partial_json":"{\"city\":\"Paris\"}"`) {
+		t.Fatalf("stream:\n%s", w.Body.String())
+	}
+
+	// Anthropic 格式的错误
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/claude/v1/messages", strings.NewReader(`{"model":"coder","messages":[{"role":"user","content":1}]}`)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `"type":"invalid_request_error"`) {
+		t.Fatalf("invalid content: %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestClaudeProviderTools(t *testing.T) {
+	server := newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		writeClaudeSSE(w,
+			`{"type":"message_start","message":{"usage":{"input_tokens":9}}}`,
+			`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Checking"}}`,
+			`{"type":"content_block_stop","index":0}`,
+			`{"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"weather","input":{}}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":"}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"\"Paris\"}"}}`,
+			`{"type":"content_block_stop","index":1}`,
+			`{"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":7}}`)
+	})
+	defer server.Close()
+
+	XConfig = &Config{ChatType: "claude", APIURL: server.URL}
+	defer func() { XConfig = nil }()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/chat/completions", OpenaiHandler)
+
+	body := `{"model":"claude-sonnet-4","stream":false,
+		"tools":[{"type":"function","function":{"name":"weather","description":"current weather","parameters":{"type":"object"}}}],
+		"tool_choice":{"type":"function","function":{"name":"weather"}},
+		"parallel_tool_calls":false,
+		"messages":[
+			{"role":"user","content":[{"type":"text","text":"weather here?"},{"type":"image_url","image_url":{"url":"data:image/png;base64,iVBO"}}]},
+			{"role":"assistant","tool_calls":[{"id":"toolu_0","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Rome\"}"}}]},
+			{"role":"tool","tool_call_id":"toolu_0","content":"sunny"},
+			{"role":"user","content":"and Paris?"}
+		]}`
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	// 上游收到 Anthropic 格式的工具、工具调用、工具结果和图片
+	sent := standInBodies[ClaudeRequest](server)[0]
+	if len(sent.Tools) != 1 || sent.Tools[0].Name != "weather" || sent.Tools[0].Description != "current weather" {
+		t.Fatalf("tools = %+v", sent.Tools)
+	}
+	if choice := sent.ToolChoice; choice == nil || choice.Type != "tool" || choice.Name != "weather" || !choice.DisableParallelToolUse {
+		t.Fatalf("tool_choice = %+v", sent.ToolChoice)
+	}
+	data, _ := json.Marshal(sent.Messages)
+	want := `[{"role":"user","content":[{"type":"text","text":"weather here?"},{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBO"}}]},` +
+		`{"role":"assistant","content":[{"type":"tool_use","id":"toolu_0","name":"weather","input":{"city":"Rome"}}]},` +
+		`{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_0","content":"sunny"},{"type":"text","text":"and Paris?"}]}]`
+	if string(data) != want {
+		t.Fatalf("messages = %s", data)
+	}
+
+	// tool_use 块按 input_json_delta 拼接为完整的工具调用
+	var resp ChatCompletionResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Choices) != 1 || resp.Choices[0].FinishReason != FinishReasonToolCalls {
+		t.Fatalf("response = %s", w.Body.String())
+	}
+	calls := resp.Choices[0].Message.ToolCalls
+	if resp.Choices[0].Message.Content != "Checking" || len(calls) != 1 || calls[0].ID != "toolu_1" ||
+		calls[0].Function.Name != "weather" || calls[0].Function.Arguments != `{"city":"Paris"}` {
+		t.Fatalf("response = %s", w.Body.String())
+	}
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fimDefaultMaxTokens 补全请求未指定 max_tokens 时的默认值，行内补全以延迟为先
+const fimDefaultMaxTokens = 256
+
+// errStopSequence 输出命中 stop 时用于提前结束上游读取
+var errStopSequence = errors.New("stop sequence reached")
+
+// stopFilter 在代理侧按 stop 截断流式输出；末尾可能是 stop 前缀的部分先暂存，等下一个分片再决定
+type stopFilter struct {
+	stops   []string
+	held    string
+	stopped bool
+}
+
+// push 追加分片，返回可以安全输出的文本
+func (f *stopFilter) push(s string) string {
+	if f.stopped {
+		return ""
+	}
+	f.held += s
+	cut := -1
+	for _, stop := range f.stops {
+		if stop == "" {
+			continue
+		}
+		if i := strings.Index(f.held, stop); i >= 0 && (cut < 0 || i < cut) {
+			cut = i
+		}
+	}
+	if cut >= 0 {
+		out := f.held[:cut]
+		f.held = ""
+		f.stopped = true
+		return out
+	}
+	keep := 0
+	for _, stop := range f.stops {
+		for n := len(stop) - 1; n > keep; n-- {
+			if strings.HasSuffix(f.held, stop[:n]) {
+				keep = n
+				break
+			}
+		}
+	}
+	out := f.held[:len(f.held)-keep]
+	f.held = f.held[len(f.held)-keep:]
+	return out
+}
+
+// flush 上游结束时输出暂存的文本
+func (f *stopFilter) flush() string {
+	out := f.held
+	f.held = ""
+	return out
+}
+
+// runCompletion 执行文本续写：
+//   - 上游实现 CompletionProvider 时原生转发，流式输出
+//   - FIM 请求用对话模拟，输出需要整理（去掉代码块、与前后文重复的部分），因此缓冲后一次输出
+//   - 其它续写把提示词作为一条用户消息，流式输出
+//
+// 补全场景不需要思考过程，reasoning 一律丢弃；stop 在代理侧再截断一次，兼容不支持 stop 的上游
+func runCompletion(ctx context.Context, provider ChatProvider, req *CompletionRequest, emit func(ChatEvent) error) error {
+	if req.Suffix != "" && req.MaxTokens == 0 {
+		out := *req
+		out.MaxTokens = fimDefaultMaxTokens
+		req = &out
+	}
+	// 虚拟模型在基础后端支持原生续写时同样实现 CompletionProvider（见 wrapVirtualProvider）
+	if req.Suffix != "" {
+		if _, ok := provider.(CompletionProvider); !ok {
+			return emulateFIM(ctx, provider, req, emit)
+		}
+	}
+	filter := &stopFilter{stops: req.Stop}
+	final := ChatEvent{}
+	forward := func(ev ChatEvent) error {
+		if ev.Usage != nil {
+			final.Usage = ev.Usage
+		}
+		if ev.FinishReason != "" {
+			final.FinishReason = ev.FinishReason
+		}
+		ev.Reasoning = ""
+		ev.Usage = nil
+		ev.FinishReason = ""
+		ev.Content = filter.push(ev.Content)
+		if ev.Content != "" || len(ev.Header) > 0 {
+			if err := emit(ev); err != nil {
+				return err
+			}
+		}
+		if filter.stopped {
+			return errStopSequence
+		}
+		return nil
+	}
+	var err error
+	if completer, ok := provider.(CompletionProvider); ok {
+		err = completer.Complete(ctx, req, forward)
+	} else {
+		chat := &ChatCompletionRequest{
+			Model:       req.Model,
+			Messages:    []ChatCompletionMessage{{Role: "user", Content: req.Prompt}},
+			MaxTokens:   req.MaxTokens,
+			Temperature: req.Temperature,
+			TopP:        req.TopP,
+			Stop:        req.Stop,
+			Stream:      true,
+			// 续写不输出思考内容，直接关闭思考省去等待
+			ReasoningEffort: reasoningEffortNone,
+		}
+		err = provider.ChatStream(ctx, chat, forward)
+	}
+	if err != nil && !errors.Is(err, errStopSequence) {
+		return err
+	}
+	if rest := filter.flush(); rest != "" {
+		if err := emit(ChatEvent{Content: rest}); err != nil {
+			return err
+		}
+	}
+	if filter.stopped || final.FinishReason == "" {
+		final.FinishReason = FinishReasonStop
+	}
+	return emit(final)
+}
+
+// emulateFIM 用对话模拟 FIM，整理输出后作为一个分片返回
+func emulateFIM(ctx context.Context, provider ChatProvider, req *CompletionRequest, emit func(ChatEvent) error) error {
+	result := chatResult{}
+	err := provider.ChatStream(ctx, fimChatRequest(req), func(ev ChatEvent) error {
+		result.add(ev)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	filter := &stopFilter{stops: req.Stop}
+	text := filter.push(trimFIMOutput(result.Content, req.Prompt, req.Suffix)) + filter.flush()
+	reason := result.FinishReason
+	if filter.stopped || reason == "" {
+		reason = FinishReasonStop
+	}
+	if text != "" || len(result.Header) > 0 {
+		if err := emit(ChatEvent{Content: text, Header: result.Header}); err != nil {
+			return err
+		}
+	}
+	return emit(ChatEvent{FinishReason: reason, Usage: &result.Usage})
+}
+
+// fimChatRequest 上游不支持原生 FIM 时，用对话提示词模拟补全光标处的代码
+func fimChatRequest(req *CompletionRequest) *ChatCompletionRequest {
+	prompt := "<PREFIX>" + req.Prompt + "</PREFIX>\n<SUFFIX>" + req.Suffix + "</SUFFIX>"
+	return &ChatCompletionRequest{
+		Model: req.Model,
+		Messages: []ChatCompletionMessage{
+			{Role: "system", Content: "You are a code completion engine. Output only the text that belongs between <PREFIX> and <SUFFIX>, " +
+				"without explanations, markdown fences or repeating the surrounding code."},
+			{Role: "user", Content: prompt},
+		},
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Stop:        req.Stop,
+		Stream:      true,
+		// 补全结果只取正文，关闭思考以降低行内补全的延迟
+		ReasoningEffort: reasoningEffortNone,
+	}
+}
+
+// trimFIMOutput 把对话模型的回答整理为可以直接插入光标处的文本：
+// 去掉 markdown 代码块和回显的标签，去掉与 prefix 末尾、suffix 开头重复的部分
+func trimFIMOutput(text, prefix, suffix string) string {
+	if start := strings.Index(text, "```"); start >= 0 {
+		body := text[start+3:]
+		// 跳过代码块语言标记所在行
+		if nl := strings.Index(body, "\n"); nl >= 0 {
+			body = body[nl+1:]
+		}
+		if end := strings.Index(body, "```"); end >= 0 {
+			body = body[:end]
+		}
+		text = strings.TrimSuffix(body, "\n")
+	}
+	for _, tag := range []string{"<PREFIX>", "</PREFIX>", "<SUFFIX>", "</SUFFIX>", "<MIDDLE>", "</MIDDLE>"} {
+		text = strings.ReplaceAll(text, tag, "")
+	}
+	// 模型常把光标所在行已有的部分（有时不带缩进）再输出一遍
+	line := prefix[strings.LastIndex(prefix, "\n")+1:]
+	switch trimmed := strings.TrimLeft(line, " \t"); {
+	case prefix != "" && strings.HasPrefix(text, prefix):
+		text = text[len(prefix):]
+	case trimmed == "":
+	case strings.HasPrefix(text, line):
+		text = text[len(line):]
+	case strings.HasPrefix(text, trimmed):
+		text = text[len(trimmed):]
+	}
+	// 结尾与 suffix 开头重叠的部分已在光标之后，去掉；过短的重叠（如单个括号）可能是有意输出，保留
+	for n := len(suffix); n > 0; n-- {
+		if n > len(text) || !strings.HasSuffix(text, suffix[:n]) {
+			continue
+		}
+		if overlap := strings.TrimSpace(suffix[:n]); len(overlap) >= 3 || n == len(suffix) {
+			text = text[:len(text)-n]
+		}
+		break
+	}
+	return text
+}
+
+// OpenAICompletionRequest /v1/completions 请求
+type OpenAICompletionRequest struct {
+	Model         string          `json:"model"`
+	Prompt        json.RawMessage `json:"prompt"`
+	Suffix        string          `json:"suffix,omitempty"`
+	MaxTokens     int             `json:"max_tokens,omitempty"`
+	Temperature   *float32        `json:"temperature,omitempty"`
+	TopP          float32         `json:"top_p,omitempty"`
+	N             int             `json:"n,omitempty"`
+	Stream        bool            `json:"stream,omitempty"`
+	StreamOptions *StreamOptions  `json:"stream_options,omitempty"`
+	Stop          json.RawMessage `json:"stop,omitempty"`
+	Echo          bool            `json:"echo,omitempty"`
+	User          string          `json:"user,omitempty"`
+}
+
+// OpenAICompletionChoice 流式分片中 finish_reason 为 null，直到最后一个分片
+type OpenAICompletionChoice struct {
+	Text         string        `json:"text"`
+	Index        int           `json:"index"`
+	Logprobs     interface{}   `json:"logprobs"`
+	FinishReason *FinishReason `json:"finish_reason"`
+}
+
+type OpenAICompletionResponse struct {
+	ID      string                   `json:"id"`
+	Object  string                   `json:"object"`
+	Created int64                    `json:"created"`
+	Model   string                   `json:"model"`
+	Choices []OpenAICompletionChoice `json:"choices"`
+	Usage   *Usage                   `json:"usage,omitempty"`
+}
+
+// stringOrList 解析 string 或 []string 字段
+func stringOrList(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var one string
+	if err := json.Unmarshal(raw, &one); err == nil {
+		return []string{one}, nil
+	}
+	var many []string
+	if err := json.Unmarshal(raw, &many); err != nil {
+		return nil, err
+	}
+	return many, nil
+}
+
+// OpenaiCompletionsHandler POST /v1/completions，IDE 行内补全常用 prompt + suffix 调用
+func OpenaiCompletionsHandler(c *gin.Context) {
+	var input OpenAICompletionRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "Invalid request", "type": "invalid_request_error"}})
+		return
+	}
+	invalid := func(message string) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": message, "type": "invalid_request_error"}})
+	}
+	prompts, err := stringOrList(input.Prompt)
+	if err != nil || len(prompts) > 1 {
+		invalid("prompt must be a string or an array with one string")
+		return
+	}
+	prompt := ""
+	if len(prompts) == 1 {
+		prompt = prompts[0]
+	}
+	stops, err := stringOrList(input.Stop)
+	if err != nil {
+		invalid("stop must be a string or an array of strings")
+		return
+	}
+	provider, upstreamModel, err := selectProvider(input.Model)
+	if err != nil {
+		invalid(err.Error())
+		return
+	}
+	req := &CompletionRequest{
+		Model:       upstreamModel,
+		Prompt:      prompt,
+		Suffix:      input.Suffix,
+		MaxTokens:   input.MaxTokens,
+		Temperature: input.Temperature,
+		TopP:        input.TopP,
+		Stop:        stops,
+		Stream:      true,
+	}
+	if req.MaxTokens == 0 {
+		req.MaxTokens = fimDefaultMaxTokens
+	}
+
+	id := "cmpl-" + RandString(24)
+	created := time.Now().Unix()
+	response := func(text string, reason FinishReason) *OpenAICompletionResponse {
+		choice := OpenAICompletionChoice{Text: text}
+		if reason != "" {
+			choice.FinishReason = &reason
+		}
+		return &OpenAICompletionResponse{
+			ID:      id,
+			Object:  "text_completion",
+			Created: created,
+			Model:   input.Model,
+			Choices: []OpenAICompletionChoice{choice},
+		}
+	}
+
+	watch := watchClient(c, input.Model)
+	if !input.Stream {
+		result := chatResult{}
+		err := runCompletion(watch.ctx, provider, req, watch.emit(func(ev ChatEvent) error {
+			result.add(ev)
+			return nil
+		}))
+		if watch.gone() {
+			return
+		}
+		err = watch.failure(err)
+		if err != nil {
+			openaiUpstreamError(c, err)
+			return
+		}
+		text := result.Content
+		if input.Echo {
+			text = prompt + text
+		}
+		resp := response(text, result.FinishReason)
+		resp.Usage = &result.Usage
+		applyEventHeader(c, result.Header)
+		c.JSON(http.StatusOK, resp)
+		return
+	}
+
+	started := false
+	start := func() {
+		if !started {
+			started = true
+			c.Header("content-Type", "text/event-stream")
+			c.Header("cache-control", "no-cache")
+			c.Header("Connection", "keep-alive")
+		}
+	}
+	if input.Echo && prompt != "" {
+		start()
+		_ = ObjectData(c, response(prompt, ""))
+	}
+	watch.keepalive(func() error {
+		start()
+		return PingData(c)
+	})
+	var usage *Usage
+	err = runCompletion(watch.ctx, provider, req, watch.emit(func(ev ChatEvent) error {
+		applyEventHeader(c, ev.Header)
+		if ev.Usage != nil {
+			usage = ev.Usage
+		}
+		if ev.Content == "" && ev.FinishReason == "" {
+			return nil
+		}
+		start()
+		return ObjectData(c, response(ev.Content, ev.FinishReason))
+	}))
+	if watch.gone() {
+		return
+	}
+	err = watch.failure(err)
+	if err != nil && !started {
+		openaiUpstreamError(c, err)
+		return
+	}
+	if err != nil {
+		log.Println("Stream error:", err)
+		_ = ObjectData(c, gin.H{"error": gin.H{"message": err.Error(), "type": "upstream_error"}})
+	} else if input.StreamOptions != nil && input.StreamOptions.IncludeUsage && usage != nil {
+		resp := response("", "")
+		resp.Choices = []OpenAICompletionChoice{}
+		resp.Usage = usage
+		_ = ObjectData(c, resp)
+	}
+	Done(c)
+}
+
+// completionURL 原生续写接口地址，未配置 completionsUrl 时为 {baseUrl}/completions
+func completionURL(cfg *ProviderConfig) string {
+	if cfg.CompletionsURL != "" {
+		return cfg.CompletionsURL
+	}
+	return fmt.Sprintf("%s/completions", strings.TrimSuffix(cfg.BaseUrl, "/"))
+}
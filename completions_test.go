@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStopFilter(t *testing.T) {
+	tests := []struct {
+		chunks  []string
+		stops   []string
+		want    string
+		stopped bool
+	}{
+		{[]string{"abc", "def"}, nil, "abcdef", false},
+		{[]string{"foo\n\n", "bar"}, []string{"\n\n"}, "foo", true},
+		// stop 跨分片
+		{[]string{"foo\n", "\nbar"}, []string{"\n\n"}, "foo", true},
+		{[]string{"foo<", "|end", "|>x"}, []string{"<|end|>"}, "foo", true},
+		// 只是前缀，流结束时原样输出
+		{[]string{"foo<", "|en"}, []string{"<|end|>"}, "foo<|en", false},
+		{[]string{"中文", "结束了"}, []string{"结束"}, "中文", true},
+	}
+	for _, tt := range tests {
+		f := &stopFilter{stops: tt.stops}
+		var sb strings.Builder
+		for _, chunk := range tt.chunks {
+			sb.WriteString(f.push(chunk))
+		}
+		sb.WriteString(f.flush())
+		if sb.String() != tt.want || f.stopped != tt.stopped {
+			t.Errorf("chunks %q stops %q = %q %v, want %q %v", tt.chunks, tt.stops, sb.String(), f.stopped, tt.want, tt.stopped)
+		}
+	}
+}
+
+func TestTrimFIMOutput(t *testing.T) {
+	tests := []struct {
+		name, text, prefix, suffix, want string
+	}{
+		{"plain", "a + b", "return ", "\n}", "a + b"},
+		{"fence", "```go\nreturn a + b\n```", "func add(a, b int) int {\n\t", "\n}", "return a + b"},
+		{"repeat current line", "\treturn a + b", "func add() {\n\tret", "\n}", "urn a + b"},
+		{"repeat line without indent", "return a + b", "func add() {\n\treturn", "\n}", " a + b"},
+		{"suffix overlap", "a + b\n}\n\nfunc main() {", "return ", "\n}\n\nfunc main() {\n}", "a + b"},
+		{"short overlap kept", "foo()", "x = ", ")\n", "foo()"},
+		{"tags", "<MIDDLE>a + b</MIDDLE>", "return ", "", "a + b"},
+	}
+	for _, tt := range tests {
+		if got := trimFIMOutput(tt.text, tt.prefix, tt.suffix); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOpenaiCompletions(t *testing.T) {
+	server := newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		if strings.HasSuffix(r.URL.Path, "/beta/completions") {
+			writeSSE(w, `{"choices":[{"text":"a +"}]}`, `{"choices":[{"text":" b\n\n// next"}]}`,
+				`{"choices":[{"text":" never sent","finish_reason":"length"}]}`, "[DONE]")
+			return
+		}
+		// 对话模型的回答带代码块，还重复了 suffix
+		writeSSE(w, openaiChunk(`{"reasoning_content":"thinking"}`, ""), openaiContent("```go\na + b\n}\n```", "stop"), "[DONE]")
+	})
+	defer server.Close()
+
+	XConfig = &Config{
+		ChatType: "dify",
+		Providers: []ProviderConfig{
+			{Name: "ds", Type: "openai", BaseUrl: server.URL, CompletionsURL: server.URL + "/beta/completions", Models: []string{"deepseek-chat"}},
+			{Name: "az", Type: "azure", BaseUrl: server.URL, Deployments: map[string]string{"gpt-4o": "gpt4o"}},
+		},
+	}
+	defer func() { XConfig = nil }()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/completions", OpenaiCompletionsHandler)
+	call := func(body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/v1/completions", strings.NewReader(body)))
+		return w
+	}
+
+	// 原生 FIM：流式输出在代理侧命中 stop 后结束
+	w := call(`{"model":"deepseek-chat","prompt":"return ","suffix":"\n}","stop":"\n\n","stream":true}`)
+	var text strings.Builder
+	var reasons []string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if !strings.HasPrefix(line, "data: {") {
+			continue
+		}
+		chunk := OpenAICompletionResponse{}
+		_ = json.Unmarshal([]byte(line[6:]), &chunk)
+		text.WriteString(chunk.Choices[0].Text)
+		if chunk.Choices[0].FinishReason != nil {
+			reasons = append(reasons, string(*chunk.Choices[0].FinishReason))
+		}
+	}
+	if text.String() != "a + b" || fmt.Sprint(reasons) != "[stop]" || !strings.HasSuffix(w.Body.String(), "data: [DONE]\n\n") {
+		t.Errorf("native stream = %s", w.Body.String())
+	}
+	bodies, paths := server.bodies(), server.paths()
+	if bodies[0]["suffix"] != "\n}" || bodies[0]["max_tokens"] != float64(fimDefaultMaxTokens) {
+		t.Errorf("native request = %v", bodies[0])
+	}
+
+	// 对话模拟：整理输出，丢弃 reasoning
+	w = call(`{"model":"gpt-4o","prompt":"func add(a, b int) int {\n\treturn ","suffix":"\n}","max_tokens":64}`)
+	resp := OpenAICompletionResponse{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if len(resp.Choices) != 1 || resp.Choices[0].Text != "a + b" || resp.Object != "text_completion" {
+		t.Errorf("emulated = %s", w.Body.String())
+	}
+	bodies, paths = server.bodies(), server.paths()
+	messages := bodies[1]["messages"].([]interface{})
+	if !strings.HasSuffix(paths[1], "/chat/completions") || bodies[1]["max_tokens"] != float64(64) ||
+		!strings.Contains(messages[1].(map[string]interface{})["content"].(string), "<SUFFIX>\n}</SUFFIX>") {
+		t.Errorf("emulated request = %v", bodies[1])
+	}
+
+	// FROM 原生 FIM 后端的虚拟模型仍走原生接口，续写只应用采样参数
+	virtualModels[ollamaModelName("my-coder")] = &VirtualModel{Name: "my-coder", From: "deepseek-chat", System: "ignored", Parameters: map[string]interface{}{"temperature": 0.2}}
+	defer func() { virtualModels = map[string]*VirtualModel{} }()
+	w = call(`{"model":"my-coder","prompt":"return ","suffix":"\n}"}`)
+	bodies, paths = server.bodies(), server.paths()
+	if last := len(paths) - 1; !strings.HasSuffix(paths[last], "/beta/completions") || bodies[last]["prompt"] != "return " ||
+		math.Abs(bodies[last]["temperature"].(float64)-0.2) > 1e-6 {
+		t.Errorf("virtual native = %s %v", paths[last], bodies[last])
+	}
+
+	if w := call(`{"model":"gpt-4o","prompt":["a","b"]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("multiple prompts = %d", w.Code)
+	}
+}
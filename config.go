@@ -17,21 +17,72 @@ type Config struct {
 	Debug      bool   `json:"debug"`
 	Mock       bool   `json:"mock"`
 	// Model        string            `json:"model"`
-	DifyAppMap       map[string]string `json:"difyAppMap"`
-	DifyAppMapProd   map[string]string `json:"difyAppMapProd"`
-	DifyTokenUrl     string            `json:"difyTokenUrl"`
-	DifyTokenUrlProd string            `json:"difyTokenUrlProd"`
-	Mapping          map[string]string `json:"mapping"`
-	ProxyMapping     map[string]string `json:"proxyMapping"`
-	DifyTokenMap     map[string]string `json:"-"`
-	IsProd           bool              `json:"-"`
-	CAFile           string            `json:"caFile"`
-	CAKeyFile        string            `json:"caKeyFile"`
-	Domain           string            `json:"domain"`
-	DomainPemFile    string            `json:"domainPemFile"`
-	DomainKeyFile    string            `json:"domainKeyFile"`
-	IsTls            bool              `json:"isTls"`
-	OSSConfig        OSSConfig         `json:"oss"`
+	DifyAppMap       map[string]string  `json:"difyAppMap"`
+	DifyAppMapProd   map[string]string  `json:"difyAppMapProd"`
+	DifyTokenUrl     string             `json:"difyTokenUrl"`
+	DifyTokenUrlProd string             `json:"difyTokenUrlProd"`
+	DifySystemInput  string             `json:"difySystemInput"` // system 消息写入的 Dify inputs 变量名，未配置时拼在 query 前
+	Mapping          map[string]string  `json:"mapping"`
+	ProxyMapping     map[string]string  `json:"proxyMapping"`
+	ModelAliases     []AliasRule        `json:"modelAliases"` // 通配符 / 正则模型映射，按顺序匹配，mapping 优先
+	ProxyAliases     []AliasRule        `json:"proxyAliases"` // 透传代理的通配符 / 正则模型映射，proxyMapping 优先
+	DifyTokenMap     map[string]string  `json:"-"`            // 按模型缓存的 dify access_token，读写需持有 difyTokenMu
+	CAFile           string             `json:"caFile"`
+	CAKeyFile        string             `json:"caKeyFile"`
+	Domain           string             `json:"domain"`
+	DomainPemFile    string             `json:"domainPemFile"`
+	DomainKeyFile    string             `json:"domainKeyFile"`
+	IsTls            bool               `json:"isTls"`
+	OSSConfig        OSSConfig          `json:"oss"`
+	Providers        []ProviderConfig   `json:"providers"`
+	ModelsFile       string             `json:"modelsFile"`   // /api/create 创建的虚拟模型保存位置，默认 models.json
+	ThinkTags        []ThinkTagRule     `json:"thinkTags"`    // <think> 标签转换规则，按顺序匹配
+	Continuation     []ContinuationRule `json:"continuation"` // 长度截断时自动续写的规则，按顺序匹配
+	Timeouts         []TimeoutRule      `json:"timeouts"`     // 上游超时与流式保活规则，按顺序匹配
+	Resume           ResumeConfig       `json:"resume"`       // 断线续传
+	Passthrough      *PassthroughConfig `json:"passthrough"`  // 默认上游的透传代理，TLS 服务未注册的路径与 /proxy/openai/ 下的路径
+	WebSocket        WebSocketConfig    `json:"websocket"`    // /ws/chat 允许的来源与每个连接的并发数
+	AdminToken       string             `json:"adminToken"`   // /admin/ 接口的访问令牌（Authorization: Bearer），未配置时不注册这些接口
+}
+
+// ProviderConfig 上游后端配置，models 中列出的模型路由到该后端，未列出的模型仍按 chatType 处理
+type ProviderConfig struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"` // gemini / openai / azure / bedrock / jetbrains / ollama
+	BaseUrl  string   `json:"baseUrl"`
+	APIKey   string   `json:"apiKey"`
+	AuthType string   `json:"authType"` // apiKey(默认) / bearer
+	Models   []string `json:"models"`
+	// 原生续写/FIM 接口地址，默认 {baseUrl}/completions；DeepSeek 为 https://api.deepseek.com/beta/completions
+	CompletionsURL string `json:"completionsUrl"`
+	// openai：透传 top_k / repetition_penalty 等非标准采样参数（vLLM、SGLang 等支持，OpenAI 官方接口会报错）
+	PassSampling bool `json:"passSampling"`
+	// gemini
+	SafetySettings []GeminiSafetySetting `json:"safetySettings"`
+	// azure
+	APIVersion  string            `json:"apiVersion"`
+	Deployments map[string]string `json:"deployments"` // 模型别名 -> 部署名
+	// bedrock，密钥未配置时读取 AWS_ACCESS_KEY_ID 等环境变量
+	Region          string `json:"region"`
+	AccessKeyID     string `json:"accessKeyId"`
+	SecretAccessKey string `json:"secretAccessKey"`
+	SessionToken    string `json:"sessionToken"`
+	// 模型别名 -> 上游模型 ID（bedrock 的 modelId、jetbrains 的 profile）
+	ModelIDs map[string]string `json:"modelIds"`
+	// 透传代理，/proxy/{name}/ 下的路径原样转发到该后端（openai / azure / gemini / ollama）
+	Passthrough *PassthroughConfig `json:"passthrough"`
+}
+
+// providerModels 后端可服务的模型，azure deployments 与 bedrock modelIds 的别名也计算在内
+func providerModels(cfg *ProviderConfig) []string {
+	models := append([]string{}, cfg.Models...)
+	for alias := range cfg.Deployments {
+		models = append(models, alias)
+	}
+	for alias := range cfg.ModelIDs {
+		models = append(models, alias)
+	}
+	return models
 }
 
 func loadConfig(configPath string) (*Config, error) {
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 自动续写：上游因长度截断（finish_reason length、Anthropic max_tokens）时，把已输出的内容作为 assistant 消息追加后重新请求，
+// 续写内容接在同一个客户端流上输出，用量按所有片段累计。按 continuation 规则逐请求开启，默认关闭。
+
+// continuationDefaultSegments 规则未指定 maxSegments 时最多续写的次数
+const continuationDefaultSegments = 3
+
+// continuationPrompt 追加在已输出内容之后，要求模型从断开处接着写
+const continuationPrompt = "Your previous reply was cut off because of the length limit. Continue exactly where it stopped, " +
+	"without repeating anything and without any preamble."
+
+// ContinuationRule continuation 配置项，匹配条件与 thinkTags 相同，第一条匹配的规则生效
+type ContinuationRule struct {
+	Route       string `json:"route"`
+	UserAgent   string `json:"userAgent"`
+	Model       string `json:"model"`
+	MaxSegments int    `json:"maxSegments"` // 最多续写次数，默认 3
+	MaxTokens   int    `json:"maxTokens"`   // 所有片段合计的输出 token 上限，0 表示只按次数限制
+}
+
+func (r *ContinuationRule) match(path, userAgent, model string) bool {
+	return requestRuleMatch(r.Route, r.UserAgent, r.Model, path, userAgent, model)
+}
+
+// withContinuation 当前请求匹配 continuation 规则时包装后端。
+// Dify 只接收最后一轮 user 输入，看不到追加的 assistant 消息，续写只会重新生成一遍，不做包装
+func withContinuation(c *gin.Context, model string, provider ChatProvider) ChatProvider {
+	if XConfig == nil {
+		return provider
+	}
+	if _, ok := innerProvider(provider).(*DifyProvider); ok {
+		return provider
+	}
+	for i := range XConfig.Continuation {
+		rule := &XConfig.Continuation[i]
+		if rule.match(c.Request.URL.Path, c.Request.UserAgent(), model) {
+			return &continuationProvider{Inner: provider, Rule: rule}
+		}
+	}
+	return provider
+}
+
+type continuationProvider struct {
+	Inner ChatProvider
+	Rule  *ContinuationRule
+}
+
+func (p *continuationProvider) ChatStream(ctx context.Context, req *ChatCompletionRequest, emit func(ChatEvent) error) error {
+	maxSegments := p.Rule.MaxSegments
+	if maxSegments <= 0 {
+		maxSegments = continuationDefaultSegments
+	}
+	var usage *Usage
+	var finish FinishReason
+	var content string
+	segmentReq := *req
+	for segment := 0; ; segment++ {
+		if p.Rule.MaxTokens > 0 {
+			capSegmentTokens(&segmentReq, p.Rule.MaxTokens-usageCompletion(usage))
+		}
+		finish = ""
+		toolCalls := false
+		// 同一片段中的用量以最后一次为准
+		var segmentUsage *Usage
+		// 每个片段的结束原因与用量先截留，全部片段结束后合并为一个结束事件
+		err := p.Inner.ChatStream(ctx, &segmentReq, func(ev ChatEvent) error {
+			if ev.FinishReason != "" {
+				finish = ev.FinishReason
+				ev.FinishReason = ""
+			}
+			if ev.Usage != nil {
+				segmentUsage = ev.Usage
+				ev.Usage = nil
+			}
+			content += ev.Content
+			toolCalls = toolCalls || len(ev.ToolCalls) > 0
+			if ev.empty() {
+				return nil
+			}
+			return emit(ev)
+		})
+		if err != nil {
+			return err
+		}
+		if segmentUsage != nil {
+			usage = addUsage(usage, segmentUsage)
+		}
+		if finish != FinishReasonLength || toolCalls || segment+1 > maxSegments ||
+			(p.Rule.MaxTokens > 0 && usageCompletion(usage) >= p.Rule.MaxTokens) {
+			break
+		}
+		messages := make([]ChatCompletionMessage, 0, len(req.Messages)+2)
+		messages = append(messages, req.Messages...)
+		messages = append(messages,
+			ChatCompletionMessage{Role: ChatMessageRoleAssistant, Content: content},
+			ChatCompletionMessage{Role: ChatMessageRoleUser, Content: continuationPrompt},
+		)
+		segmentReq.Messages = messages
+	}
+	if finish == "" && usage == nil {
+		return nil
+	}
+	return emit(ChatEvent{FinishReason: finish, Usage: usage})
+}
+
+// capSegmentTokens 按剩余预算收紧本片段的输出上限
+func capSegmentTokens(req *ChatCompletionRequest, remaining int) {
+	if remaining <= 0 {
+		remaining = 1
+	}
+	switch {
+	case req.MaxCompletionTokens > 0:
+		req.MaxCompletionTokens = min(req.MaxCompletionTokens, remaining)
+	case req.MaxTokens > 0:
+		req.MaxTokens = min(req.MaxTokens, remaining)
+	default:
+		req.MaxTokens = remaining
+	}
+}
+
+func usageCompletion(usage *Usage) int {
+	if usage == nil {
+		return 0
+	}
+	return usage.CompletionTokens
+}
+
+// addUsage 累计各片段的用量
+func addUsage(total, u *Usage) *Usage {
+	if total == nil {
+		total = &Usage{}
+	}
+	total.PromptTokens += u.PromptTokens
+	total.CompletionTokens += u.CompletionTokens
+	total.TotalTokens += u.TotalTokens
+	return total
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// truncatingStandIn 第一次请求因长度截断，之后正常结束
+func truncatingStandIn() *standIn {
+	return newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		text, finish := "world.", "stop"
+		if n == 1 {
+			text, finish = "Hello, ", "length"
+		}
+		writeSSE(w, openaiContent(text, ""), openaiChunk(`{}`, finish), openaiUsage(5, 3), "[DONE]")
+	})
+}
+
+func TestContinuationStream(t *testing.T) {
+	server := truncatingStandIn()
+	defer server.Close()
+	XConfig = &Config{
+		ChatType:     "dify",
+		Providers:    []ProviderConfig{{Name: "openai", Type: "openai", BaseUrl: server.URL, Models: []string{"gpt-test", "gpt-budget"}}},
+		Continuation: []ContinuationRule{{Model: "gpt-budget", MaxTokens: 3}, {Route: "/v1/"}},
+	}
+	defer func() { XConfig = nil }()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/chat/completions", OpenaiHandler)
+	router.POST("/api/chat", chatHandlerSteam)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/v1/chat/completions",
+		strings.NewReader(`{"model":"gpt-test","stream":true,"stream_options":{"include_usage":true},"messages":[{"role":"user","content":"greet"}]}`)))
+	var content strings.Builder
+	var finishes []FinishReason
+	var usage *Usage
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if !strings.HasPrefix(line, "data: {") {
+			continue
+		}
+		var chunk ChatCompletionStreamResponse
+		_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &chunk)
+		for _, choice := range chunk.Choices {
+			content.WriteString(choice.Delta.Content)
+			if choice.FinishReason != "" && choice.FinishReason != FinishReasonNull {
+				finishes = append(finishes, choice.FinishReason)
+			}
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage
+		}
+	}
+	if content.String() != "Hello, world." || len(finishes) != 1 || finishes[0] != FinishReasonStop {
+		t.Fatalf("content = %q, finishes = %v\n%s", content.String(), finishes, w.Body.String())
+	}
+	if usage == nil || usage.PromptTokens != 10 || usage.CompletionTokens != 6 {
+		t.Fatalf("usage = %+v", usage)
+	}
+	bodies := standInBodies[ChatCompletionRequest](server)
+	if len(bodies) != 2 {
+		t.Fatalf("requests = %d", len(bodies))
+	}
+	msgs := bodies[1].Messages
+	if len(msgs) != 3 || msgs[1].Role != "assistant" || msgs[1].Content != "Hello, " || msgs[2].Content != continuationPrompt {
+		t.Fatalf("continuation messages = %+v", msgs)
+	}
+
+	// 预算用完后不再续写，结束原因保持 length
+	server.reset()
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/chat",
+		strings.NewReader(`{"model":"gpt-budget","stream":false,"messages":[{"role":"user","content":"greet"}]}`)))
+	var resp OllamaResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	bodies = standInBodies[ChatCompletionRequest](server)
+	if len(bodies) != 1 || bodies[0].MaxTokens != 3 || resp.Message.Content != "Hello, " || resp.DoneReason != "length" {
+		t.Fatalf("budget: %d requests, max_tokens %d: %s", len(bodies), bodies[0].MaxTokens, w.Body.String())
+	}
+}
+
+func TestContinuationSkipsDify(t *testing.T) {
+	XConfig = &Config{ChatType: "dify", Continuation: []ContinuationRule{{Route: "/v1/"}}}
+	defer func() { XConfig = nil }()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	for _, provider := range []ChatProvider{&DifyProvider{}, wrapVirtualProvider(&VirtualModel{Name: "app"}, &DifyProvider{})} {
+		if got := withContinuation(c, "app", provider); got != provider {
+			t.Fatalf("%T wrapped as %T", provider, got)
+		}
+	}
+	if _, ok := withContinuation(c, "gpt-test", &OpenAIProvider{}).(*continuationProvider); !ok {
+		t.Fatal("openai provider not wrapped")
+	}
+}
@@ -1,15 +1,20 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
-	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
+// difyTokenMu 保护 XConfig.DifyTokenMap，并发请求会同时读取和刷新 token
+var difyTokenMu sync.Mutex
+
 type DifyToken struct {
 	AccessToken string `json:"access_token"`
 }
@@ -37,6 +42,7 @@ type DifyAgentThoughtEvent struct {
 	ToolInput      string                 `json:"tool_input,omitempty"`
 	MessageFiles   []interface{}          `json:"message_files,omitempty"`
 	Metadata       MessageMetadata        `json:"metadata,omitempty"`
+	Message        string                 `json:"message,omitempty"` // error 事件的错误信息
 }
 type MessageMetadata struct {
 	Usage UsageInfo `json:"usage"`
@@ -57,229 +63,164 @@ type UsageInfo struct {
 	Latency             float64 `json:"latency"`
 }
 
+// ToDityRequest Ollama 请求经中立请求转换，Dify 不接受采样参数
 func ToDityRequest(input *OllamaChatRequest) *DifyChatRequest {
-	index := len(input.Messages) - 1
+	return GptToDityRequest(OllamaToChatRequest(input))
+}
+
+// GptToDityRequest Dify 自己维护会话，只发送末尾的用户输入；system 放到 difySystemInput 指定的 inputs 变量，
+// 未配置时作为 query 的前缀
+func GptToDityRequest(input *ChatCompletionRequest) *DifyChatRequest {
+	system, messages := splitSystem(input.Messages)
 	req := DifyChatRequest{
 		ResponseMode:   "streaming",
 		ConversationID: "",
-		Query:          input.Messages[index].Content,
+		Query:          lastUserTurn(messages),
 		Inputs:         map[string]interface{}{},
 	}
+	switch {
+	case system == "":
+	case XConfig != nil && XConfig.DifySystemInput != "":
+		req.Inputs[XConfig.DifySystemInput] = system
+	case req.Query == "":
+		req.Query = system
+	default:
+		req.Query = system + "\n\n" + req.Query
+	}
 	return &req
 }
 
-func GptToDityRequest(input *ChatCompletionRequest) *DifyChatRequest {
-	index := len(input.Messages) - 1
-	switch content := input.Messages[index].Content.(type) {
-	case string:
-		req := DifyChatRequest{
-			ResponseMode:   "streaming",
-			ConversationID: "",
-			Query:          content,
-			Inputs:         map[string]interface{}{},
-		}
-		return &req
-	case map[string]string:
-		req := DifyChatRequest{
-			ResponseMode:   "streaming",
-			ConversationID: "",
-			Query:          content["text"],
-			Inputs:         map[string]interface{}{},
-		}
-		return &req
-	}
-
-	return nil
-}
+// DifyProvider 通过 dify chat-messages 接口对话，沿用 difyAppMap/difyAppMapProd 的 token 与地址选择
+type DifyProvider struct{}
 
-func DifyToOllamaResponse(input []byte, req *OllamaChatRequest) (*OllamaResponse, error) {
-	msg := OllamaResponse{}
-	response := DifyAgentThoughtEvent{}
-	if len(input) < 6 {
-		return nil, nil
+func (p *DifyProvider) ChatStream(ctx context.Context, req *ChatCompletionRequest, emit func(ChatEvent) error) error {
+	if len(req.Messages) == 0 {
+		return fmt.Errorf("messages is empty")
 	}
-	log.Println("Received request:", string(input[6:])) //去除 data:
-	err := json.Unmarshal(input[6:], &response)
-	if err != nil {
-		log.Println("Unmarshal error:", err)
-		return nil, nil
+	if structuredOutputRequested(req) {
+		return emulateStructuredOutput(ctx, p, req, emit)
 	}
-	if response.Event == "message_end" {
-		msg.Done = true
-		msg.Model = req.Model
-		msg.CreatedAt = time.Now().UTC().Format(time.RFC3339Nano)
-		msg.Message = OllamaMessage{
-			Role:    "assistant",
-			Content: "",
-		}
-		msg.DoneReason = "stop"
-		msg.TotalDuration = 13937866250
-		msg.LoadDuration = 5978299625
-		msg.PromptEvalCount = 9
-		msg.PromptEvalDuration = 3912791542
-		msg.EvalCount = 12
-		msg.EvalDuration = 10937866250
-		return &msg, err
+	req, ignored := adaptSampling(req, noSampling)
+	if err := reportIgnoredParams(emit, ignored); err != nil {
+		return err
 	}
-	if response.Event == "agent_thought" || response.Event == "content_block_start" {
-		if response.Thought == "" {
-			msg.Done = false
-			return nil, err
-		}
-		msg.Done = false
-		msg.Message = OllamaMessage{
-			Role:    "assistant",
-			Content: response.Thought,
-		}
-		msg.Model = req.Model
-		msg.CreatedAt = time.Now().UTC().Format(time.RFC3339Nano)
-		return &msg, err
+	_, isProd := XConfig.DifyAppMapProd[req.Model]
+	url := XConfig.APIURL
+	if isProd {
+		url = XConfig.APIURLProd
 	}
-	if response.Answer == "" {
-		return nil, err
+	token, err := difyToken(ctx, req.Model, isProd)
+	if err != nil {
+		return err
 	}
-	msg.Model = req.Model
-	msg.CreatedAt = time.Now().UTC().Format(time.RFC3339)
-	msg.Message = OllamaMessage{
-		Role:    "assistant",
-		Content: response.Answer,
+	payload, err := json.Marshal(GptToDityRequest(req))
+	if err != nil {
+		return err
 	}
-	msg.Done = false
-	return &msg, nil
-}
-
-func DifyToGptResponse(input []byte, req *ChatCompletionRequest) (string, error) {
-	var msg ChatCompletionStreamResponse
-	response := DifyAgentThoughtEvent{}
-	err := json.Unmarshal(input, &response)
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+	header.Set("x-api-key", XConfig.APIKey)
+	resp, err := postUpstream(ctx, url, payload, header)
 	if err != nil {
-		log.Println("Unmarshal error:", err)
-		return "", nil
+		return err
 	}
-	now := time.Now().Unix()
-	chatId := strconv.Itoa(int(now))
-	fingerprint := "" //raw body
-	if response.Event == "message_end" {
-		//var spentAmount float64 = 80 // todo 计算花费
+	defer resp.Body.Close()
 
-		msg = CreateStreamMessage(chatId, now, req, fingerprint, "", "")
-		msg.Choices[0].FinishReason = FinishReasonStop
-		msg.Usage = &Usage{
-			PromptTokens:     response.Metadata.Usage.PromptTokens,
-			CompletionTokens: response.Metadata.Usage.CompletionTokens,
-			TotalTokens:      response.Metadata.Usage.TotalTokens,
+	thoughts := difyThoughts{}
+	taskID := ""
+	err = readSSEData(resp.Body, func(data string) error {
+		response := DifyAgentThoughtEvent{}
+		if err := json.Unmarshal([]byte(data), &response); err != nil {
+			log.Println("Unmarshal error:", err)
+			return nil
 		}
-		// str, _ := json.Marshal(&msg)
-		return "", err
-	}
-	if response.Event == "agent_thought" || response.Event == "content_block_start" {
-		if response.Thought != "" {
-			msg := ChatCompletionMessage{
-				Role: "assistant",
-				Content: []ChatMessagePart{
-					{
-						Type: "text",
-						Text: response.Thought,
-					},
-				},
+		if response.TaskID != "" {
+			taskID = response.TaskID
+		}
+		switch response.Event {
+		case "message", "agent_message":
+			if response.Answer == "" {
+				return nil
 			}
-			log.Println("Received empty thoughts")
-			resp := ChatCompletionResponse{}
-			resp.ID = chatId
-			resp.Object = "chat.completion"
-			resp.Created = now
-			resp.Choices = []ChatCompletionChoice{
-				{
-					Index:        0,
-					Message:      msg,
-					FinishReason: FinishReasonStop,
-				},
+			thoughts.answer.WriteString(response.Answer)
+			return emit(ChatEvent{Content: response.Answer})
+		case "agent_thought":
+			if reasoningDisabled(req) {
+				return nil
+			}
+			if thought := thoughts.delta(response.ID, response.Thought); thought != "" {
+				return emit(ChatEvent{Reasoning: thought})
 			}
-			// msg.V = "ok,"
-			// msg.P = "response/content"
-			// msg.O = "append"
-			// str, err := json.Marshal(&msg)
-			//msg = CreateStreamMessage(chatId, now, req, fingerprint, response.Answer, "")
-			str, _ := json.Marshal(&msg)
-			return string(str), err
+		case "message_end":
+			return emit(ChatEvent{
+				FinishReason: FinishReasonStop,
+				Usage: &Usage{
+					PromptTokens:     response.Metadata.Usage.PromptTokens,
+					CompletionTokens: response.Metadata.Usage.CompletionTokens,
+					TotalTokens:      response.Metadata.Usage.TotalTokens,
+				},
+			})
+		case "error":
+			return fmt.Errorf("dify error: %s", response.Message)
 		}
-		return "", err
-	}
-	if response.Answer == "" {
-		return "", nil
+		return nil
+	})
+	// 断开连接后 dify 仍会在服务端继续生成并计费，需要显式停止任务
+	if ctx.Err() != nil && taskID != "" {
+		stopDifyTask(url, taskID, header)
 	}
-
-	msg = CreateStreamMessage(chatId, now, req, fingerprint, response.Answer, "")
-	// completionBuilder.WriteString(sseData.Content)
-
-	// msg.V = response.Answer
-	// str, err := json.Marshal(&msg)
-	return "", err
+	return err
 }
 
-func DifyToGptResponseStream(input []byte, req *ChatCompletionRequest) (string, error) {
-	var msg ChatCompletionStreamResponse
-	response := DifyAgentThoughtEvent{}
-	err := json.Unmarshal(input, &response)
+// stopDifyTask 调用 {apiURL}/{task_id}/stop 停止流式生成；原请求的 context 已取消，这里单独限时
+func stopDifyTask(url, taskID string, header http.Header) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := postUpstream(ctx, fmt.Sprintf("%s/%s/stop", strings.TrimSuffix(url, "/"), taskID), []byte(`{"user":""}`), header)
 	if err != nil {
-		log.Println("Unmarshal error:", err)
-		return "", nil
+		log.Printf("停止 dify 任务 %s 失败: %v", taskID, err)
+		return
+	}
+	resp.Body.Close()
+	if XConfig.Debug {
+		log.Printf("已停止 dify 任务 %s\n", taskID)
 	}
-	now := time.Now().Unix()
-	chatId := strconv.Itoa(int(now))
-	fingerprint := "" //raw body
-	if response.Event == "message_end" {
-		//var spentAmount float64 = 80 // todo 计算花费
+}
 
-		msg = CreateStreamMessage(chatId, now, req, fingerprint, "", "")
-		msg.Choices[0].FinishReason = FinishReasonStop
-		msg.Usage = &Usage{
-			PromptTokens:     response.Metadata.Usage.PromptTokens,
-			CompletionTokens: response.Metadata.Usage.CompletionTokens,
-			TotalTokens:      response.Metadata.Usage.TotalTokens,
-		}
-		str, _ := json.Marshal(&msg)
-		// return string(str), err
-		return "[DONE]" + string(str), err
+// difyToken 返回模型缓存的 access_token，没有时按 isProd 选择地址获取并缓存
+func difyToken(ctx context.Context, model string, isProd bool) (string, error) {
+	difyTokenMu.Lock()
+	token := XConfig.DifyTokenMap[model]
+	difyTokenMu.Unlock()
+	if token != "" {
+		return token, nil
 	}
-	if response.Event == "agent_thought" || response.Event == "content_block_start" {
-		if response.Thought == "" {
-			log.Println("Received empty thoughts")
-			// msg.V = "ok,"
-			// msg.P = "response/content"
-			// msg.O = "append"
-			// str, err := json.Marshal(&msg)
-			//msg = CreateStreamMessage(chatId, now, req, fingerprint, response.Answer, "")
-			//str, _ := json.Marshal(&msg)
-			//return string(str), err
-		}
+	token, err := getDifyToken(ctx, model, isProd)
+	if err != nil {
 		return "", err
 	}
-	if response.Answer == "" {
-		return "", nil
+	difyTokenMu.Lock()
+	if XConfig.DifyTokenMap == nil {
+		XConfig.DifyTokenMap = make(map[string]string)
 	}
-
-	msg = CreateStreamMessage(chatId, now, req, fingerprint, response.Answer, "")
-	// completionBuilder.WriteString(sseData.Content)
-
-	// msg.V = response.Answer
-	str, err := json.Marshal(&msg)
-	return string(str), err
+	XConfig.DifyTokenMap[model] = token
+	difyTokenMu.Unlock()
+	return token, nil
 }
 
-func getDifyToken(model string) error {
+func getDifyToken(ctx context.Context, model string, isProd bool) (string, error) {
 	if XConfig == nil {
-		return fmt.Errorf("XConfig is nil")
+		return "", fmt.Errorf("XConfig is nil")
 	}
 	client := &http.Client{}
 	url := XConfig.DifyTokenUrl
-	if XConfig.IsProd {
+	if isProd {
 		url = XConfig.DifyTokenUrlProd
 	}
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		log.Printf("创建请求失败: %v", err)
-		return err
+		return "", err
 	}
 	if app, ok := XConfig.DifyAppMapProd[model]; ok {
 		req.Header.Add("X-App-Code", app)
@@ -291,25 +232,21 @@ func getDifyToken(model string) error {
 	resp, err := client.Do(req)
 	if err != nil {
 		log.Printf("发送请求失败: %v", err)
-		return err
+		return "", err
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		log.Printf("读取响应失败: %v", err)
-		return err
+		return "", err
 	}
 	token := DifyToken{}
 	err = json.Unmarshal(body, &token)
 	if err != nil {
 		log.Println("Unmarshal error:", err)
 	}
-	if XConfig.DifyTokenMap == nil {
-		XConfig.DifyTokenMap = make(map[string]string)
-	}
-	XConfig.DifyTokenMap[model] = token.AccessToken
-	log.Println("获取到的token:", token.AccessToken)
+	log.Println("已获取 dify token:", model)
 
-	return nil
+	return token.AccessToken, nil
 }
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestDifyTokenPerEnvironment(t *testing.T) {
+	server := newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		env, endpoint, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+		if endpoint == "token" {
+			_, _ = w.Write([]byte(`{"access_token":"` + env + `-token"}`))
+			return
+		}
+		writeSSE(w, `{"event":"message","answer":"ok"}`, `{"event":"message_end","metadata":{"usage":{}}}`)
+	})
+	defer server.Close()
+
+	XConfig = &Config{
+		APIURL:           server.URL + "/test/chat-messages",
+		APIURLProd:       server.URL + "/prod/chat-messages",
+		DifyTokenUrl:     server.URL + "/test/token",
+		DifyTokenUrlProd: server.URL + "/prod/token",
+		DifyAppMap:       map[string]string{"dev-model": "a"},
+		DifyAppMapProd:   map[string]string{"prod-model": "b"},
+	}
+	defer func() { XConfig = nil }()
+
+	// 测试与生产环境的模型并发请求，地址和 token 不能串用
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		model := "dev-model"
+		if i%2 == 1 {
+			model = "prod-model"
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := &ChatCompletionRequest{Model: model, Messages: []ChatCompletionMessage{{Role: ChatMessageRoleUser, Content: "?"}}}
+			if err := (&DifyProvider{}).ChatStream(context.Background(), req, func(ChatEvent) error { return nil }); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	chats := 0
+	for _, r := range server.received() {
+		env, endpoint, _ := strings.Cut(strings.TrimPrefix(r.Path, "/"), "/")
+		if endpoint != "chat-messages" {
+			continue
+		}
+		chats++
+		if got := r.Header.Get("Authorization"); got != "Bearer "+env+"-token" {
+			t.Fatalf("%s used %q", r.Path, got)
+		}
+	}
+	if chats != 8 {
+		t.Fatalf("chat requests = %d", chats)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 各上游单次请求允许的最大输入条数，超出时分批请求后按顺序合并
+const (
+	openaiEmbeddingBatchSize = 2048
+	geminiEmbeddingBatchSize = 100
+	ollamaEmbeddingBatchSize = 512
+)
+
+// embedInBatches 按 size 切分输入逐批调用 fn，结果按原顺序拼接
+func embedInBatches(req *EmbeddingRequest, size int, fn func(batch *EmbeddingRequest) (*EmbeddingResult, error)) (*EmbeddingResult, error) {
+	result := &EmbeddingResult{Embeddings: make([][]float64, 0, len(req.Input))}
+	for start := 0; start < len(req.Input); start += size {
+		end := start + size
+		if end > len(req.Input) {
+			end = len(req.Input)
+		}
+		batch := *req
+		batch.Input = req.Input[start:end]
+		out, err := fn(&batch)
+		if err != nil {
+			return nil, err
+		}
+		if len(out.Embeddings) != len(batch.Input) {
+			return nil, fmt.Errorf("upstream returned %d embeddings for %d inputs", len(out.Embeddings), len(batch.Input))
+		}
+		result.Embeddings = append(result.Embeddings, out.Embeddings...)
+		result.PromptTokens += out.PromptTokens
+	}
+	return result, nil
+}
+
+// fitDimensions 上游忽略 dimensions 时截断向量并重新归一化（Matryoshka 方式）
+func fitDimensions(embeddings [][]float64, dimensions int) {
+	if dimensions <= 0 {
+		return
+	}
+	for i, vec := range embeddings {
+		if len(vec) <= dimensions {
+			continue
+		}
+		vec = vec[:dimensions]
+		norm := 0.0
+		for _, v := range vec {
+			norm += v * v
+		}
+		if norm = math.Sqrt(norm); norm > 0 {
+			for j := range vec {
+				vec[j] /= norm
+			}
+		}
+		embeddings[i] = vec
+	}
+}
+
+// selectEmbeddingProvider 按模型选择支持向量化的后端
+func selectEmbeddingProvider(model string) (EmbeddingProvider, string, error) {
+	provider, upstreamModel, err := selectProvider(model)
+	if err != nil {
+		return nil, "", err
+	}
+	embedder, ok := innerProvider(provider).(EmbeddingProvider)
+	if !ok {
+		return nil, "", fmt.Errorf("model %s does not support embeddings", model)
+	}
+	return embedder, upstreamModel, nil
+}
+
+// embedTexts 向量化并按需调整维度
+func embedTexts(ctx context.Context, embedder EmbeddingProvider, model string, input []string, dimensions int) (*EmbeddingResult, error) {
+	result, err := embedder.Embed(ctx, &EmbeddingRequest{Model: model, Input: input, Dimensions: dimensions})
+	if err != nil {
+		return nil, err
+	}
+	fitDimensions(result.Embeddings, dimensions)
+	return result, nil
+}
+
+// embeddingInput 解析 input 字段，支持字符串和字符串数组，token 数组无法转发给其它上游
+func embeddingInput(raw json.RawMessage) ([]string, error) {
+	var one string
+	if err := json.Unmarshal(raw, &one); err == nil {
+		return []string{one}, nil
+	}
+	var many []string
+	if err := json.Unmarshal(raw, &many); err == nil {
+		if len(many) == 0 {
+			return nil, fmt.Errorf("input must not be empty")
+		}
+		return many, nil
+	}
+	return nil, fmt.Errorf("input must be a string or an array of strings")
+}
+
+// OpenAIEmbeddingRequest /v1/embeddings 请求
+type OpenAIEmbeddingRequest struct {
+	Model          string          `json:"model"`
+	Input          json.RawMessage `json:"input"`
+	Dimensions     int             `json:"dimensions,omitempty"`
+	EncodingFormat string          `json:"encoding_format,omitempty"` // float(默认) / base64
+	User           string          `json:"user,omitempty"`
+}
+
+// OpenAIEmbedding 响应中的单条向量，base64 时 Embedding 为小端 float32 的 base64 字符串
+type OpenAIEmbedding struct {
+	Object    string      `json:"object"`
+	Index     int         `json:"index"`
+	Embedding interface{} `json:"embedding"`
+}
+
+type OpenAIEmbeddingResponse struct {
+	Object string            `json:"object"`
+	Data   []OpenAIEmbedding `json:"data"`
+	Model  string            `json:"model"`
+	Usage  struct {
+		PromptTokens int `json:"prompt_tokens"`
+		TotalTokens  int `json:"total_tokens"`
+	} `json:"usage"`
+}
+
+// embeddingBase64 与 OpenAI 一致，按小端 float32 编码
+func embeddingBase64(vec []float64) string {
+	buf := make([]byte, 4*len(vec))
+	for i, v := range vec {
+		binary.LittleEndian.PutUint32(buf[4*i:], math.Float32bits(float32(v)))
+	}
+	return base64.StdEncoding.EncodeToString(buf)
+}
+
+// OpenaiEmbeddingsHandler POST /v1/embeddings
+func OpenaiEmbeddingsHandler(c *gin.Context) {
+	var input OpenAIEmbeddingRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": "Invalid request", "type": "invalid_request_error"}})
+		return
+	}
+	texts, err := embeddingInput(input.Input)
+	if err == nil && input.EncodingFormat != "" && input.EncodingFormat != "float" && input.EncodingFormat != "base64" {
+		err = fmt.Errorf("encoding_format must be float or base64")
+	}
+	var embedder EmbeddingProvider
+	var upstreamModel string
+	if err == nil {
+		embedder, upstreamModel, err = selectEmbeddingProvider(input.Model)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"message": err.Error(), "type": "invalid_request_error"}})
+		return
+	}
+	result, err := embedTexts(c.Request.Context(), embedder, upstreamModel, texts, input.Dimensions)
+	if err != nil {
+		openaiUpstreamError(c, err)
+		return
+	}
+	resp := OpenAIEmbeddingResponse{Object: "list", Model: input.Model, Data: make([]OpenAIEmbedding, 0, len(result.Embeddings))}
+	for i, vec := range result.Embeddings {
+		item := OpenAIEmbedding{Object: "embedding", Index: i, Embedding: vec}
+		if input.EncodingFormat == "base64" {
+			item.Embedding = embeddingBase64(vec)
+		}
+		resp.Data = append(resp.Data, item)
+	}
+	resp.Usage.PromptTokens = result.PromptTokens
+	resp.Usage.TotalTokens = result.PromptTokens
+	c.JSON(http.StatusOK, resp)
+}
+
+// OllamaEmbedRequest /api/embed 请求
+type OllamaEmbedRequest struct {
+	Model      string          `json:"model"`
+	Input      json.RawMessage `json:"input"`
+	Truncate   *bool           `json:"truncate,omitempty"`
+	Dimensions int             `json:"dimensions,omitempty"`
+	KeepAlive  interface{}     `json:"keep_alive,omitempty"`
+	Options    OllamaOptions   `json:"options"`
+}
+
+type OllamaEmbedResponse struct {
+	Model           string      `json:"model"`
+	Embeddings      [][]float64 `json:"embeddings"`
+	TotalDuration   int64       `json:"total_duration,omitempty"`
+	LoadDuration    int64       `json:"load_duration,omitempty"`
+	PromptEvalCount int         `json:"prompt_eval_count,omitempty"`
+}
+
+// OllamaEmbedHandler POST /api/embed
+func OllamaEmbedHandler(c *gin.Context) {
+	var input OllamaEmbedRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	texts, err := embeddingInput(input.Input)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	embedder, upstreamModel, err := selectEmbeddingProvider(input.Model)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	start := time.Now()
+	result, err := embedTexts(c.Request.Context(), embedder, upstreamModel, texts, input.Dimensions)
+	if err != nil {
+		ollamaUpstreamError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, &OllamaEmbedResponse{
+		Model:           input.Model,
+		Embeddings:      result.Embeddings,
+		TotalDuration:   time.Since(start).Nanoseconds(),
+		PromptEvalCount: result.PromptTokens,
+	})
+}
+
+// OllamaEmbeddingsHandler POST /api/embeddings 旧版接口，一次一条 prompt
+func OllamaEmbeddingsHandler(c *gin.Context) {
+	var input struct {
+		Model  string `json:"model"`
+		Prompt string `json:"prompt"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	if input.Prompt == "" {
+		// 与 Ollama 一致，空 prompt 返回空向量
+		c.JSON(http.StatusOK, gin.H{"embedding": []float64{}})
+		return
+	}
+	embedder, upstreamModel, err := selectEmbeddingProvider(input.Model)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	result, err := embedTexts(c.Request.Context(), embedder, upstreamModel, []string{input.Prompt}, 0)
+	if err != nil {
+		ollamaUpstreamError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"embedding": result.Embeddings[0]})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestEmbeddingsRouting(t *testing.T) {
+	server := newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		switch r.URL.Path {
+		case "/openai/embeddings":
+			// 故意倒序返回，代理需要按 index 归位
+			fmt.Fprint(w, `{"data":[{"index":1,"embedding":[0,0,3,4]},{"index":0,"embedding":[1,0,0,0]}],"usage":{"prompt_tokens":6}}`)
+		case "/gemini/models/text-embedding-004:batchEmbedContents":
+			fmt.Fprint(w, `{"embeddings":[{"values":[0.1,0.2]},{"values":[0.3,0.4]}]}`)
+		case "/ollama/api/embed":
+			fmt.Fprint(w, `{"model":"nomic","embeddings":[[0.5,0.6]],"prompt_eval_count":3}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer server.Close()
+
+	XConfig = &Config{
+		ChatType:   "dify",
+		DifyAppMap: map[string]string{"dify-app": "app"},
+		Providers: []ProviderConfig{
+			{Name: "oa", Type: "openai", BaseUrl: server.URL + "/openai", Models: []string{"text-embedding-3-small"}},
+			{Name: "g", Type: "gemini", BaseUrl: server.URL + "/gemini", Models: []string{"text-embedding-004"}},
+			{Name: "o", Type: "ollama", BaseUrl: server.URL + "/ollama", Models: []string{"nomic"}},
+		},
+	}
+	defer func() { XConfig = nil }()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/embeddings", OpenaiEmbeddingsHandler)
+	router.POST("/api/embed", OllamaEmbedHandler)
+	router.POST("/api/embeddings", OllamaEmbeddingsHandler)
+	call := func(path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return w
+	}
+
+	// OpenAI：上游忽略 dimensions 时截断并归一化，base64 为小端 float32
+	w := call("/v1/embeddings", `{"model":"text-embedding-3-small","input":["a","b"],"dimensions":3,"encoding_format":"base64"}`)
+	resp := struct {
+		Data []struct {
+			Index     int    `json:"index"`
+			Embedding string `json:"embedding"`
+		} `json:"data"`
+		Usage struct {
+			PromptTokens int `json:"prompt_tokens"`
+		} `json:"usage"`
+	}{}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Data) != 2 || resp.Usage.PromptTokens != 6 {
+		t.Fatalf("openai = %d %s", w.Code, w.Body.String())
+	}
+	raw, _ := base64.StdEncoding.DecodeString(resp.Data[1].Embedding)
+	var vec []float32
+	for i := 0; i+4 <= len(raw); i += 4 {
+		vec = append(vec, math.Float32frombits(binary.LittleEndian.Uint32(raw[i:])))
+	}
+	if len(vec) != 3 || vec[2] != 1 {
+		t.Errorf("decoded = %v", vec)
+	}
+	if sent := server.received()[0].Body; !strings.Contains(string(sent), `"dimensions":3`) {
+		t.Errorf("openai request = %s", sent)
+	}
+
+	// Ollama /api/embed 路由到 Gemini
+	w = call("/api/embed", `{"model":"text-embedding-004","input":["x","y"]}`)
+	embed := OllamaEmbedResponse{}
+	_ = json.Unmarshal(w.Body.Bytes(), &embed)
+	if len(embed.Embeddings) != 2 || embed.Embeddings[1][1] != 0.4 {
+		t.Errorf("gemini = %s", w.Body.String())
+	}
+	if sent := server.received()[1].Body; !strings.Contains(string(sent), `"model":"models/text-embedding-004","content":{"parts":[{"text":"x"}]}`) {
+		t.Errorf("gemini request = %s", sent)
+	}
+
+	// 旧版 /api/embeddings 路由到 Ollama
+	w = call("/api/embeddings", `{"model":"nomic","prompt":"hello"}`)
+	if w.Body.String() != `{"embedding":[0.5,0.6]}` {
+		t.Errorf("ollama = %s", w.Body.String())
+	}
+
+	// FROM 向量模型的虚拟模型使用底层后端
+	virtualModels[ollamaModelName("my-embed")] = &VirtualModel{Name: "my-embed", From: "nomic", System: "ignored"}
+	defer func() { virtualModels = map[string]*VirtualModel{} }()
+	w = call("/api/embed", `{"model":"my-embed","input":"hello"}`)
+	if sent := server.received(); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `[0.5,0.6]`) || !strings.Contains(string(sent[len(sent)-1].Body), `"model":"nomic"`) {
+		t.Errorf("virtual = %d %s", w.Code, w.Body.String())
+	}
+
+	if w := call("/api/embed", `{"model":"dify-app","input":"x"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("unsupported = %d", w.Code)
+	}
+	if w := call("/v1/embeddings", `{"model":"text-embedding-3-small","input":[[1,2]]}`); w.Code != http.StatusBadRequest {
+		t.Errorf("token input = %d", w.Code)
+	}
+}
+
+func TestEmbedInBatches(t *testing.T) {
+	var sizes []int
+	req := &EmbeddingRequest{Input: []string{"a", "b", "c", "d", "e"}}
+	result, err := embedInBatches(req, 2, func(batch *EmbeddingRequest) (*EmbeddingResult, error) {
+		sizes = append(sizes, len(batch.Input))
+		out := &EmbeddingResult{PromptTokens: 1}
+		for _, s := range batch.Input {
+			out.Embeddings = append(out.Embeddings, []float64{float64(s[0])})
+		}
+		return out, nil
+	})
+	if err != nil || fmt.Sprint(sizes) != "[2 2 1]" || result.PromptTokens != 3 || result.Embeddings[4][0] != 'e' {
+		t.Errorf("sizes = %v, result = %+v, err = %v", sizes, result, err)
+	}
+	_, err = embedInBatches(req, 10, func(batch *EmbeddingRequest) (*EmbeddingResult, error) {
+		return &EmbeddingResult{}, nil
+	})
+	if err == nil {
+		t.Errorf("count mismatch should fail")
+	}
+}
@@ -1,5 +1,19 @@
 package main
 
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
 type GeminiRequest struct {
 	Model    string           `json:"model"`
 	Steam    bool             `json:"steam"`
@@ -31,3 +45,878 @@ type GeminiDelta struct {
 	Content string `json:"content"`
 	Role    string `json:"role"`
 }
+
+// 以下为 Google 原生 Gemini REST API 结构
+// https://ai.google.dev/api/generate-content
+
+type GeminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+type GeminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"`
+	InlineData       *GeminiBlob             `json:"inlineData,omitempty"`
+	FileData         *GeminiFileData         `json:"fileData,omitempty"`
+	FunctionCall     *GeminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *GeminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type GeminiBlob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"` // base64
+}
+
+type GeminiFileData struct {
+	MimeType string `json:"mimeType,omitempty"`
+	FileURI  string `json:"fileUri"`
+}
+
+type GeminiFunctionCall struct {
+	ID   string                 `json:"id,omitempty"`
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+type GeminiFunctionResponse struct {
+	ID       string                 `json:"id,omitempty"`
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+type GeminiFunctionDeclaration struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Parameters  interface{} `json:"parameters,omitempty"`
+}
+
+type GeminiTool struct {
+	FunctionDeclarations []GeminiFunctionDeclaration `json:"functionDeclarations,omitempty"`
+}
+
+type GeminiSafetySetting struct {
+	Category  string `json:"category"`
+	Threshold string `json:"threshold"`
+}
+
+type GeminiThinkingConfig struct {
+	IncludeThoughts bool `json:"includeThoughts,omitempty"`
+	ThinkingBudget  *int `json:"thinkingBudget,omitempty"`
+}
+
+type GeminiGenerationConfig struct {
+	Temperature      *float32              `json:"temperature,omitempty"`
+	TopP             *float32              `json:"topP,omitempty"`
+	TopK             *int                  `json:"topK,omitempty"`
+	CandidateCount   int                   `json:"candidateCount,omitempty"`
+	MaxOutputTokens  int                   `json:"maxOutputTokens,omitempty"`
+	StopSequences    []string              `json:"stopSequences,omitempty"`
+	ResponseMimeType string                `json:"responseMimeType,omitempty"`
+	ResponseSchema   interface{}           `json:"responseSchema,omitempty"`
+	Seed             *int                  `json:"seed,omitempty"`
+	PresencePenalty  *float32              `json:"presencePenalty,omitempty"`
+	FrequencyPenalty *float32              `json:"frequencyPenalty,omitempty"`
+	ThinkingConfig   *GeminiThinkingConfig `json:"thinkingConfig,omitempty"`
+}
+
+type GeminiGenerateContentRequest struct {
+	Contents          []GeminiContent         `json:"contents"`
+	SystemInstruction *GeminiContent          `json:"systemInstruction,omitempty"`
+	Tools             []GeminiTool            `json:"tools,omitempty"`
+	ToolConfig        interface{}             `json:"toolConfig,omitempty"`
+	SafetySettings    []GeminiSafetySetting   `json:"safetySettings,omitempty"`
+	GenerationConfig  *GeminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+// UnmarshalJSON 官方文档的 curl 示例使用 system_instruction/generation_config 等下划线写法，这里一并兼容
+func (r *GeminiGenerateContentRequest) UnmarshalJSON(data []byte) error {
+	type alias GeminiGenerateContentRequest
+	var aux struct {
+		alias
+		SystemInstruction *GeminiContent          `json:"system_instruction"`
+		GenerationConfig  *GeminiGenerationConfig `json:"generation_config"`
+		SafetySettings    []GeminiSafetySetting   `json:"safety_settings"`
+		ToolConfig        interface{}             `json:"tool_config"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	*r = GeminiGenerateContentRequest(aux.alias)
+	if r.SystemInstruction == nil {
+		r.SystemInstruction = aux.SystemInstruction
+	}
+	if r.GenerationConfig == nil {
+		r.GenerationConfig = aux.GenerationConfig
+	}
+	if r.SafetySettings == nil {
+		r.SafetySettings = aux.SafetySettings
+	}
+	if r.ToolConfig == nil {
+		r.ToolConfig = aux.ToolConfig
+	}
+	return nil
+}
+
+type GeminiCandidate struct {
+	Content      GeminiContent `json:"content"`
+	FinishReason string        `json:"finishReason,omitempty"`
+	Index        int           `json:"index"`
+}
+
+type GeminiUsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
+	ThoughtsTokenCount   int `json:"thoughtsTokenCount,omitempty"`
+}
+
+type GeminiPromptFeedback struct {
+	BlockReason string `json:"blockReason,omitempty"`
+}
+
+type GeminiGenerateContentResponse struct {
+	Candidates     []GeminiCandidate     `json:"candidates"`
+	PromptFeedback *GeminiPromptFeedback `json:"promptFeedback,omitempty"`
+	UsageMetadata  *GeminiUsageMetadata  `json:"usageMetadata,omitempty"`
+	ModelVersion   string                `json:"modelVersion,omitempty"`
+}
+
+type GeminiModelInfo struct {
+	Name                       string   `json:"name"`
+	Version                    string   `json:"version"`
+	DisplayName                string   `json:"displayName"`
+	Description                string   `json:"description,omitempty"`
+	InputTokenLimit            int      `json:"inputTokenLimit"`
+	OutputTokenLimit           int      `json:"outputTokenLimit"`
+	SupportedGenerationMethods []string `json:"supportedGenerationMethods"`
+}
+
+// GeminiToChatRequest Gemini generateContent 请求转为中立的 ChatCompletionRequest
+func GeminiToChatRequest(model string, in *GeminiGenerateContentRequest) *ChatCompletionRequest {
+	req := &ChatCompletionRequest{Model: model}
+
+	if in.SystemInstruction != nil {
+		var texts []string
+		for _, part := range in.SystemInstruction.Parts {
+			if part.Text != "" {
+				texts = append(texts, part.Text)
+			}
+		}
+		if len(texts) > 0 {
+			req.Messages = append(req.Messages, ChatCompletionMessage{
+				Role:    ChatMessageRoleSystem,
+				Content: strings.Join(texts, "\n"),
+			})
+		}
+	}
+
+	// Gemini 的函数调用没有必填 id，按函数名生成并让后续 functionResponse 对上
+	pendingCalls := map[string][]string{}
+	callSeq := 0
+	for _, content := range in.Contents {
+		role := ChatMessageRoleUser
+		if content.Role == "model" {
+			role = ChatMessageRoleAssistant
+		}
+		parts := make([]ChatMessagePart, 0, len(content.Parts))
+		var toolCalls []ToolCall
+		for _, part := range content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				id := part.FunctionCall.ID
+				if id == "" {
+					callSeq++
+					id = fmt.Sprintf("call_%s_%d", part.FunctionCall.Name, callSeq)
+				}
+				pendingCalls[part.FunctionCall.Name] = append(pendingCalls[part.FunctionCall.Name], id)
+				args, _ := json.Marshal(part.FunctionCall.Args)
+				toolCalls = append(toolCalls, ToolCall{
+					ID:       id,
+					Type:     "function",
+					Function: FunctionCall{Name: part.FunctionCall.Name, Arguments: string(args)},
+				})
+			case part.FunctionResponse != nil:
+				id := part.FunctionResponse.ID
+				if queue := pendingCalls[part.FunctionResponse.Name]; id == "" && len(queue) > 0 {
+					id = queue[0]
+					pendingCalls[part.FunctionResponse.Name] = queue[1:]
+				}
+				result, _ := json.Marshal(part.FunctionResponse.Response)
+				req.Messages = append(req.Messages, ChatCompletionMessage{
+					Role:       ChatMessageRoleTool,
+					Content:    string(result),
+					ToolCallID: id,
+					Name:       part.FunctionResponse.Name,
+				})
+			case part.InlineData != nil:
+				parts = append(parts, ChatMessagePart{
+					Type:     "image_url",
+					ImageURL: &ChatMessageImageURL{URL: "data:" + part.InlineData.MimeType + ";base64," + part.InlineData.Data},
+				})
+			case part.FileData != nil:
+				parts = append(parts, ChatMessagePart{
+					Type:     "image_url",
+					ImageURL: &ChatMessageImageURL{URL: part.FileData.FileURI},
+				})
+			case part.Thought:
+				// 历史中的思考内容不再回传给模型
+			case part.Text != "":
+				parts = append(parts, ChatMessagePart{Type: "text", Text: part.Text})
+			}
+		}
+		if len(parts) == 0 && len(toolCalls) == 0 {
+			continue
+		}
+		msg := ChatCompletionMessage{Role: role, ToolCalls: toolCalls}
+		if onlyText(parts) {
+			msg.Content = messageText(parts)
+		} else {
+			msg.Content = parts
+		}
+		req.Messages = append(req.Messages, msg)
+	}
+
+	for _, tool := range in.Tools {
+		for _, fn := range tool.FunctionDeclarations {
+			req.Tools = append(req.Tools, Tool{
+				Type: "function",
+				Function: &FunctionDefinition{
+					Name:        fn.Name,
+					Description: fn.Description,
+					Parameters:  fn.Parameters,
+				},
+			})
+		}
+	}
+
+	if cfg := in.GenerationConfig; cfg != nil {
+		req.Temperature = cfg.Temperature
+		if cfg.TopP != nil {
+			req.TopP = *cfg.TopP
+		}
+		req.TopK = cfg.TopK
+		if cfg.PresencePenalty != nil {
+			req.PresencePenalty = *cfg.PresencePenalty
+		}
+		if cfg.FrequencyPenalty != nil {
+			req.FrequencyPenalty = *cfg.FrequencyPenalty
+		}
+		req.MaxTokens = cfg.MaxOutputTokens
+		req.Stop = cfg.StopSequences
+		req.Seed = cfg.Seed
+		if cfg.ResponseMimeType == "application/json" {
+			req.ResponseFormat = &ChatCompletionResponseFormat{Type: "json_object"}
+			if schema, err := json.Marshal(cfg.ResponseSchema); err == nil && cfg.ResponseSchema != nil {
+				req.ResponseFormat = jsonSchemaFormat(schema)
+			}
+		}
+		// thinkingBudget 为 0 表示关闭思考，-1 为模型自行决定
+		if tc := cfg.ThinkingConfig; tc != nil && tc.ThinkingBudget != nil {
+			switch budget := *tc.ThinkingBudget; {
+			case budget == 0:
+				req.ReasoningEffort = reasoningEffortNone
+			case budget > 0:
+				req.ThinkingBudget = budget
+			}
+		}
+	}
+	return req
+}
+
+func onlyText(parts []ChatMessagePart) bool {
+	for _, part := range parts {
+		if part.Type != "text" {
+			return false
+		}
+	}
+	return true
+}
+
+// geminiFinishReason OpenAI finish_reason 转为 Gemini finishReason
+func geminiFinishReason(reason FinishReason) string {
+	switch reason {
+	case "":
+		return ""
+	case FinishReasonLength:
+		return "MAX_TOKENS"
+	case FinishReasonContentFilter:
+		return "SAFETY"
+	default:
+		return "STOP"
+	}
+}
+
+// ChatEventToGemini 中立事件渲染为 Gemini 响应分片
+func ChatEventToGemini(ev ChatEvent, model string) *GeminiGenerateContentResponse {
+	parts := make([]GeminiPart, 0)
+	if ev.Reasoning != "" {
+		parts = append(parts, GeminiPart{Text: ev.Reasoning, Thought: true})
+	}
+	if ev.Content != "" {
+		parts = append(parts, GeminiPart{Text: ev.Content})
+	}
+	for _, call := range ev.ToolCalls {
+		args := map[string]interface{}{}
+		if call.Function.Arguments != "" {
+			_ = json.Unmarshal([]byte(call.Function.Arguments), &args)
+		}
+		parts = append(parts, GeminiPart{FunctionCall: &GeminiFunctionCall{ID: call.ID, Name: call.Function.Name, Args: args}})
+	}
+	resp := &GeminiGenerateContentResponse{
+		Candidates: []GeminiCandidate{{
+			Content:      GeminiContent{Role: "model", Parts: parts},
+			FinishReason: geminiFinishReason(ev.FinishReason),
+		}},
+		ModelVersion: model,
+	}
+	if ev.Usage != nil {
+		resp.UsageMetadata = &GeminiUsageMetadata{
+			PromptTokenCount:     ev.Usage.PromptTokens,
+			CandidatesTokenCount: ev.Usage.CompletionTokens,
+			TotalTokenCount:      ev.Usage.TotalTokens,
+		}
+	}
+	return resp
+}
+
+// geminiError 按 Google API 的错误格式返回
+func geminiError(c *gin.Context, code int, message string) {
+	c.JSON(code, geminiErrorBody(code, message))
+}
+
+func geminiErrorBody(code int, message string) gin.H {
+	status := "INTERNAL"
+	switch code {
+	case http.StatusBadRequest:
+		status = "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		status = "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		status = "PERMISSION_DENIED"
+	case http.StatusNotFound:
+		status = "NOT_FOUND"
+	case http.StatusTooManyRequests:
+		status = "RESOURCE_EXHAUSTED"
+	}
+	return gin.H{"error": gin.H{"code": code, "message": message, "status": status}}
+}
+
+func geminiModelInfo(model string) GeminiModelInfo {
+	return GeminiModelInfo{
+		Name:                       "models/" + model,
+		Version:                    "001",
+		DisplayName:                model,
+		InputTokenLimit:            131072,
+		OutputTokenLimit:           8192,
+		SupportedGenerationMethods: []string{"generateContent", "streamGenerateContent"},
+	}
+}
+
+// GeminiModelsHandler GET /v1beta/models
+func GeminiModelsHandler(c *gin.Context) {
+	models := make([]GeminiModelInfo, 0)
+	for _, model := range catalogModels() {
+		models = append(models, geminiModelInfo(model))
+	}
+	c.JSON(http.StatusOK, gin.H{"models": models})
+}
+
+// GeminiModelHandler GET /v1beta/models/{model}
+func GeminiModelHandler(c *gin.Context) {
+	model := c.Param("action")
+	for _, m := range catalogModels() {
+		if m == model {
+			c.JSON(http.StatusOK, geminiModelInfo(model))
+			return
+		}
+	}
+	geminiError(c, http.StatusNotFound, "model not found: "+model)
+}
+
+// GeminiGenerateHandler POST /v1beta/models/{model}:generateContent 与 :streamGenerateContent
+func GeminiGenerateHandler(c *gin.Context) {
+	model, method, _ := strings.Cut(c.Param("action"), ":")
+	if method != "generateContent" && method != "streamGenerateContent" {
+		geminiError(c, http.StatusNotFound, "unsupported method: "+method)
+		return
+	}
+	var input GeminiGenerateContentRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		geminiError(c, http.StatusBadRequest, "Invalid request: "+err.Error())
+		return
+	}
+	if len(input.Contents) == 0 {
+		geminiError(c, http.StatusBadRequest, "contents is required")
+		return
+	}
+	// 后端只返回一个候选，多候选无法满足
+	if cfg := input.GenerationConfig; cfg != nil && cfg.CandidateCount > 1 {
+		geminiError(c, http.StatusBadRequest, "candidateCount > 1 is not supported")
+		return
+	}
+
+	provider, upstreamModel, err := selectProvider(model)
+	if err != nil {
+		geminiError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	provider = withThinkTags(c, model, withContinuation(c, model, provider))
+	req := GeminiToChatRequest(upstreamModel, &input)
+	req.Stream = method == "streamGenerateContent"
+	if XConfig.Debug {
+		log.Printf("Gemini 请求: %s -> %s\n", model, upstreamModel)
+	}
+
+	watch := watchClient(c, model)
+	if !req.Stream {
+		result := chatResult{}
+		err := provider.ChatStream(watch.ctx, req, watch.emit(func(ev ChatEvent) error {
+			result.add(ev)
+			return nil
+		}))
+		if watch.gone() {
+			return
+		}
+		err = watch.failure(err)
+		if err != nil {
+			geminiUpstreamError(c, err)
+			return
+		}
+		applyEventHeader(c, result.Header)
+		c.JSON(http.StatusOK, ChatEventToGemini(ChatEvent{
+			Content:      result.Content,
+			Reasoning:    result.Reasoning,
+			ToolCalls:    result.ToolCalls,
+			FinishReason: result.FinishReason,
+			Usage:        &result.Usage,
+		}, model))
+		return
+	}
+
+	// alt=sse 为 SSE，否则按官方行为输出一个逐步写出的 JSON 数组
+	sse := c.Query("alt") == "sse"
+	started := false
+	// start 在第一次写出前设置全部响应头，之后的事件头已无法再发出
+	start := func() {
+		if started {
+			return
+		}
+		started = true
+		if sse {
+			c.Header("content-Type", "text/event-stream")
+		} else {
+			c.Header("content-Type", "application/json")
+		}
+		c.Header("cache-control", "no-cache")
+		if !sse {
+			c.Status(http.StatusOK)
+			c.Writer.WriteString("[")
+		}
+	}
+	if sse {
+		// JSON 数组格式中间插不进保活，只有 SSE 发送
+		watch.keepalive(func() error {
+			start()
+			return PingData(c)
+		})
+	}
+	first := true
+	err = provider.ChatStream(watch.ctx, req, watch.emit(func(ev ChatEvent) error {
+		if !started {
+			applyEventHeader(c, ev.Header)
+		}
+		if !ev.hasOutput() {
+			return nil
+		}
+		chunk := ChatEventToGemini(ev, model)
+		start()
+		if sse {
+			return ObjectData(c, chunk)
+		}
+		if !first {
+			c.Writer.WriteString(",\r\n")
+		}
+		first = false
+		data, err := json.Marshal(chunk)
+		if err != nil {
+			return err
+		}
+		if _, err := c.Writer.Write(data); err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	}))
+	if watch.gone() {
+		return
+	}
+	err = watch.failure(err)
+	if err != nil && !started {
+		geminiUpstreamError(c, err)
+		return
+	}
+	// 输出开始后状态码已无法修改，错误作为最后一个元素 / 事件发给客户端
+	if err != nil {
+		log.Println("Gemini stream error:", err)
+		body := geminiErrorBody(geminiErrorStatus(err))
+		if sse {
+			_ = ObjectData(c, body)
+		} else if data, err := json.Marshal(body); err == nil {
+			if !first {
+				c.Writer.WriteString(",\r\n")
+			}
+			c.Writer.Write(data)
+		}
+	}
+	if !sse {
+		start()
+		c.Writer.WriteString("]")
+		c.Writer.Flush()
+	}
+}
+
+func geminiUpstreamError(c *gin.Context, err error) {
+	log.Println("Request error:", err)
+	code, message := geminiErrorStatus(err)
+	geminiError(c, code, message)
+}
+
+// geminiErrorStatus 上游错误沿用上游的状态码，其它错误按 502 返回
+func geminiErrorStatus(err error) (int, string) {
+	var upstream *UpstreamError
+	if errors.As(err, &upstream) {
+		return upstream.StatusCode, upstream.Body
+	}
+	return http.StatusBadGateway, err.Error()
+}
+
+const geminiDefaultBaseUrl = "https://generativelanguage.googleapis.com/v1beta"
+
+// GeminiProvider 通过 Gemini REST API 的 streamGenerateContent 对话
+type GeminiProvider struct {
+	Config *ProviderConfig
+}
+
+func (p *GeminiProvider) baseUrl() string {
+	if p.Config.BaseUrl == "" {
+		return geminiDefaultBaseUrl
+	}
+	return strings.TrimSuffix(p.Config.BaseUrl, "/")
+}
+
+// header authType 为 bearer 时使用访问令牌，默认使用 API key
+func (p *GeminiProvider) header() http.Header {
+	header := http.Header{}
+	if p.Config.AuthType == "bearer" {
+		header.Set("Authorization", "Bearer "+p.Config.APIKey)
+	} else {
+		header.Set("x-goog-api-key", p.Config.APIKey)
+	}
+	return header
+}
+
+// Embed 通过 batchEmbedContents 向量化，Gemini 不返回 token 用量
+func (p *GeminiProvider) Embed(ctx context.Context, req *EmbeddingRequest) (*EmbeddingResult, error) {
+	url := fmt.Sprintf("%s/models/%s:batchEmbedContents", p.baseUrl(), req.Model)
+	return embedInBatches(req, geminiEmbeddingBatchSize, func(batch *EmbeddingRequest) (*EmbeddingResult, error) {
+		type embedContentRequest struct {
+			Model                string        `json:"model"`
+			Content              GeminiContent `json:"content"`
+			OutputDimensionality int           `json:"outputDimensionality,omitempty"`
+		}
+		body := struct {
+			Requests []embedContentRequest `json:"requests"`
+		}{}
+		for _, text := range batch.Input {
+			body.Requests = append(body.Requests, embedContentRequest{
+				Model:                "models/" + batch.Model,
+				Content:              GeminiContent{Parts: []GeminiPart{{Text: text}}},
+				OutputDimensionality: batch.Dimensions,
+			})
+		}
+		payload, err := json.Marshal(&body)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := postUpstream(ctx, url, payload, p.header())
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		var out struct {
+			Embeddings []struct {
+				Values []float64 `json:"values"`
+			} `json:"embeddings"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			return nil, err
+		}
+		result := &EmbeddingResult{}
+		for _, e := range out.Embeddings {
+			result.Embeddings = append(result.Embeddings, e.Values)
+		}
+		return result, nil
+	})
+}
+
+func (p *GeminiProvider) ChatStream(ctx context.Context, req *ChatCompletionRequest, emit func(ChatEvent) error) error {
+	req, ignored := adaptSampling(req, geminiSampling)
+	if err := reportIgnoredParams(emit, ignored); err != nil {
+		return err
+	}
+	payload, err := json.Marshal(ChatToGeminiRequest(req, p.Config.SafetySettings))
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse", p.baseUrl(), req.Model)
+	resp, err := postUpstream(ctx, url, payload, p.header())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	hasToolCalls := false
+	callSeq := 0
+	return readSSEData(resp.Body, func(data string) error {
+		chunk := GeminiGenerateContentResponse{}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			log.Println("Unmarshal error:", err)
+			return nil
+		}
+		if len(chunk.Candidates) == 0 {
+			if chunk.PromptFeedback != nil && chunk.PromptFeedback.BlockReason != "" {
+				return emit(ChatEvent{FinishReason: FinishReasonContentFilter, Usage: geminiUsage(chunk.UsageMetadata)})
+			}
+			return nil
+		}
+		candidate := chunk.Candidates[0]
+		ev := ChatEvent{}
+		for _, part := range candidate.Content.Parts {
+			switch {
+			case part.FunctionCall != nil:
+				id := part.FunctionCall.ID
+				if id == "" {
+					callSeq++
+					id = fmt.Sprintf("call_%s_%d", part.FunctionCall.Name, callSeq)
+				}
+				args, _ := json.Marshal(part.FunctionCall.Args)
+				ev.ToolCalls = append(ev.ToolCalls, ToolCall{
+					ID:       id,
+					Type:     "function",
+					Function: FunctionCall{Name: part.FunctionCall.Name, Arguments: string(args)},
+				})
+				hasToolCalls = true
+			case part.Thought:
+				ev.Reasoning += part.Text
+			default:
+				ev.Content += part.Text
+			}
+		}
+		if candidate.FinishReason != "" {
+			ev.FinishReason = geminiToFinishReason(candidate.FinishReason, hasToolCalls)
+			ev.Usage = geminiUsage(chunk.UsageMetadata)
+		}
+		if ev.Content == "" && ev.Reasoning == "" && len(ev.ToolCalls) == 0 && ev.FinishReason == "" {
+			return nil
+		}
+		return emit(ev)
+	})
+}
+
+// ChatToGeminiRequest 中立请求转为 Gemini generateContent 请求
+func ChatToGeminiRequest(req *ChatCompletionRequest, safety []GeminiSafetySetting) *GeminiGenerateContentRequest {
+	out := &GeminiGenerateContentRequest{SafetySettings: safety}
+	// functionResponse 需要函数名，按 tool_call_id 从之前的 assistant 消息中找回
+	callNames := map[string]string{}
+	var system []GeminiPart
+	for _, m := range req.Messages {
+		var role string
+		var parts []GeminiPart
+		switch m.Role {
+		case ChatMessageRoleSystem, ChatMessageRoleDeveloper:
+			if text := messageText(m.Content); text != "" {
+				system = append(system, GeminiPart{Text: text})
+			}
+			continue
+		case ChatMessageRoleTool, ChatMessageRoleFunction:
+			role = "user"
+			name := callNames[m.ToolCallID]
+			if name == "" {
+				name = m.Name
+			}
+			text := messageText(m.Content)
+			response := map[string]interface{}{}
+			if err := json.Unmarshal([]byte(text), &response); err != nil {
+				response = map[string]interface{}{"content": text}
+			}
+			parts = append(parts, GeminiPart{FunctionResponse: &GeminiFunctionResponse{Name: name, Response: response}})
+		case ChatMessageRoleAssistant:
+			role = "model"
+			if text := messageText(m.Content); text != "" {
+				parts = append(parts, GeminiPart{Text: text})
+			}
+			for _, call := range m.ToolCalls {
+				callNames[call.ID] = call.Function.Name
+				args := map[string]interface{}{}
+				if call.Function.Arguments != "" {
+					_ = json.Unmarshal([]byte(call.Function.Arguments), &args)
+				}
+				parts = append(parts, GeminiPart{FunctionCall: &GeminiFunctionCall{Name: call.Function.Name, Args: args}})
+			}
+		default:
+			role = "user"
+			for _, part := range messageParts(m.Content) {
+				if gp, ok := chatPartToGemini(part); ok {
+					parts = append(parts, gp)
+				}
+			}
+		}
+		if len(parts) == 0 {
+			continue
+		}
+		// 连续同角色的消息合并，多个工具结果会放在同一轮里
+		if n := len(out.Contents); n > 0 && out.Contents[n-1].Role == role {
+			out.Contents[n-1].Parts = append(out.Contents[n-1].Parts, parts...)
+			continue
+		}
+		out.Contents = append(out.Contents, GeminiContent{Role: role, Parts: parts})
+	}
+	switch {
+	case len(out.Contents) == 0:
+		// 只有 system 时作为用户输入发送，contents 不能为空
+		if len(system) > 0 {
+			out.Contents = []GeminiContent{{Role: "user", Parts: system}}
+			system = nil
+		}
+	case out.Contents[0].Role != "user":
+		out.Contents = append([]GeminiContent{{Role: "user", Parts: []GeminiPart{{Text: leadingUserTurn}}}}, out.Contents...)
+	}
+	if len(system) > 0 {
+		out.SystemInstruction = &GeminiContent{Parts: system}
+	}
+
+	var declarations []GeminiFunctionDeclaration
+	for _, tool := range req.Tools {
+		if tool.Function == nil {
+			continue
+		}
+		declarations = append(declarations, GeminiFunctionDeclaration{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			Parameters:  cleanGeminiSchema(tool.Function.Parameters),
+		})
+	}
+	if len(declarations) > 0 {
+		out.Tools = []GeminiTool{{FunctionDeclarations: declarations}}
+	}
+
+	cfg := &GeminiGenerationConfig{
+		MaxOutputTokens: req.MaxTokens,
+		StopSequences:   req.Stop,
+		Seed:            req.Seed,
+	}
+	if req.MaxCompletionTokens > 0 {
+		cfg.MaxOutputTokens = req.MaxCompletionTokens
+	}
+	cfg.Temperature = req.Temperature
+	if req.TopP != 0 {
+		cfg.TopP = &req.TopP
+	}
+	cfg.TopK = req.TopK
+	if req.PresencePenalty != 0 {
+		cfg.PresencePenalty = &req.PresencePenalty
+	}
+	if req.FrequencyPenalty != 0 {
+		cfg.FrequencyPenalty = &req.FrequencyPenalty
+	}
+	if format := req.ResponseFormat; format != nil && (format.Type == "json_object" || format.Type == "json_schema") {
+		cfg.ResponseMimeType = "application/json"
+		if format.JSONSchema != nil && len(format.JSONSchema.Schema) > 0 {
+			cfg.ResponseSchema = cleanGeminiSchema(format.JSONSchema.Schema)
+		}
+	}
+	if budget, ok := reasoningBudget(req); ok {
+		cfg.ThinkingConfig = &GeminiThinkingConfig{IncludeThoughts: budget > 0, ThinkingBudget: &budget}
+	}
+	out.GenerationConfig = cfg
+	return out
+}
+
+// chatPartToGemini 图片分片中 data URL 转为 inlineData，其它 URL 作为 fileData
+func chatPartToGemini(part ChatMessagePart) (GeminiPart, bool) {
+	if part.ImageURL == nil {
+		if part.Text == "" {
+			return GeminiPart{}, false
+		}
+		return GeminiPart{Text: part.Text}, true
+	}
+	url := part.ImageURL.URL
+	if strings.HasPrefix(url, "data:") {
+		meta, data, ok := strings.Cut(strings.TrimPrefix(url, "data:"), ",")
+		if !ok {
+			return GeminiPart{}, false
+		}
+		return GeminiPart{InlineData: &GeminiBlob{MimeType: strings.TrimSuffix(meta, ";base64"), Data: data}}, true
+	}
+	return GeminiPart{FileData: &GeminiFileData{MimeType: mime.TypeByExtension(path.Ext(url)), FileURI: url}}, true
+}
+
+// cleanGeminiSchema 去掉 Gemini 不接受的 JSON Schema 关键字
+func cleanGeminiSchema(schema interface{}) interface{} {
+	data, err := json.Marshal(schema)
+	if err != nil || string(data) == "null" {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil
+	}
+	var walk func(v interface{}) interface{}
+	walk = func(v interface{}) interface{} {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			delete(node, "$schema")
+			delete(node, "additionalProperties")
+			delete(node, "strict")
+			for key, child := range node {
+				node[key] = walk(child)
+			}
+			return node
+		case []interface{}:
+			for i, child := range node {
+				node[i] = walk(child)
+			}
+			return node
+		default:
+			return v
+		}
+	}
+	return walk(v)
+}
+
+// geminiToFinishReason Gemini finishReason 转为 OpenAI finish_reason
+func geminiToFinishReason(reason string, hasToolCalls bool) FinishReason {
+	switch reason {
+	case "MAX_TOKENS":
+		return FinishReasonLength
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		return FinishReasonContentFilter
+	default:
+		if hasToolCalls {
+			return FinishReasonToolCalls
+		}
+		return FinishReasonStop
+	}
+}
+
+func geminiUsage(meta *GeminiUsageMetadata) *Usage {
+	if meta == nil {
+		return nil
+	}
+	return &Usage{
+		PromptTokens:     meta.PromptTokenCount,
+		CompletionTokens: meta.CandidatesTokenCount + meta.ThoughtsTokenCount,
+		TotalTokens:      meta.TotalTokenCount,
+	}
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// geminiStandIn 模拟 Gemini streamGenerateContent，事件以 CRLF 分隔
+func geminiStandIn(t *testing.T) *standIn {
+	return newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		if r.URL.Path != "/models/gemini-test:streamGenerateContent" || r.URL.Query().Get("alt") != "sse" {
+			t.Errorf("unexpected url: %s", r.URL.String())
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"let me think\",\"thought\":true}]},\"index\":0}]}\r\n\r\n")
+		fmt.Fprint(w, "data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"text\":\"Hello\"}]},\"index\":0}]}\r\n\r\n")
+		fmt.Fprint(w, "data: {\"candidates\":[{\"content\":{\"role\":\"model\",\"parts\":[{\"functionCall\":{\"name\":\"get_weather\",\"args\":{\"city\":\"Beijing\"}}}]},\"finishReason\":\"STOP\",\"index\":0}],"+
+			"\"usageMetadata\":{\"promptTokenCount\":10,\"candidatesTokenCount\":5,\"totalTokenCount\":15}}\r\n\r\n")
+	})
+}
+
+func TestGeminiProviderStream(t *testing.T) {
+	server := geminiStandIn(t)
+	defer server.Close()
+
+	provider := &GeminiProvider{Config: &ProviderConfig{
+		Type:           "gemini",
+		BaseUrl:        server.URL,
+		APIKey:         "test-key",
+		SafetySettings: []GeminiSafetySetting{{Category: "HARM_CATEGORY_HARASSMENT", Threshold: "BLOCK_NONE"}},
+	}}
+	req := &ChatCompletionRequest{
+		Model:     "gemini-test",
+		MaxTokens: 256,
+		Messages: []ChatCompletionMessage{
+			{Role: "system", Content: "be brief"},
+			{Role: "user", Content: []interface{}{
+				map[string]interface{}{"type": "text", "text": "what is this"},
+				map[string]interface{}{"type": "image_url", "image_url": map[string]interface{}{"url": "data:image/png;base64,iVBORw0KGgo="}},
+			}},
+			{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_1", Type: "function", Function: FunctionCall{Name: "lookup", Arguments: `{"q":"x"}`}}}},
+			{Role: "tool", ToolCallID: "call_1", Content: `{"result":"ok"}`},
+		},
+		Tools: []Tool{{Type: "function", Function: &FunctionDefinition{
+			Name:       "get_weather",
+			Parameters: map[string]interface{}{"type": "object", "additionalProperties": false, "properties": map[string]interface{}{"city": map[string]interface{}{"type": "string"}}},
+		}}},
+	}
+
+	var events []ChatEvent
+	err := provider.ChatStream(context.Background(), req, func(ev ChatEvent) error {
+		events = append(events, ev)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ChatStream: %v", err)
+	}
+
+	got := standInBodies[GeminiGenerateContentRequest](server)[0]
+	header := server.received()[0].Header
+	if header.Get("x-goog-api-key") != "test-key" {
+		t.Errorf("api key header = %q", header.Get("x-goog-api-key"))
+	}
+	if got.SystemInstruction == nil || got.SystemInstruction.Parts[0].Text != "be brief" {
+		t.Errorf("systemInstruction = %+v", got.SystemInstruction)
+	}
+	if len(got.Contents) != 3 {
+		t.Fatalf("contents = %+v", got.Contents)
+	}
+	if blob := got.Contents[0].Parts[1].InlineData; blob == nil || blob.MimeType != "image/png" || blob.Data != "iVBORw0KGgo=" {
+		t.Errorf("inlineData = %+v", got.Contents[0].Parts[1])
+	}
+	if call := got.Contents[1].Parts[0].FunctionCall; got.Contents[1].Role != "model" || call == nil || call.Args["q"] != "x" {
+		t.Errorf("functionCall = %+v", got.Contents[1])
+	}
+	if resp := got.Contents[2].Parts[0].FunctionResponse; resp == nil || resp.Name != "lookup" || resp.Response["result"] != "ok" {
+		t.Errorf("functionResponse = %+v", got.Contents[2])
+	}
+	params := got.Tools[0].FunctionDeclarations[0].Parameters.(map[string]interface{})
+	if _, ok := params["additionalProperties"]; ok {
+		t.Errorf("additionalProperties should be removed: %v", params)
+	}
+	if len(got.SafetySettings) != 1 || got.GenerationConfig.MaxOutputTokens != 256 {
+		t.Errorf("safety/generationConfig = %+v %+v", got.SafetySettings, got.GenerationConfig)
+	}
+
+	if len(events) != 3 {
+		t.Fatalf("events = %+v", events)
+	}
+	if events[0].Reasoning != "let me think" || events[1].Content != "Hello" {
+		t.Errorf("text events = %+v", events[:2])
+	}
+	last := events[2]
+	if len(last.ToolCalls) != 1 || last.ToolCalls[0].Function.Arguments != `{"city":"Beijing"}` {
+		t.Errorf("tool calls = %+v", last.ToolCalls)
+	}
+	if last.FinishReason != FinishReasonToolCalls || last.Usage == nil || last.Usage.TotalTokens != 15 {
+		t.Errorf("finish = %s usage = %+v", last.FinishReason, last.Usage)
+	}
+}
+
+func TestGeminiProviderBearerError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("Authorization = %q", r.Header.Get("Authorization"))
+		}
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"error":{"code":403,"message":"denied","status":"PERMISSION_DENIED"}}`)
+	}))
+	defer server.Close()
+
+	provider := &GeminiProvider{Config: &ProviderConfig{BaseUrl: server.URL, APIKey: "token", AuthType: "bearer"}}
+	err := provider.ChatStream(context.Background(), &ChatCompletionRequest{
+		Model:    "gemini-test",
+		Messages: []ChatCompletionMessage{{Role: "user", Content: "hi"}},
+	}, func(ChatEvent) error { return nil })
+	var upstream *UpstreamError
+	if !errors.As(err, &upstream) || upstream.StatusCode != http.StatusForbidden {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestOllamaChatAnsweredByGemini(t *testing.T) {
+	server := geminiStandIn(t)
+	defer server.Close()
+
+	XConfig = &Config{
+		ChatType:  "dify",
+		Providers: []ProviderConfig{{Name: "google", Type: "gemini", BaseUrl: server.URL, Models: []string{"gemini-test"}}},
+	}
+	defer func() { XConfig = nil }()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/chat", chatHandlerSteam)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/chat", strings.NewReader(`{"model":"gemini-test","messages":[{"role":"user","content":"hi"}]}`)))
+
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\r\n")
+	if len(lines) != 3 {
+		t.Fatalf("lines = %q", lines)
+	}
+	var thought, first, last OllamaResponse
+	_ = json.Unmarshal([]byte(lines[0]), &thought)
+	_ = json.Unmarshal([]byte(lines[1]), &first)
+	_ = json.Unmarshal([]byte(lines[2]), &last)
+	if thought.Message.Thinking != "let me think" || thought.Message.Content != "" {
+		t.Errorf("thought = %+v", thought)
+	}
+	if first.Message.Content != "Hello" || first.Done {
+		t.Errorf("first = %+v", first)
+	}
+	if !last.Done || last.EvalCount != 5 || len(last.Message.ToolCalls) != 1 || last.Message.ToolCalls[0].Function.Arguments["city"] != "Beijing" {
+		t.Errorf("last = %+v", last)
+	}
+}
+
+func TestGeminiGenerateHandler(t *testing.T) {
+	server := newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		var body ChatCompletionRequest
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if body.Model == "gpt-limited" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"error":{"message":"slow down"}}`)
+			return
+		}
+		if body.Model == "gpt-broken" {
+			// 输出一段后流出错
+			writeSSE(w, openaiContent("Hel", ""), openaiChunk(`{"tool_calls":[{"index":100000,"function":{"name":"f"}}]}`, ""))
+			return
+		}
+		writeSSE(w, openaiContent("Hel", ""), openaiContent("lo", "stop"), openaiUsage(3, 2), "[DONE]")
+	})
+	defer server.Close()
+	XConfig = &Config{
+		Providers: []ProviderConfig{{Name: "openai", Type: "openai", BaseUrl: server.URL, Models: []string{"gpt-test", "gpt-limited", "gpt-broken"}}},
+	}
+	defer func() { XConfig = nil }()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1beta/models/:action", GeminiGenerateHandler)
+	generate := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body := `{"contents":[{"role":"user","parts":[{"text":"hi"}]}],"generationConfig":{"topK":20}}`
+		router.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return w
+	}
+	text := func(chunks []GeminiGenerateContentResponse) string {
+		var b strings.Builder
+		for _, chunk := range chunks {
+			for _, part := range chunk.Candidates[0].Content.Parts {
+				b.WriteString(part.Text)
+			}
+		}
+		return b.String()
+	}
+
+	// SSE：响应头（含被忽略参数）在第一段数据之前发出
+	// Result().Header 是第一次写出时的快照，之后再设置的头不会出现在其中
+	w := generate("/v1beta/models/gpt-test:streamGenerateContent?alt=sse")
+	if header := w.Result().Header; header.Get("Content-Type") != "text/event-stream" || header.Get("Cache-Control") != "no-cache" || header.Get(ignoredParamsHeader) != "top_k" {
+		t.Fatalf("sse headers = %v", header)
+	}
+	var chunks []GeminiGenerateContentResponse
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			var chunk GeminiGenerateContentResponse
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				t.Fatalf("sse chunk %q: %v", data, err)
+			}
+			chunks = append(chunks, chunk)
+		}
+	}
+	if text(chunks) != "Hello" || chunks[len(chunks)-1].UsageMetadata == nil || chunks[len(chunks)-1].UsageMetadata.TotalTokenCount != 5 {
+		t.Fatalf("sse body:\n%s", w.Body.String())
+	}
+
+	// 默认输出 JSON 数组
+	w = generate("/v1beta/models/gpt-test:streamGenerateContent")
+	if header := w.Result().Header; header.Get("Content-Type") != "application/json" || header.Get("Cache-Control") != "no-cache" || header.Get(ignoredParamsHeader) != "top_k" {
+		t.Fatalf("array headers = %v", header)
+	}
+	chunks = nil
+	if err := json.Unmarshal(w.Body.Bytes(), &chunks); err != nil || text(chunks) != "Hello" {
+		t.Fatalf("array body (%v):\n%s", err, w.Body.String())
+	}
+
+	// 上游错误按 Google API 格式返回，流式与非流式一致
+	for _, method := range []string{"generateContent", "streamGenerateContent"} {
+		w = generate("/v1beta/models/gpt-limited:" + method)
+		var out struct {
+			Error struct {
+				Code   int    `json:"code"`
+				Status string `json:"status"`
+			} `json:"error"`
+		}
+		_ = json.Unmarshal(w.Body.Bytes(), &out)
+		if w.Code != http.StatusTooManyRequests || out.Error.Code != http.StatusTooManyRequests || out.Error.Status != "RESOURCE_EXHAUSTED" {
+			t.Fatalf("%s error: %d %s", method, w.Code, w.Body.String())
+		}
+	}
+
+	// 输出开始后出错：SSE 以错误事件结束，JSON 数组的最后一个元素为错误
+	type errorChunk struct {
+		Error *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	w = generate("/v1beta/models/gpt-broken:streamGenerateContent?alt=sse")
+	var events []string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if data, ok := strings.CutPrefix(line, "data: "); ok {
+			events = append(events, data)
+		}
+	}
+	var last errorChunk
+	if len(events) != 2 || json.Unmarshal([]byte(events[1]), &last) != nil || last.Error == nil || last.Error.Code != http.StatusBadGateway {
+		t.Fatalf("sse late error:\n%s", w.Body.String())
+	}
+	w = generate("/v1beta/models/gpt-broken:streamGenerateContent")
+	var array []errorChunk
+	if err := json.Unmarshal(w.Body.Bytes(), &array); err != nil || len(array) != 2 || array[0].Error != nil || array[1].Error == nil {
+		t.Fatalf("array late error (%v):\n%s", err, w.Body.String())
+	}
+
+	// 只支持单个候选
+	w = httptest.NewRecorder()
+	body := `{"contents":[{"role":"user","parts":[{"text":"hi"}]}],"generationConfig":{"candidateCount":2}}`
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/v1beta/models/gpt-test:generateContent", strings.NewReader(body)))
+	if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "candidateCount") {
+		t.Fatalf("candidateCount: %d %s", w.Code, w.Body.String())
+	}
+
+	w = generate("/v1beta/models/gpt-test:countTokens")
+	if w.Code != http.StatusNotFound || !strings.Contains(w.Body.String(), `"status":"NOT_FOUND"`) {
+		t.Fatalf("unsupported method: %d %s", w.Code, w.Body.String())
+	}
+}
@@ -4,41 +4,17 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"net/http"
-	"strings"
+
+	"ollamaproxy/sse"
 
 	"github.com/gin-gonic/gin"
 )
 
-type stringWriter interface {
-	io.Writer
-	writeString(string) (int, error)
-}
-
-type stringWrapper struct {
-	io.Writer
-}
-
-func (w stringWrapper) writeString(str string) (int, error) {
-	return w.Writer.Write([]byte(str))
-}
-
-func checkWriter(writer io.Writer) stringWriter {
-	if w, ok := writer.(stringWriter); ok {
-		return w
-	} else {
-		return stringWrapper{writer}
-	}
-}
-
 var contentType = []string{"text/event-stream"}
 var noCache = []string{"no-cache"}
 
-var dataReplacer = strings.NewReplacer(
-	"\n", "\n",
-	"\r", "\\r")
-
+// CustomEvent gin 的 SSE Render，按 sse 包的格式输出；Data 为字符串时原样作为 data，其它类型序列化为 JSON
 type CustomEvent struct {
 	Event string
 	Id    string
@@ -46,22 +22,17 @@ type CustomEvent struct {
 	Data  interface{}
 }
 
-func encode(writer io.Writer, event CustomEvent) error {
-	w := checkWriter(writer)
-	return writeData(w, event.Data)
-}
-
-func writeData(w stringWriter, data interface{}) error {
-	dataReplacer.WriteString(w, fmt.Sprint(data))
-	if strings.HasPrefix(data.(string), "data") {
-		w.writeString("\n\n")
-	}
-	return nil
-}
-
 func (r CustomEvent) Render(w http.ResponseWriter) error {
 	r.WriteContentType(w)
-	return encode(w, r)
+	data, ok := r.Data.(string)
+	if !ok {
+		jsonData, err := json.Marshal(r.Data)
+		if err != nil {
+			return err
+		}
+		data = string(jsonData)
+	}
+	return sse.Encode(w, sse.Event{Event: r.Event, ID: r.Id, Retry: int(r.Retry), Data: data})
 }
 
 func (r CustomEvent) WriteContentType(w http.ResponseWriter) {
@@ -74,9 +45,10 @@ func (r CustomEvent) WriteContentType(w http.ResponseWriter) {
 }
 
 func StringData(c *gin.Context, str string) error {
-	//str = strings.TrimPrefix(str, "data: ")
-	//str = strings.TrimSuffix(str, "\r")
-	c.Render(-1, CustomEvent{Data: "data: " + str})
+	// 直接渲染而不经过 c.Render，写回失败时返回错误，调用方据此取消上游请求
+	if err := (CustomEvent{Data: str}).Render(c.Writer); err != nil {
+		return err
+	}
 	if flusher, ok := c.Writer.(http.Flusher); ok {
 		flusher.Flush()
 	} else {
@@ -86,7 +58,9 @@ func StringData(c *gin.Context, str string) error {
 }
 
 func PingData(c *gin.Context) error {
-	c.Writer.Write([]byte(": PING\n\n"))
+	if err := sse.EncodeComment(c.Writer, "PING"); err != nil {
+		return err
+	}
 	if flusher, ok := c.Writer.(http.Flusher); ok {
 		flusher.Flush()
 	} else {
@@ -106,6 +80,19 @@ func ObjectData(c *gin.Context, object interface{}) error {
 	return StringData(c, string(jsonData))
 }
 
+// EventData 写出带事件名的 SSE 消息（event: xxx），Responses / Anthropic 等协议按事件名区分消息类型
+func EventData(c *gin.Context, event string, object interface{}) error {
+	jsonData, err := json.Marshal(object)
+	if err != nil {
+		return fmt.Errorf("error marshalling object: %w", err)
+	}
+	if err := sse.Encode(c.Writer, sse.Event{Event: event, Data: string(jsonData)}); err != nil {
+		return err
+	}
+	c.Writer.Flush()
+	return nil
+}
+
 func Done(c *gin.Context) {
 	_ = StringData(c, "[DONE]")
 }
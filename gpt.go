@@ -1,9 +1,9 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -106,10 +106,10 @@ type ChatMessagePart struct {
 }
 
 type ChatCompletionMessage struct {
-	Role         string      `json:"role"`
-	Content      interface{} `json:"content,omitempty"`
-	Refusal      string      `json:"refusal,omitempty"`
-	MultiContent []ChatMessagePart
+	Role         string            `json:"role"`
+	Content      interface{}       `json:"content,omitempty"`
+	Refusal      string            `json:"refusal,omitempty"`
+	MultiContent []ChatMessagePart `json:"-"`
 
 	// This property isn't in the official documentation, but it's in
 	// the documentation for the official library for python:
@@ -133,10 +133,10 @@ type ChatCompletionMessage struct {
 }
 
 type ChatCompletionResponseFormatJSONSchema struct {
-	Name        string         `json:"name"`
-	Description string         `json:"description,omitempty"`
-	Schema      json.Marshaler `json:"schema"`
-	Strict      bool           `json:"strict"`
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema"`
+	Strict      bool            `json:"strict"`
 }
 
 type ChatCompletionResponseFormat struct {
@@ -185,7 +185,7 @@ type ChatCompletionRequest struct {
 	// MaxCompletionTokens An upper bound for the number of tokens that can be generated for a completion,
 	// including visible output tokens and reasoning tokens https://platform.openai.com/docs/guides/reasoning
 	MaxCompletionTokens int                           `json:"max_completion_tokens,omitempty"`
-	Temperature         float32                       `json:"temperature,omitempty"`
+	Temperature         *float32                      `json:"temperature,omitempty"`
 	TopP                float32                       `json:"top_p,omitempty"`
 	N                   int                           `json:"n,omitempty"`
 	Stream              bool                          `json:"stream,omitempty"`
@@ -223,6 +223,11 @@ type ChatCompletionRequest struct {
 	Store bool `json:"store,omitempty"`
 	// Controls effort on reasoning for reasoning models. It can be set to "low", "medium", or "high".
 	ReasoningEffort string `json:"reasoning_effort,omitempty"`
+	// TopK / RepetitionPenalty 非 OpenAI 标准参数，来自 Ollama options 的 top_k / repeat_penalty，vLLM 等兼容上游支持
+	TopK              *int     `json:"top_k,omitempty"`
+	RepetitionPenalty *float32 `json:"repetition_penalty,omitempty"`
+	// ThinkingBudget 思考 token 预算（来自 Anthropic thinking.budget_tokens 等），不直接发给 OpenAI 兼容上游
+	ThinkingBudget int `json:"-"`
 	// Metadata to store with the completion.
 	Metadata map[string]string `json:"metadata,omitempty"`
 	// Configuration for a predicted output.
@@ -267,13 +272,24 @@ type Profanity struct {
 	Detected bool `json:"detected"`
 }
 
+type ProtectedMaterial struct {
+	Filtered bool `json:"filtered"`
+	Detected bool `json:"detected"`
+	Citation *struct {
+		URL     string `json:"URL,omitempty"`
+		License string `json:"license,omitempty"`
+	} `json:"citation,omitempty"`
+}
+
 type ContentFilterResults struct {
-	Hate      Hate      `json:"hate,omitempty"`
-	SelfHarm  SelfHarm  `json:"self_harm,omitempty"`
-	Sexual    Sexual    `json:"sexual,omitempty"`
-	Violence  Violence  `json:"violence,omitempty"`
-	JailBreak JailBreak `json:"jailbreak,omitempty"`
-	Profanity Profanity `json:"profanity,omitempty"`
+	Hate                  Hate               `json:"hate,omitempty"`
+	SelfHarm              SelfHarm           `json:"self_harm,omitempty"`
+	Sexual                Sexual             `json:"sexual,omitempty"`
+	Violence              Violence           `json:"violence,omitempty"`
+	JailBreak             JailBreak          `json:"jailbreak,omitempty"`
+	Profanity             Profanity          `json:"profanity,omitempty"`
+	ProtectedMaterialText *ProtectedMaterial `json:"protected_material_text,omitempty"`
+	ProtectedMaterialCode *ProtectedMaterial `json:"protected_material_code,omitempty"`
 }
 
 type ToolCall struct {
@@ -444,30 +460,45 @@ func CreateStreamMessage(chatId string, now int64, req *ChatCompletionRequest, f
 	}
 }
 
+// GpttoClaudeRequest 消息转为 Anthropic messages：system 需先由 splitSystem 取出放到顶层 system，
+// 工具调用转为 tool_use 块，工具结果作为 user 的 tool_result 块发送，图片转为 image 块，
+// 连续同角色的消息合并，保证 user / assistant 交替且从 user 开始
 func GpttoClaudeRequest(input []ChatCompletionMessage) []ClaudeMessageItem {
 	msg := make([]ClaudeMessageItem, 0, len(input))
 	for _, m := range input {
-		if m.Role == "system" {
-			m.Role = "assistant"
-		}
-		str := ""
-		if m.Content == nil {
-			m.Content = ""
+		role := ChatMessageRoleUser
+		var content []ClaudeMessageContent
+		switch {
+		case m.Role == ChatMessageRoleAssistant:
+			role = ChatMessageRoleAssistant
+			// Anthropic 不接受空的 text 块
+			if text := messageText(m.Content); text != "" {
+				content = append(content, ClaudeMessageContent{Type: "text", Text: text})
+			}
+			for _, call := range m.ToolCalls {
+				content = append(content, ClaudeMessageContent{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: claudeToolInput(call.Function.Arguments)})
+			}
+		case m.ToolCallID != "":
+			content = append(content, ClaudeMessageContent{Type: "tool_result", ToolUseID: m.ToolCallID, Content: messageText(m.Content)})
+		default:
+			for _, part := range messageParts(m.Content) {
+				if block, ok := chatPartToClaude(part); ok {
+					content = append(content, block)
+				}
+			}
 		}
-		str, ok := m.Content.(string)
-		if ok {
-			msg = append(msg, ClaudeMessageItem{
-				Role:    m.Role,
-				Content: []ClaudeMessageContent{{Type: "text", Text: str}},
-			})
+		if len(content) == 0 {
+			continue
 		}
-		nmap, ok := m.Content.(map[string]string)
-		if ok {
-			msg = append(msg, ClaudeMessageItem{
-				Role:    m.Role,
-				Content: []ClaudeMessageContent{{Type: "text", Text: nmap["text"]}},
-			})
+		if n := len(msg); n > 0 && msg[n-1].Role == role {
+			msg[n-1].Content = append(msg[n-1].Content, content...)
+			continue
 		}
+		msg = append(msg, ClaudeMessageItem{Role: role, Content: content})
+	}
+	if len(msg) > 0 && msg[0].Role != ChatMessageRoleUser {
+		lead := ClaudeMessageItem{Role: ChatMessageRoleUser, Content: []ClaudeMessageContent{{Type: "text", Text: leadingUserTurn}}}
+		msg = append([]ClaudeMessageItem{lead}, msg...)
 	}
 	return msg
 }
@@ -547,149 +578,72 @@ func GetGptModels(c *gin.Context) {
 		}
 		models = append(models, gpt)
 	}
+	for _, key := range virtualModelNames() {
+		models = append(models, GptModel{
+			ID:      key,
+			Object:  "model",
+			Created: 0,
+			OwnedBy: "ollamaproxy",
+		})
+	}
 	resp.Data = models
 	c.JSON(http.StatusOK, resp)
 }
 
 func OpenaiHandlerSteam(c *gin.Context, input ChatCompletionRequest) {
-
-	if model, ok := XConfig.Mapping[input.Model]; ok {
-		input.Model = model
-	}
-
-	// dify 选择 url
-	models := make([]string, 0)
-	hasModels := make([]string, 0)
-	for model := range XConfig.DifyAppMapProd {
-		models = append(models, model)
-		if model == input.Model {
-			XConfig.IsProd = true
-			break
-		} else {
-			hasModels = append(models, model)
-		}
-	}
-	if len(hasModels) == len(models) {
-		XConfig.IsProd = false
-	}
-
-	url := XConfig.APIURL
-	if XConfig.IsProd {
-		url = XConfig.APIURLProd
-	}
-
-	payload, err := GptGenRequest(&input)
-	if err != nil {
-		log.Println("Encode error:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode request" + err.Error()})
+	if resumeGeneration(c) {
 		return
 	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
+	provider, upstreamModel, err := selectProvider(input.Model)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-	}
-
-	if XConfig.ChatType == "dify" {
-		log.Println("current DifyToken:", XConfig.DifyTokenMap[input.Model])
-		req.Header.Set("Authorization", "Bearer "+XConfig.DifyTokenMap[input.Model])
-	} else {
-		req.Header.Set("Authorization", "Bearer "+XConfig.APIKey)
-	}
-	req.Header.Set("x-api-key", XConfig.APIKey)
-	req.Header.Set("Content-Type", "application/json")
-
-	// 发起请求
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Println("Request error:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "API request failed" + err.Error()})
 		return
 	}
-
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		c.JSON(resp.StatusCode, gin.H{"error": "API request failed code is not 200", "data": string(body)})
-		return
+	if gen := startGeneration(c); gen != nil {
+		defer gen.finish()
 	}
-	scanner := bufio.NewScanner(resp.Body)
-	scanner.Buffer(make([]byte, InitialScannerBufferSize), MaxScannerBufferSize)
-	scanner.Split(bufio.ScanLines)
-
-	// 设置为流式响应
-	c.Header("content-Type", "text/event-stream")
-	// c.Header("content-Type", "application/x-ndjson")
-	c.Header("cache-control", "no-cache")
-	c.Header("Connection", "keep-alive")
-
-	for scanner.Scan() {
-		data := scanner.Text()
-		if XConfig.Debug {
-			println(data)
-		}
-		if len(data) < 6 {
-			continue
+	provider = withThinkTags(c, input.Model, withContinuation(c, input.Model, provider))
+	req := input
+	req.Model = upstreamModel
+
+	stream := newOpenaiStream(&input)
+	started := false
+	start := func() {
+		if !started {
+			started = true
+			// 设置为流式响应
+			c.Header("content-Type", "text/event-stream")
+			c.Header("cache-control", "no-cache")
+			c.Header("Connection", "keep-alive")
 		}
-		if data[:5] != "data:" { //&& data[:6] != "[DONE]"
-			continue
-		}
-		data = data[6:]
-		if data == "[DONE]" {
-			log.Println("DONE")
-			outputMsg := fmt.Sprintf("data: %s\n\n", data)
-			info, err := c.Writer.WriteString(outputMsg)
-			if err != nil {
-				log.Println("Write error:", err)
-				continue
-			}
-			log.Println("info:", info)
-			c.Writer.Flush()
-			break
-		}
-
-		sendLine, err := GptGenResponseStream([]byte(data), &input)
-		//log.Println("返回一行:", sendLine)
-		if err != nil {
-			log.Println("Encode error:", err)
-			continue
-		}
-		if sendLine == "null" || sendLine == "" {
-			continue
-		}
-		if strings.HasPrefix(sendLine, "[DONE]") {
-			sendLine = sendLine[6:]
-			outputMsg := fmt.Sprintf("data: %s\n\n", sendLine)
-			c.Writer.WriteString(outputMsg)
-			c.Writer.Flush()
-			outputMsg = fmt.Sprintf("data: %s\n\n", "[DONE]")
-			c.Writer.WriteString(outputMsg)
-			log.Println("Write DONE")
-			c.Writer.Flush()
-			continue
-
-		}
-		// re := []byte("data: " + str + "\n\n")
-		outputMsg := fmt.Sprintf("data: %s\n\n", sendLine)
-		info, err := c.Writer.WriteString(outputMsg)
-		if err != nil {
-			log.Println("Write error:", err)
-			continue
-		}
-
-		if XConfig.Debug {
-			log.Println("info:", info)
-		}
-		c.Writer.Flush()
 	}
-
-	if err := scanner.Err(); err != nil {
-		if err != io.EOF {
-			log.Println("scanner error: " + err.Error())
+	watch := watchClient(c, input.Model)
+	watch.keepalive(func() error {
+		start()
+		return PingData(c)
+	})
+	err = provider.ChatStream(watch.ctx, &req, watch.emit(func(ev ChatEvent) error {
+		applyEventHeader(c, ev.Header)
+		// 响应头已在上面处理，只带响应头的事件不输出分片
+		if !ev.hasPayload() {
+			return nil
 		}
+		start()
+		return ObjectData(c, stream.chunk(ev))
+	}))
+	if watch.gone() {
+		return
 	}
-
+	err = watch.failure(err)
+	if err != nil && !started {
+		openaiUpstreamError(c, err)
+		return
+	}
+	if err != nil {
+		log.Println("Stream error:", err)
+		_ = ObjectData(c, gin.H{"error": gin.H{"message": err.Error(), "type": "upstream_error"}})
+	}
+	Done(c)
 }
 
 func OpenaiHandler(c *gin.Context) {
@@ -727,6 +681,8 @@ func OpenaiHandler(c *gin.Context) {
 					// log.Println("map[string]string:", content["text"])
 					// m.Content = content["text"]
 					msg = append(msg, ChatCompletionMessage{Role: m.Role, Content: content["text"]})
+				default:
+					msg = append(msg, m)
 				}
 			}
 		}
@@ -741,6 +697,8 @@ func OpenaiHandler(c *gin.Context) {
 				// log.Println("map[string]string:", content["text"])
 				// m.Content = content["text"]
 				msg = append(msg, ChatCompletionMessage{Role: m.Role, Content: content["text"]})
+			default:
+				msg = append(msg, m)
 			}
 		}
 
@@ -769,167 +727,346 @@ func OpenaiHandler(c *gin.Context) {
 		return
 	}
 
-	if model, ok := XConfig.Mapping[input.Model]; ok {
-		input.Model = model
+	provider, upstreamModel, err := selectProvider(input.Model)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	provider = withThinkTags(c, input.Model, withContinuation(c, input.Model, provider))
+	req := input
+	req.Model = upstreamModel
+
+	result := chatResult{}
+	watch := watchClient(c, input.Model)
+	err = provider.ChatStream(watch.ctx, &req, watch.emit(func(ev ChatEvent) error {
+		result.add(ev)
+		return nil
+	}))
+	if watch.gone() {
+		return
 	}
+	err = watch.failure(err)
+	if err != nil {
+		openaiUpstreamError(c, err)
+		return
+	}
+	now := time.Now().Unix()
+	applyEventHeader(c, result.Header)
+	c.JSON(http.StatusOK, ChatResultToResponse("chatcmpl-"+strconv.Itoa(int(now)), now, input.Model, &result))
+}
 
-	// dify 选择 url
-	models := make([]string, 0)
-	hasModels := make([]string, 0)
-	for model := range XConfig.DifyAppMapProd {
-		models = append(models, model)
-		if model == input.Model {
-			XConfig.IsProd = true
-			break
-		} else {
-			hasModels = append(models, model)
-		}
+// openaiStream 把中立事件渲染为 chat.completion.chunk，工具调用的 index 在整个流内递增
+type openaiStream struct {
+	chatId    string
+	now       int64
+	req       *ChatCompletionRequest
+	toolIndex int
+}
+
+func newOpenaiStream(req *ChatCompletionRequest) *openaiStream {
+	now := time.Now().Unix()
+	return &openaiStream{chatId: strconv.Itoa(int(now)), now: now, req: req}
+}
+
+func (s *openaiStream) chunk(ev ChatEvent) ChatCompletionStreamResponse {
+	msg := CreateStreamMessage(s.chatId, s.now, s.req, "", ev.Content, ev.Reasoning)
+	for _, call := range ev.ToolCalls {
+		index := s.toolIndex
+		s.toolIndex++
+		call.Index = &index
+		msg.Choices[0].Delta.ToolCalls = append(msg.Choices[0].Delta.ToolCalls, call)
 	}
-	if len(hasModels) == len(models) {
-		XConfig.IsProd = false
+	if ev.FinishReason != "" {
+		msg.Choices[0].FinishReason = ev.FinishReason
 	}
+	if ev.ContentFilter != nil {
+		msg.Choices[0].ContentFilterResults = *ev.ContentFilter
+	}
+	msg.PromptFilterResults = ev.PromptFilterResults
+	msg.Usage = ev.Usage
+	return msg
+}
 
-	url := XConfig.APIURL
-	if XConfig.IsProd {
-		url = XConfig.APIURLProd
+// ChatResultToResponse 聚合结果渲染为非流式 chat.completion
+func ChatResultToResponse(id string, now int64, model string, result *chatResult) ChatCompletionResponse {
+	finishReason := result.FinishReason
+	if finishReason == "" {
+		finishReason = FinishReasonStop
+	}
+	contentFilter := ContentFilterResults{}
+	if result.ContentFilter != nil {
+		contentFilter = *result.ContentFilter
+	}
+	return ChatCompletionResponse{
+		ID:      id,
+		Object:  "chat.completion",
+		Created: now,
+		Model:   model,
+		Choices: []ChatCompletionChoice{{
+			Index: 0,
+			Message: ChatCompletionMessage{
+				Role:             ChatMessageRoleAssistant,
+				Content:          result.Content,
+				ReasoningContent: result.Reasoning,
+				ToolCalls:        result.ToolCalls,
+			},
+			FinishReason:         finishReason,
+			ContentFilterResults: contentFilter,
+		}},
+		Usage:               result.Usage,
+		PromptFilterResults: result.PromptFilterResults,
 	}
+}
 
-	payload, err := GptGenRequest(&input)
-	if err != nil {
-		log.Println("Encode error:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode request" + err.Error()})
+func openaiUpstreamError(c *gin.Context, err error) {
+	log.Println("Request error:", err)
+	var upstream *UpstreamError
+	if errors.As(err, &upstream) {
+		// 上游已是 OpenAI 错误格式时原样返回，客户端可以直接解析
+		var body struct {
+			Error map[string]interface{} `json:"error"`
+		}
+		if json.Unmarshal([]byte(upstream.Body), &body) == nil && body.Error != nil {
+			c.Data(upstream.StatusCode, "application/json", []byte(upstream.Body))
+			return
+		}
+		c.JSON(upstream.StatusCode, gin.H{"error": "API request failed code is not 200", "data": upstream.Body})
 		return
 	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "API request failed" + err.Error()})
+}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-	}
+// OpenAIProvider 通过 OpenAI 兼容的 /chat/completions 接口对话
+type OpenAIProvider struct {
+	Config *ProviderConfig
+}
 
-	if XConfig.ChatType == "dify" {
-		log.Println("current DifyToken:", XConfig.DifyTokenMap[input.Model])
-		req.Header.Set("Authorization", "Bearer "+XConfig.DifyTokenMap[input.Model])
-	} else {
-		req.Header.Set("Authorization", "Bearer "+XConfig.APIKey)
+func (p *OpenAIProvider) ChatStream(ctx context.Context, req *ChatCompletionRequest, emit func(ChatEvent) error) error {
+	req, ignored := adaptSampling(req, providerSampling(p.Config))
+	if err := reportIgnoredParams(emit, ignored); err != nil {
+		return err
 	}
-	req.Header.Set("x-api-key", XConfig.APIKey)
-	req.Header.Set("Content-Type", "application/json")
+	url := strings.TrimSuffix(p.Config.BaseUrl, "/") + "/chat/completions"
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+p.Config.APIKey)
+	return openaiChatStream(ctx, url, header, req, emit)
+}
 
-	// 发起请求
-	client := &http.Client{}
-	resp, err := client.Do(req)
+// Complete 通过 {baseUrl}/completions（或 completionsUrl）做文本续写，suffix 原样透传给支持 FIM 的上游
+func (p *OpenAIProvider) Complete(ctx context.Context, req *CompletionRequest, emit func(ChatEvent) error) error {
+	url := completionURL(p.Config)
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+p.Config.APIKey)
+	body := struct {
+		CompletionRequest
+		StreamOptions *StreamOptions `json:"stream_options,omitempty"`
+	}{CompletionRequest: *req, StreamOptions: &StreamOptions{IncludeUsage: true}}
+	body.Stream = true
+	payload, err := json.Marshal(&body)
 	if err != nil {
-		log.Println("Request error:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "API request failed" + err.Error()})
-		return
+		return err
 	}
-
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		c.JSON(resp.StatusCode, gin.H{"error": "API request failed code is not 200", "data": string(body)})
-		return
+	resp, err := postUpstream(ctx, url, payload, header)
+	if err != nil {
+		return err
 	}
-	scanner := bufio.NewScanner(resp.Body)
-	scanner.Buffer(make([]byte, InitialScannerBufferSize), MaxScannerBufferSize)
-	scanner.Split(bufio.ScanLines)
-
-	c.Header("content-Type", "application/json")
-	c.Header("cache-control", "no-cache")
-	c.Header("Connection", "keep-alive")
-
-	for scanner.Scan() {
-		data := scanner.Text()
-		if XConfig.Debug {
-			println(data)
+	defer resp.Body.Close()
+	final := ChatEvent{}
+	err = readSSEData(resp.Body, func(data string) error {
+		if data == "[DONE]" {
+			return nil
 		}
-		if len(data) < 6 {
-			continue
+		chunk := struct {
+			Choices []struct {
+				Text         string       `json:"text"`
+				FinishReason FinishReason `json:"finish_reason"`
+			} `json:"choices"`
+			Usage *Usage `json:"usage"`
+		}{}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			log.Println("Unmarshal error:", err)
+			return nil
 		}
-		if data[:5] != "data:" { //&& data[:6] != "[DONE]"
-			continue
+		if chunk.Usage != nil {
+			final.Usage = chunk.Usage
 		}
-		data = data[6:]
-
-		sendLine, err := GptGenResponse([]byte(data), &input)
-		if err != nil {
-			log.Println("Encode error:", err)
-			continue
+		if len(chunk.Choices) == 0 {
+			return nil
 		}
-		if sendLine == "null" || sendLine == "" {
-			continue
+		if chunk.Choices[0].FinishReason != "" && chunk.Choices[0].FinishReason != FinishReasonNull {
+			final.FinishReason = chunk.Choices[0].FinishReason
 		}
-		c.JSON(http.StatusOK, sendLine)
-	}
-
-	if err := scanner.Err(); err != nil {
-		if err != io.EOF {
-			log.Println("scanner error: " + err.Error())
+		if chunk.Choices[0].Text == "" {
+			return nil
 		}
+		return emit(ChatEvent{Content: chunk.Choices[0].Text})
+	})
+	if err != nil {
+		return err
 	}
+	if final.FinishReason == "" {
+		final.FinishReason = FinishReasonStop
+	}
+	return emit(final)
+}
 
+// Embed 通过 {baseUrl}/embeddings 向量化
+func (p *OpenAIProvider) Embed(ctx context.Context, req *EmbeddingRequest) (*EmbeddingResult, error) {
+	url := strings.TrimSuffix(p.Config.BaseUrl, "/") + "/embeddings"
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+p.Config.APIKey)
+	return openaiEmbed(ctx, url, header, req)
 }
 
-func GptGenRequest(input *ChatCompletionRequest) ([]byte, error) {
-	if XConfig == nil {
-		return nil, nil
-	}
-	switch XConfig.ChatType {
-	case "dify":
-		if XConfig.DifyTokenMap[input.Model] == "" {
-			err := getDifyToken(input.Model)
-			if err != nil {
-				return nil, err
+// openaiEmbed 发送 OpenAI 格式的向量化请求，Azure 等兼容上游共用
+func openaiEmbed(ctx context.Context, url string, header http.Header, req *EmbeddingRequest) (*EmbeddingResult, error) {
+	return embedInBatches(req, openaiEmbeddingBatchSize, func(batch *EmbeddingRequest) (*EmbeddingResult, error) {
+		body := map[string]interface{}{"model": batch.Model, "input": batch.Input, "encoding_format": "float"}
+		if batch.Dimensions > 0 {
+			body["dimensions"] = batch.Dimensions
+		}
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := postUpstream(ctx, url, payload, header)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		var out struct {
+			Data []struct {
+				Index     int       `json:"index"`
+				Embedding []float64 `json:"embedding"`
+			} `json:"data"`
+			Usage struct {
+				PromptTokens int `json:"prompt_tokens"`
+			} `json:"usage"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			return nil, err
+		}
+		// data 按 index 归位，不依赖上游返回顺序
+		result := &EmbeddingResult{Embeddings: make([][]float64, len(out.Data)), PromptTokens: out.Usage.PromptTokens}
+		for _, d := range out.Data {
+			if d.Index < 0 || d.Index >= len(out.Data) {
+				return nil, fmt.Errorf("upstream embedding index %d out of range", d.Index)
 			}
+			result.Embeddings[d.Index] = d.Embedding
 		}
-		log.Println("DifyTokenMap:", XConfig.DifyTokenMap)
-		re := GptToDityRequest(input)
-		return json.Marshal(re)
-	case "claude":
-		re := GpttoClaudeRequest(input.Messages)
-		return json.Marshal(re)
-	default:
-		return nil, nil
-	}
-
+		return result, nil
+	})
 }
 
-func GptGenResponse(input []byte, req *ChatCompletionRequest) (string, error) {
-	if XConfig == nil {
-		return "", nil
+// openaiChatStream 以流式方式发送 OpenAI 格式请求并解析输出，Azure 等兼容上游共用
+func openaiChatStream(ctx context.Context, url string, header http.Header, req *ChatCompletionRequest, emit func(ChatEvent) error) error {
+	body := *req
+	body.Stream = true
+	body.StreamOptions = &StreamOptions{IncludeUsage: true}
+	// none 只在代理侧丢弃思考内容，不少兼容上游不认识这个档位
+	body.ReasoningEffort = reasoningEffort(req)
+	if body.ReasoningEffort == reasoningEffortNone {
+		body.ReasoningEffort = ""
+	}
+	payload, err := json.Marshal(&body)
+	if err != nil {
+		return err
 	}
-	switch XConfig.ChatType {
-	case "dify":
-		return DifyToGptResponse(input, req)
-	case "claude":
-		// re, err := ClaudeBlockToOllamaResponse(input, req)
-		// if err != nil {
-		// 	return nil, nil
-		// }
-		// return json.Marshal(re)
-		return "", nil
-	default:
-		return "", nil
+	resp, err := postUpstream(ctx, url, payload, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if reasoningDisabled(req) {
+		emit = dropReasoning(emit)
 	}
+	return readOpenAIStream(resp.Body, emit)
+}
 
+// openaiStreamChunk 上游 chat.completion.chunk，过滤标注用指针区分是否存在
+type openaiStreamChunk struct {
+	Choices []struct {
+		Index int `json:"index"`
+		Delta struct {
+			ChatCompletionStreamChoiceDelta
+			// Reasoning Ollama、OpenRouter 等上游使用的思考字段名
+			Reasoning string `json:"reasoning"`
+		} `json:"delta"`
+		FinishReason         FinishReason          `json:"finish_reason"`
+		ContentFilterResults *ContentFilterResults `json:"content_filter_results"`
+	} `json:"choices"`
+	PromptFilterResults []PromptFilterResult `json:"prompt_filter_results"`
+	Usage               *Usage               `json:"usage"`
 }
 
-func GptGenResponseStream(input []byte, req *ChatCompletionRequest) (string, error) {
-	if XConfig == nil {
-		return "", nil
+// maxStreamToolCalls 单条回复允许的工具调用 index 上限，防止异常上游用极大的 index 撑爆内存
+const maxStreamToolCalls = 128
+
+// readOpenAIStream 解析 OpenAI 流式输出；工具调用按 index 拼接参数，
+// finish_reason 与 usage 分属不同分片，统一在流结束时合并为最后一个事件
+func readOpenAIStream(body io.Reader, emit func(ChatEvent) error) error {
+	var calls []ToolCall
+	final := ChatEvent{}
+	err := readSSEData(body, func(data string) error {
+		if data == "[DONE]" {
+			return nil
+		}
+		chunk := openaiStreamChunk{}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			log.Println("Unmarshal error:", err)
+			return nil
+		}
+		if chunk.Usage != nil {
+			final.Usage = chunk.Usage
+		}
+		ev := ChatEvent{PromptFilterResults: chunk.PromptFilterResults}
+		for _, choice := range chunk.Choices {
+			if choice.Index != 0 {
+				continue
+			}
+			ev.Content = choice.Delta.Content
+			ev.Reasoning = choice.Delta.ReasoningContent
+			if ev.Reasoning == "" {
+				ev.Reasoning = choice.Delta.Reasoning
+			}
+			ev.ContentFilter = choice.ContentFilterResults
+			for _, delta := range choice.Delta.ToolCalls {
+				index := len(calls)
+				if delta.Index != nil {
+					index = *delta.Index
+				}
+				if index < 0 || index >= maxStreamToolCalls {
+					return fmt.Errorf("invalid tool call index %d", index)
+				}
+				for len(calls) <= index {
+					calls = append(calls, ToolCall{Type: "function"})
+				}
+				if delta.ID != "" {
+					calls[index].ID = delta.ID
+				}
+				if delta.Function.Name != "" {
+					calls[index].Function.Name = delta.Function.Name
+				}
+				calls[index].Function.Arguments += delta.Function.Arguments
+			}
+			if choice.FinishReason != "" && choice.FinishReason != FinishReasonNull {
+				final.FinishReason = choice.FinishReason
+			}
+		}
+		if !ev.hasPayload() {
+			return nil
+		}
+		return emit(ev)
+	})
+	if err != nil {
+		return err
 	}
-	switch XConfig.ChatType {
-	case "dify":
-		return DifyToGptResponseStream(input, req)
-	case "claude":
-		// re, err := ClaudeBlockToOllamaResponse(input, req)
-		// if err != nil {
-		// 	return nil, nil
-		// }
-		// return json.Marshal(re)
-		return "", nil
-	default:
-		return "", nil
+	if final.FinishReason == "" && final.Usage == nil && len(calls) == 0 {
+		return nil
 	}
-
+	if final.FinishReason == "" {
+		final.FinishReason = FinishReasonStop
+	}
+	final.ToolCalls = calls
+	return emit(final)
 }
@@ -1,5 +1,15 @@
 package main
 
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
 // https://github.com/zouyq/jetbrains-ai-proxy/blob/c3aa83dbbb8dcc207be78787ee144740fd8c99d0/internal/apiserver/router.go#L67
 
 type SSEData struct {
@@ -30,3 +40,156 @@ type QuotaInfo struct {
 type SpentData struct {
 	Amount string `json:"amount"`
 }
+
+const jetbrainsDefaultURL = "https://api.jetbrains.ai/user/v5/llm/chat/stream/v7"
+
+// JetBrainsProvider 通过 JetBrains AI (grazie) 的流式接口对话，apiKey 填写 grazie JWT
+type JetBrainsProvider struct {
+	Config *ProviderConfig
+}
+
+type jetbrainsMessage struct {
+	Type    string `json:"type"` // system_message / user_message / assistant_message
+	Content string `json:"content"`
+}
+
+type jetbrainsChatRequest struct {
+	Prompt  string `json:"prompt"`
+	Profile string `json:"profile"`
+	Chat    struct {
+		Messages []jetbrainsMessage `json:"messages"`
+	} `json:"chat"`
+}
+
+// jetbrainsQuota 每个 provider 最近一次收到的额度信息
+var jetbrainsQuota sync.Map
+
+func (p *JetBrainsProvider) ChatStream(ctx context.Context, req *ChatCompletionRequest, emit func(ChatEvent) error) error {
+	if structuredOutputRequested(req) {
+		return emulateStructuredOutput(ctx, p, req, emit)
+	}
+	req, ignored := adaptSampling(req, noSampling)
+	if err := reportIgnoredParams(emit, ignored); err != nil {
+		return err
+	}
+	body := jetbrainsChatRequest{Prompt: "ij.chat.request.new-chat-on-start", Profile: req.Model}
+	if profile, ok := p.Config.ModelIDs[req.Model]; ok {
+		body.Profile = profile
+	}
+	for _, m := range req.Messages {
+		msgType := "user_message"
+		switch m.Role {
+		case ChatMessageRoleSystem, ChatMessageRoleDeveloper:
+			msgType = "system_message"
+		case ChatMessageRoleAssistant:
+			msgType = "assistant_message"
+		}
+		body.Chat.Messages = append(body.Chat.Messages, jetbrainsMessage{Type: msgType, Content: messageText(m.Content)})
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	url := p.Config.BaseUrl
+	if url == "" {
+		url = jetbrainsDefaultURL
+	}
+	header := http.Header{}
+	header.Set("grazie-authenticate-jwt", p.Config.APIKey)
+	header.Set("grazie-agent", `{"name":"aia:idea","version":"251.23774.318:251.23774.318"}`)
+	header.Set("Accept", "text/event-stream")
+	resp, err := postUpstream(ctx, url, payload, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// 先带上已知的额度，若本次流中更新了额度且响应头尚未写出，会被新值覆盖
+	if quota, ok := jetbrainsQuota.Load(p.name()); ok {
+		if err := emit(ChatEvent{Header: jetbrainsQuotaHeader(quota.(*UpdatedData))}); err != nil {
+			return err
+		}
+	}
+	finished := false
+	err = readSSEData(resp.Body, func(data string) error {
+		if data == "end" {
+			return nil
+		}
+		event := SSEData{}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			log.Println("Unmarshal error:", err)
+			return nil
+		}
+		switch event.Type {
+		case "Content":
+			if event.Content == "" {
+				return nil
+			}
+			return emit(ChatEvent{Content: event.Content})
+		case "FinishMetadata":
+			finished = true
+			return emit(ChatEvent{FinishReason: jetbrainsFinishReason(event.Reason)})
+		case "QuotaMetadata":
+			return emit(ChatEvent{Header: p.updateQuota(&event)})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !finished {
+		return emit(ChatEvent{FinishReason: FinishReasonStop})
+	}
+	return nil
+}
+
+func (p *JetBrainsProvider) name() string {
+	if p.Config.Name != "" {
+		return p.Config.Name
+	}
+	return "jetbrains"
+}
+
+// updateQuota 记录额度并更新指标，返回对应的响应头
+func (p *JetBrainsProvider) updateQuota(event *SSEData) http.Header {
+	labels := map[string]string{"provider": p.name()}
+	if event.Spent != nil {
+		if spent, err := strconv.ParseFloat(event.Spent.Amount, 64); err == nil {
+			addCounter("jetbrains_quota_spent_total", "JetBrains AI credits spent by proxied requests.", labels, spent)
+		}
+	}
+	if event.Updated == nil {
+		return nil
+	}
+	jetbrainsQuota.Store(p.name(), event.Updated)
+	if current, err := strconv.ParseFloat(event.Updated.Current.Amount, 64); err == nil {
+		setGauge("jetbrains_quota_current", "JetBrains AI credits used in the current quota period.", labels, current)
+	}
+	if maximum, err := strconv.ParseFloat(event.Updated.Maximum.Amount, 64); err == nil {
+		setGauge("jetbrains_quota_maximum", "JetBrains AI credits available in the current quota period.", labels, maximum)
+	}
+	setGauge("jetbrains_quota_until_seconds", "Unix time when the JetBrains AI quota resets.", labels, float64(event.Updated.Until/1000))
+	return jetbrainsQuotaHeader(event.Updated)
+}
+
+func jetbrainsQuotaHeader(quota *UpdatedData) http.Header {
+	header := http.Header{}
+	header.Set("X-JetBrains-Quota-Current", quota.Current.Amount)
+	header.Set("X-JetBrains-Quota-Maximum", quota.Maximum.Amount)
+	header.Set("X-JetBrains-Quota-Until", time.UnixMilli(quota.Until).UTC().Format(time.RFC3339))
+	if quota.License != "" {
+		header.Set("X-JetBrains-Quota-License", quota.License)
+	}
+	return header
+}
+
+func jetbrainsFinishReason(reason string) FinishReason {
+	switch reason {
+	case "length":
+		return FinishReasonLength
+	case "function_call", "tool_call":
+		return FinishReasonToolCalls
+	default:
+		return FinishReasonStop
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestJetBrainsProviderQuota(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("grazie-authenticate-jwt") != "jwt-token" {
+			t.Errorf("jwt header = %q", r.Header.Get("grazie-authenticate-jwt"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		var req jetbrainsChatRequest
+		_ = json.Unmarshal(body, &req)
+		if req.Profile != "openai-gpt-4o" || len(req.Chat.Messages) != 2 || req.Chat.Messages[0].Type != "system_message" {
+			t.Errorf("request = %s", body)
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, `data: {"type":"QuotaMetadata","updated":{"license":"AIP","current":{"amount":"120.5"},"maximum":{"amount":"1000"},"until":1767225600000,"quotaID":{"quotaId":"q"}},"spent":{"amount":"2.5"}}`+"\n\n")
+		fmt.Fprint(w, `data: {"type":"Content","event_type":"Content","content":"Hel"}`+"\n\n")
+		fmt.Fprint(w, `data: {"type":"Content","event_type":"Content","content":"lo"}`+"\n\n")
+		fmt.Fprint(w, `data: {"type":"FinishMetadata","reason":"stop"}`+"\n\n")
+		fmt.Fprint(w, "data: end\n\n")
+	}))
+	defer server.Close()
+
+	XConfig = &Config{Providers: []ProviderConfig{{
+		Name:     "jb",
+		Type:     "jetbrains",
+		BaseUrl:  server.URL,
+		APIKey:   "jwt-token",
+		ModelIDs: map[string]string{"gpt-4o": "openai-gpt-4o"},
+	}}}
+	defer func() { XConfig = nil }()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/openai/v1/chat/completions", OpenaiHandler)
+	router.GET("/metrics", MetricsHandler)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/openai/v1/chat/completions",
+		strings.NewReader(`{"model":"gpt-4o","stream":true,"messages":[{"role":"system","content":"be nice"},{"role":"user","content":"hi"}]}`)))
+
+	if w.Header().Get("X-JetBrains-Quota-Current") != "120.5" || w.Header().Get("X-JetBrains-Quota-Maximum") != "1000" {
+		t.Errorf("quota headers = %v", w.Header())
+	}
+	if w.Header().Get("X-JetBrains-Quota-Until") != "2026-01-01T00:00:00Z" {
+		t.Errorf("until = %q", w.Header().Get("X-JetBrains-Quota-Until"))
+	}
+	if !strings.Contains(w.Body.String(), `"content":"Hel"`) || !strings.Contains(w.Body.String(), "data: [DONE]") {
+		t.Errorf("body = %s", w.Body.String())
+	}
+
+	m := httptest.NewRecorder()
+	router.ServeHTTP(m, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`jetbrains_quota_current{provider="jb"} 120.5`,
+		`jetbrains_quota_maximum{provider="jb"} 1000`,
+		`jetbrains_quota_spent_total{provider="jb"} 2.5`,
+	} {
+		if !strings.Contains(m.Body.String(), want) {
+			t.Errorf("metrics missing %q:\n%s", want, m.Body.String())
+		}
+	}
+}
@@ -105,9 +105,10 @@ func getLMModels(c *gin.Context) {
 		}
 	} else {
 		var models []map[string]interface{}
-		list, err := getModelsByUrl()
+		list, err := getModelsByUrl(c.Request.Context())
 		if err != nil {
 			c.JSON(http.StatusOK, gin.H{"object": "list", "data": models})
+			return
 		}
 		for _, v := range list.Data {
 			family := ""
@@ -135,5 +136,19 @@ func getLMModels(c *gin.Context) {
 			models = append(models, model)
 		}
 	}
+	// 通过 /api/create 创建的虚拟模型
+	for _, name := range virtualModelNames() {
+		models = append(models, map[string]interface{}{
+			"id":                 name,
+			"model":              "model",
+			"type":               "llm",
+			"publisher":          ollamaModelFamily(name),
+			"arch":               "llama",
+			"compatibility_type": "gguf",
+			"quantization":       "Q4_K_M",
+			"state":              "not-loaded",
+			"max_context_length": ollamaContextLength(name),
+		})
+	}
 	c.JSON(http.StatusOK, gin.H{"object": "list", "data": models})
 }
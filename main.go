@@ -1,9 +1,8 @@
 package main
 
 import (
-	"bufio"
-	"bytes"
 	"encoding/json"
+	"errors"
 	"flag"
 	"log"
 	"math/rand"
@@ -43,6 +42,9 @@ func main() {
 		log.Println("使用配置文件:", configPath)
 		loadConfig(configPath)
 	}
+	if err := loadVirtualModels(); err != nil {
+		log.Println("读取虚拟模型失败:", err)
+	}
 	if claudeAPIKey == "" {
 		log.Fatal("Missing CLAUDE_API_KEY environment variable")
 	}
@@ -64,13 +66,33 @@ func main() {
 	// ollama api
 	router.GET("/api/tags", getModels)
 	router.POST("/api/chat", chatHandlerSteam)
+	router.POST("/api/generate", OllamaGenerateHandler)
+	router.POST("/api/show", OllamaShowHandler)
+	router.GET("/api/ps", OllamaPsHandler)
+	router.GET("/api/version", OllamaVersionHandler)
+	router.POST("/api/pull", OllamaPullHandler)
+	router.DELETE("/api/delete", OllamaDeleteHandler)
+	router.POST("/api/create", OllamaCreateHandler)
+	router.POST("/api/embed", OllamaEmbedHandler)
+	router.POST("/api/embeddings", OllamaEmbeddingsHandler)
 	router.POST("/v1/chat/completions", chatHandlerSteam)
+	router.GET("/v1/models", GetGptModels)
+	router.POST("/v1/embeddings", OpenaiEmbeddingsHandler)
+	router.POST("/v1/completions", OpenaiCompletionsHandler)
+	router.POST("/v1/responses", ResponsesHandler)
+	router.GET("/v1/responses/:id", ResponsesGetHandler)
+	router.DELETE("/v1/responses/:id", ResponsesDeleteHandler)
 
 	//open ai
 	router.POST("/openai/v1/chat/completions", OpenaiHandler)
 	router.GET("/openai/v1/models", GetGptModels)
 	router.GET("/openai/models", GetGptModels)
 	router.POST("/openai/chat/completions", OpenaiHandler)
+	router.POST("/openai/v1/embeddings", OpenaiEmbeddingsHandler)
+	router.POST("/openai/v1/completions", OpenaiCompletionsHandler)
+	router.POST("/openai/v1/responses", ResponsesHandler)
+	router.GET("/openai/v1/responses/:id", ResponsesGetHandler)
+	router.DELETE("/openai/v1/responses/:id", ResponsesDeleteHandler)
 
 	router.POST("/proxy/openai/v1/chat/completions", ProxyChatHandle)
 	router.GET("/proxy/openai/v1/models", GetGptModels)
@@ -81,6 +103,8 @@ func main() {
 		c.String(http.StatusOK, "Ollama is running ok")
 	})
 	router.POST("/imgreduce/openai/v1/chat/completions", OpenaiHandler)
+	router.POST("/imgreduce/openai/v1/embeddings", OpenaiEmbeddingsHandler)
+	router.POST("/imgreduce/openai/v1/completions", OpenaiCompletionsHandler)
 	router.GET("/imgreduce/openai/v1/models", GetGptModels)
 
 	// imgreduce ollama
@@ -90,6 +114,15 @@ func main() {
 	})
 	router.GET("/imgreduce/ollama/api/tags", getModels)
 	router.POST("/imgreduce/ollama/api/chat", chatHandlerSteam)
+	router.POST("/imgreduce/ollama/api/generate", OllamaGenerateHandler)
+	router.POST("/imgreduce/ollama/api/show", OllamaShowHandler)
+	router.GET("/imgreduce/ollama/api/ps", OllamaPsHandler)
+	router.GET("/imgreduce/ollama/api/version", OllamaVersionHandler)
+	router.POST("/imgreduce/ollama/api/pull", OllamaPullHandler)
+	router.DELETE("/imgreduce/ollama/api/delete", OllamaDeleteHandler)
+	router.POST("/imgreduce/ollama/api/create", OllamaCreateHandler)
+	router.POST("/imgreduce/ollama/api/embed", OllamaEmbedHandler)
+	router.POST("/imgreduce/ollama/api/embeddings", OllamaEmbeddingsHandler)
 
 	//imgreduce lm studio
 	router.GET("/imgreduce/lmstudio", func(c *gin.Context) {
@@ -102,13 +135,30 @@ func main() {
 	router.GET("/imgreduce/lmstudio/v1/models", GetGptModels)
 	router.POST("/imgreduce/lmstudio/v1/chat/completions", OpenaiHandler)
 
+	// gemini
+	router.GET("/v1beta/models", GeminiModelsHandler)
+	router.GET("/v1beta/models/:action", GeminiModelHandler)
+	router.POST("/v1beta/models/:action", GeminiGenerateHandler)
+
 	// claude
 	router.POST("/claude/v1/messages", ClaudeHandlerSteam)
 	router.GET("/claude/v1/models", getModels)
 
+	// WebSocket，用于会缓冲 SSE 的代理之后
+	router.GET("/ws/chat", WSChatHandler)
+
+	router.GET("/metrics", MetricsHandler)
+	// 管理接口会暴露路由配置，只在配置了 adminToken 时注册
+	if XConfig.AdminToken != "" {
+		router.GET("/admin/resolve", adminAuth, AdminResolveHandler)
+	}
+
 	router.POST("/upload/oss", Upload)
 	router.GET("/upload/oss/list", OssList)
 
+	// 透传代理
+	router.NoRoute(PassthroughHandler)
+
 	log.Println("Claude proxy server running at :" + strconv.Itoa(XConfig.Port))
 	router.Run(":" + strconv.Itoa(XConfig.Port))
 }
@@ -174,9 +224,10 @@ func getModels(c *gin.Context) {
 		}
 	} else {
 		var models []map[string]interface{}
-		list, err := getModelsByUrl()
+		list, err := getModelsByUrl(c.Request.Context())
 		if err != nil {
 			c.JSON(http.StatusOK, gin.H{"models": models})
+			return
 		}
 		for _, v := range list.Data {
 			family := ""
@@ -207,6 +258,18 @@ func getModels(c *gin.Context) {
 			models = append(models, model)
 		}
 	}
+	// 通过 /api/create 创建的虚拟模型
+	for _, name := range virtualModelNames() {
+		vm, _ := lookupVirtualModel(name)
+		models = append(models, map[string]interface{}{
+			"name":        name,
+			"model":       name,
+			"modified_at": vm.ModifiedAt.Format(time.RFC3339),
+			"size":        0,
+			"digest":      ollamaModelDigest(name),
+			"details":     ollamaModelDetails(name),
+		})
+	}
 	c.JSON(http.StatusOK, gin.H{"models": models})
 }
 
@@ -230,148 +293,105 @@ func chatHandlerSteam(c *gin.Context) {
 		_, _ = c.Writer.Write(jsonStr)
 		return
 	}
-	//log.Println("Received request:", input)
-
-	models := make([]string, 0)
-	hasModels := make([]string, 0)
-	for model := range XConfig.DifyAppMapProd {
-		models = append(models, model)
-		if model == input.Model {
-			XConfig.IsProd = true
-			break
-		} else {
-			hasModels = append(models, model)
-		}
-	}
-	if len(hasModels) == len(models) {
-		XConfig.IsProd = false
-	}
-
-	url := XConfig.APIURL
-	if XConfig.IsProd {
-		url = XConfig.APIURLProd
-	}
 
-	// 构造 API 请求 Ollama to Dify or Anthropic 依赖 XConfig.IsProd
-	payload, err := GenRequest(&input)
-	if err != nil {
-		log.Println("Encode error:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encode request"})
+	streaming := input.Stream == nil || *input.Stream
+	if streaming && resumeGeneration(c) {
 		return
 	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(payload))
+	provider, upstreamModel, err := selectProvider(input.Model)
 	if err != nil {
-		log.Println("Request error:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
-	if XConfig.ChatType == "dify" {
-		//log.Println("current DifyToken:", XConfig.DifyToken)
-		req.Header.Set("Authorization", "Bearer "+XConfig.DifyTokenMap[input.Model])
-	} else {
-		req.Header.Set("Authorization", "Bearer "+XConfig.APIKey)
+	if streaming {
+		if gen := startGeneration(c); gen != nil {
+			defer gen.finish()
+		}
 	}
-	req.Header.Set("x-api-key", XConfig.APIKey)
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("anthropic-version", "2023-06-01")
-
-	// 发起请求
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Println("Request error:", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "API request failed"})
+	provider = withThinkTags(c, input.Model, withContinuation(c, input.Model, provider))
+	req := OllamaToChatRequest(&input)
+	req.Model = upstreamModel
+	start := time.Now()
+	watch := watchClient(c, input.Model)
+
+	if input.Stream != nil && !*input.Stream {
+		result := chatResult{}
+		err := provider.ChatStream(watch.ctx, req, watch.emit(func(ev ChatEvent) error {
+			result.add(ev)
+			return nil
+		}))
+		if watch.gone() {
+			return
+		}
+		err = watch.failure(err)
+		if err != nil {
+			ollamaUpstreamError(c, err)
+			return
+		}
+		msg := ChatEventToOllama(ChatEvent{Content: result.Content, Reasoning: result.Reasoning, ToolCalls: result.ToolCalls}, input.Model)
+		finishOllama(msg, result.FinishReason, result.Usage, start, time.Time{})
+		applyEventHeader(c, result.Header)
+		c.JSON(http.StatusOK, msg)
 		return
 	}
-	defer resp.Body.Close()
-	log.Println("Claude API response status:", resp.Status, XConfig.APIURL, string(payload))
 
 	// 设置为流式响应
-	// c.Header("content-Type", "text/event-stream")
-	c.Header("content-Type", "application/x-ndjson")
-	c.Header("cache-control", "no-cache")
-	c.Header("Connection", "keep-alive")
-
-	scanner := bufio.NewScanner(resp.Body)
-	for scanner.Scan() {
-		line := scanner.Bytes()
-		if len(string(line)) < 6 {
-			continue
-		}
-		if string(line)[:5] != "data:" && string(line)[:6] != "[DONE]" {
-			continue
-		}
-		//fmt.Println("收到一行:", string(line))
-		jsonStr, err := GenResponse(line, &input)
-		//log.Println("返回一行:", string(jsonStr))
-		if err != nil {
-			log.Println("Encode error:", err)
-			continue
+	stream := &ndjsonStream{c: c}
+	if watch.rule.NDJSONHeartbeat {
+		// 心跳为内容为空的分片
+		watch.keepalive(func() error {
+			return stream.write(ChatEventToOllama(ChatEvent{}, input.Model))
+		})
+	}
+	finished := false
+	var firstToken time.Time
+	usage := Usage{}
+	err = provider.ChatStream(watch.ctx, req, watch.emit(func(ev ChatEvent) error {
+		applyEventHeader(c, ev.Header)
+		if ev.Usage != nil {
+			usage = *ev.Usage
 		}
-		if string(jsonStr) == "null" {
-			continue
+		if !ev.hasOutput() {
+			return nil
 		}
-		_, writeErr := c.Writer.Write(jsonStr)
-		if writeErr != nil {
-			log.Println("Write error:", writeErr)
-			continue
-
+		if firstToken.IsZero() {
+			firstToken = time.Now()
 		}
-		_, writeErr = c.Writer.Write([]byte("\r\n"))
-		if writeErr != nil {
-			log.Println("Write error:", writeErr)
-			continue
-
+		msg := ChatEventToOllama(ev, input.Model)
+		if ev.FinishReason != "" {
+			finished = true
+			finishOllama(msg, ev.FinishReason, usage, start, firstToken)
+		} else if ev.Content == "" && ev.Reasoning == "" && len(ev.ToolCalls) == 0 {
+			return nil
 		}
-		c.Writer.Flush()
-
+		return stream.write(msg)
+	}))
+	if watch.gone() {
+		return
 	}
-
-}
-
-func GenRequest(input *OllamaChatRequest) ([]byte, error) {
-	if XConfig == nil {
-		return nil, nil
+	err = watch.failure(err)
+	if err != nil && !stream.started {
+		ollamaUpstreamError(c, err)
+		return
 	}
-	switch XConfig.ChatType {
-	case "dify":
-		if XConfig.DifyTokenMap[input.Model] == "" {
-			err := getDifyToken(input.Model)
-			if err != nil {
-				return nil, err
-			}
-		}
-		re := ToDityRequest(input)
-		return json.Marshal(re)
-	case "claude":
-		re := toClaudeRequest(input.Messages)
-		return json.Marshal(re)
-	default:
-		return nil, nil
+	if err != nil {
+		log.Println("Stream error:", err)
+		_ = stream.write(&OllamaResponse{Model: input.Model, Error: err.Error(), Done: true})
+		return
+	}
+	if !finished {
+		msg := ChatEventToOllama(ChatEvent{}, input.Model)
+		finishOllama(msg, FinishReasonStop, usage, start, firstToken)
+		_ = stream.write(msg)
 	}
-
 }
 
-func GenResponse(input []byte, req *OllamaChatRequest) ([]byte, error) {
-	if XConfig == nil {
-		return nil, nil
-	}
-	switch XConfig.ChatType {
-	case "dify":
-		re, err := DifyToOllamaResponse(input, req)
-		if err != nil {
-			return nil, nil
-		}
-		return json.Marshal(re)
-	case "claude":
-		re, err := ClaudeBlockToOllamaResponse(input, req)
-		if err != nil {
-			return nil, nil
-		}
-		return json.Marshal(re)
-	default:
-		return nil, nil
+func ollamaUpstreamError(c *gin.Context, err error) {
+	log.Println("Request error:", err)
+	var upstream *UpstreamError
+	if errors.As(err, &upstream) {
+		c.JSON(upstream.StatusCode, gin.H{"error": upstream.Body})
+		return
 	}
-
+	c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
 }
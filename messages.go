@@ -0,0 +1,45 @@
+package main
+
+import "strings"
+
+// 各后端对消息序列的要求不同：Anthropic 与 Gemini 没有 system 角色，且要求 user / assistant 交替、从 user 开始；
+// Dify 只接收一条 query。这里提供按后端规整消息的公共处理。
+
+// leadingUserTurn 对话以 assistant 开头时补在前面的 user 消息，Anthropic / Gemini 要求第一轮为 user
+const leadingUserTurn = "..."
+
+// splitSystem 取出 system / developer 消息的文本（多条以空行连接），其余消息保持顺序
+func splitSystem(messages []ChatCompletionMessage) (string, []ChatCompletionMessage) {
+	var system []string
+	rest := make([]ChatCompletionMessage, 0, len(messages))
+	for _, m := range messages {
+		switch m.Role {
+		case ChatMessageRoleSystem, ChatMessageRoleDeveloper:
+			if text := messageText(m.Content); text != "" {
+				system = append(system, text)
+			}
+		default:
+			rest = append(rest, m)
+		}
+	}
+	return strings.Join(system, "\n\n"), rest
+}
+
+// lastUserTurn 末尾连续的 user 消息合并为一条文本，用于只接收单条输入的后端
+func lastUserTurn(messages []ChatCompletionMessage) string {
+	end := len(messages)
+	for end > 0 && messages[end-1].Role != ChatMessageRoleUser {
+		end--
+	}
+	start := end
+	for start > 0 && messages[start-1].Role == ChatMessageRoleUser {
+		start--
+	}
+	texts := make([]string, 0, end-start)
+	for _, m := range messages[start:end] {
+		if text := messageText(m.Content); text != "" {
+			texts = append(texts, text)
+		}
+	}
+	return strings.Join(texts, "\n\n")
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestClaudeSystemAndAlternation(t *testing.T) {
+	req := &ChatCompletionRequest{Messages: []ChatCompletionMessage{
+		{Role: ChatMessageRoleSystem, Content: "be brief"},
+		{Role: ChatMessageRoleAssistant, Content: "hello"},
+		{Role: ChatMessageRoleSystem, Content: "answer in French"},
+		{Role: ChatMessageRoleUser, Content: "hi"},
+		{Role: ChatMessageRoleUser, Content: "are you there?"},
+		{Role: ChatMessageRoleAssistant, ToolCalls: []ToolCall{{ID: "call_1", Function: FunctionCall{Name: "f"}}}},
+		{Role: ChatMessageRoleTool, ToolCallID: "call_1", Content: "42"},
+	}}
+	out := ChatToClaudeRequest(req)
+	if out.System != "be brief\n\nanswer in French" {
+		t.Fatalf("system = %q", out.System)
+	}
+	data, _ := json.Marshal(out.Messages)
+	want := `[{"role":"user","content":[{"type":"text","text":"..."}]},` +
+		`{"role":"assistant","content":[{"type":"text","text":"hello"}]},` +
+		`{"role":"user","content":[{"type":"text","text":"hi"},{"type":"text","text":"are you there?"}]},` +
+		`{"role":"assistant","content":[{"type":"tool_use","id":"call_1","name":"f","input":{}}]},` +
+		`{"role":"user","content":[{"type":"tool_result","tool_use_id":"call_1","content":"42"}]}]`
+	if string(data) != want {
+		t.Fatalf("messages = %s", data)
+	}
+
+	only := ChatToClaudeRequest(&ChatCompletionRequest{Messages: []ChatCompletionMessage{{Role: ChatMessageRoleSystem, Content: "ping"}}})
+	if only.System != "" || len(only.Messages) != 1 || only.Messages[0].Content[0].Text != "ping" {
+		t.Fatalf("system only = %+v", only)
+	}
+}
+
+func TestGeminiLeadingUserTurn(t *testing.T) {
+	out := ChatToGeminiRequest(&ChatCompletionRequest{Messages: []ChatCompletionMessage{
+		{Role: ChatMessageRoleSystem, Content: "be brief"},
+		{Role: ChatMessageRoleAssistant, Content: "hello"},
+		{Role: ChatMessageRoleUser, Content: "hi"},
+	}}, nil)
+	if out.SystemInstruction == nil || out.SystemInstruction.Parts[0].Text != "be brief" {
+		t.Fatalf("systemInstruction = %+v", out.SystemInstruction)
+	}
+	if len(out.Contents) != 3 || out.Contents[0].Role != "user" || out.Contents[1].Role != "model" {
+		t.Fatalf("contents = %+v", out.Contents)
+	}
+}
+
+func TestDifySystemPrompt(t *testing.T) {
+	req := &ChatCompletionRequest{Messages: []ChatCompletionMessage{
+		{Role: ChatMessageRoleSystem, Content: "be brief"},
+		{Role: ChatMessageRoleUser, Content: "old question"},
+		{Role: ChatMessageRoleAssistant, Content: "old answer"},
+		{Role: ChatMessageRoleUser, Content: "part one"},
+		{Role: ChatMessageRoleUser, Content: "part two"},
+	}}
+	XConfig = &Config{}
+	defer func() { XConfig = nil }()
+	if got := GptToDityRequest(req); got.Query != "be brief\n\npart one\n\npart two" || len(got.Inputs) != 0 {
+		t.Fatalf("prefix: %+v", got)
+	}
+	XConfig.DifySystemInput = "system_prompt"
+	if got := GptToDityRequest(req); got.Query != "part one\n\npart two" || got.Inputs["system_prompt"] != "be brief" {
+		t.Fatalf("inputs: %+v", got)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 进程内指标，GET /metrics 以 Prometheus 文本格式输出
+
+type metricSeries struct {
+	kind   string // gauge / counter
+	help   string
+	values map[string]float64 // 标签串 -> 值
+}
+
+var (
+	metricsMu sync.Mutex
+	metricSet = map[string]*metricSeries{}
+)
+
+// metricLabels 标签按名称排序后拼成 {a="x",b="y"}
+func metricLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[name])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, value))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func metricSeriesFor(name, kind, help string) *metricSeries {
+	series, ok := metricSet[name]
+	if !ok {
+		series = &metricSeries{kind: kind, help: help, values: map[string]float64{}}
+		metricSet[name] = series
+	}
+	return series
+}
+
+// setGauge 设置 gauge 的当前值
+func setGauge(name, help string, labels map[string]string, value float64) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	metricSeriesFor(name, "gauge", help).values[metricLabels(labels)] = value
+}
+
+// addCounter counter 累加
+func addCounter(name, help string, labels map[string]string, delta float64) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	metricSeriesFor(name, "counter", help).values[metricLabels(labels)] += delta
+}
+
+// MetricsHandler GET /metrics
+func MetricsHandler(c *gin.Context) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	names := make([]string, 0, len(metricSet))
+	for name := range metricSet {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var sb strings.Builder
+	for _, name := range names {
+		series := metricSet[name]
+		fmt.Fprintf(&sb, "# HELP %s %s\n# TYPE %s %s\n", name, series.help, name, series.kind)
+		labels := make([]string, 0, len(series.values))
+		for label := range series.values {
+			labels = append(labels, label)
+		}
+		sort.Strings(labels)
+		for _, label := range labels {
+			fmt.Fprintf(&sb, "%s%s %g\n", name, label, series.values[label])
+		}
+	}
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(sb.String()))
+}
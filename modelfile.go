@@ -0,0 +1,509 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// VirtualModel 通过 /api/create 由 Modelfile 创建的虚拟模型，请求时在基础模型之上追加 system、示例消息和参数
+type VirtualModel struct {
+	Name       string                 `json:"name"`
+	From       string                 `json:"from"`
+	System     string                 `json:"system,omitempty"`
+	Template   string                 `json:"template,omitempty"`
+	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Messages   []OllamaMessage        `json:"messages,omitempty"`
+	ModifiedAt time.Time              `json:"modified_at"`
+}
+
+var (
+	virtualModelsMu sync.RWMutex
+	virtualModels   = map[string]*VirtualModel{}
+)
+
+// virtualModelsFile 虚拟模型持久化文件，默认为当前目录下的 models.json
+func virtualModelsFile() string {
+	if XConfig != nil && XConfig.ModelsFile != "" {
+		return XConfig.ModelsFile
+	}
+	return "models.json"
+}
+
+// loadVirtualModels 启动时读取已创建的虚拟模型，文件不存在时忽略
+func loadVirtualModels() error {
+	data, err := os.ReadFile(virtualModelsFile())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []*VirtualModel
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	virtualModelsMu.Lock()
+	defer virtualModelsMu.Unlock()
+	virtualModels = map[string]*VirtualModel{}
+	for _, vm := range list {
+		virtualModels[vm.Name] = vm
+	}
+	return nil
+}
+
+// saveVirtualModels 调用方需持有写锁
+func saveVirtualModels() error {
+	list := make([]*VirtualModel, 0, len(virtualModels))
+	for _, vm := range virtualModels {
+		list = append(list, vm)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := virtualModelsFile()
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// virtualModelCycle 以 from 为基础创建 name 是否会形成循环
+func virtualModelCycle(name, from string) bool {
+	from = ollamaModelName(from)
+	for i := 0; i <= maxVirtualModelDepth; i++ {
+		if from == name {
+			return true
+		}
+		vm, ok := lookupVirtualModel(from)
+		if !ok {
+			return false
+		}
+		from = ollamaModelName(vm.From)
+	}
+	return true
+}
+
+func lookupVirtualModel(name string) (*VirtualModel, bool) {
+	virtualModelsMu.RLock()
+	defer virtualModelsMu.RUnlock()
+	vm, ok := virtualModels[ollamaModelName(name)]
+	return vm, ok
+}
+
+// virtualModelNames 按名称排序的虚拟模型列表
+func virtualModelNames() []string {
+	virtualModelsMu.RLock()
+	defer virtualModelsMu.RUnlock()
+	names := make([]string, 0, len(virtualModels))
+	for name := range virtualModels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// maxVirtualModelDepth 虚拟模型 FROM 链的最大层数
+const maxVirtualModelDepth = 8
+
+// ollamaBaseModel 沿 FROM 找到最终路由的基础模型，用于推断上下文长度和能力
+func ollamaBaseModel(name string) string {
+	name = ollamaModelName(name)
+	for i := 0; i < maxVirtualModelDepth; i++ {
+		vm, ok := lookupVirtualModel(name)
+		if !ok {
+			break
+		}
+		name = ollamaModelName(vm.From)
+	}
+	return name
+}
+
+// parseModelfile 解析 Modelfile，支持 FROM SYSTEM TEMPLATE PARAMETER MESSAGE，
+// 其余指令（ADAPTER LICENSE 等）对代理没有意义，忽略
+func parseModelfile(text string) (*VirtualModel, error) {
+	vm := &VirtualModel{Parameters: map[string]interface{}{}}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.SplitN(line, " ", 2)
+		command := strings.ToUpper(fields[0])
+		rest := ""
+		if len(fields) == 2 {
+			rest = strings.TrimSpace(fields[1])
+		}
+		// 三引号的值可以跨行
+		if strings.HasPrefix(rest, `"""`) {
+			value := rest[3:]
+			for !strings.Contains(value, `"""`) {
+				i++
+				if i >= len(lines) {
+					return nil, fmt.Errorf("unterminated \"\"\" in %s", command)
+				}
+				value += "\n" + lines[i]
+			}
+			rest = value[:strings.Index(value, `"""`)]
+		} else {
+			rest = unquoteModelfile(rest)
+		}
+		switch command {
+		case "FROM":
+			vm.From = rest
+		case "SYSTEM":
+			vm.System = rest
+		case "TEMPLATE":
+			vm.Template = rest
+		case "PARAMETER":
+			kv := strings.SplitN(rest, " ", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid PARAMETER: %s", rest)
+			}
+			setModelParameter(vm.Parameters, strings.ToLower(kv[0]), unquoteModelfile(strings.TrimSpace(kv[1])))
+		case "MESSAGE":
+			kv := strings.SplitN(rest, " ", 2)
+			role := strings.ToLower(kv[0])
+			if role != "system" && role != "user" && role != "assistant" {
+				return nil, fmt.Errorf("invalid MESSAGE role: %s", kv[0])
+			}
+			content := ""
+			if len(kv) == 2 {
+				content = unquoteModelfile(strings.TrimSpace(kv[1]))
+			}
+			vm.Messages = append(vm.Messages, OllamaMessage{Role: role, Content: content})
+		case "ADAPTER", "LICENSE", "REQUIRES":
+		default:
+			return nil, fmt.Errorf("unknown Modelfile command: %s", fields[0])
+		}
+	}
+	if vm.From == "" {
+		return nil, fmt.Errorf("no FROM line")
+	}
+	return vm, nil
+}
+
+func unquoteModelfile(value string) string {
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			return unquoted
+		}
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// setModelParameter 数值参数按数字保存，stop 可以出现多次
+func setModelParameter(params map[string]interface{}, key, value string) {
+	if key == "stop" {
+		stops, _ := params["stop"].([]string)
+		params["stop"] = append(stops, value)
+		return
+	}
+	if n, err := strconv.ParseFloat(value, 64); err == nil {
+		params[key] = n
+		return
+	}
+	if b, err := strconv.ParseBool(value); err == nil {
+		params[key] = b
+		return
+	}
+	params[key] = value
+}
+
+// modelfile 还原为 Modelfile 文本，用于 /api/show
+func (vm *VirtualModel) modelfile() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# Modelfile generated by \"ollama show\"\n# To build a new Modelfile based on this, replace FROM with:\n# FROM %s\n\nFROM %s\n", vm.Name, vm.From)
+	if vm.Template != "" {
+		fmt.Fprintf(&sb, "TEMPLATE \"\"\"%s\"\"\"\n", vm.Template)
+	}
+	if vm.System != "" {
+		fmt.Fprintf(&sb, "SYSTEM \"\"\"%s\"\"\"\n", vm.System)
+	}
+	sb.WriteString(vm.parameterLines("PARAMETER "))
+	for _, m := range vm.Messages {
+		fmt.Fprintf(&sb, "MESSAGE %s %s\n", m.Role, strconv.Quote(m.Content))
+	}
+	return sb.String()
+}
+
+// parameterLines 参数按名称排序输出，每行一个
+func (vm *VirtualModel) parameterLines(prefix string) string {
+	keys := make([]string, 0, len(vm.Parameters))
+	for key := range vm.Parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var sb strings.Builder
+	for _, key := range keys {
+		switch v := vm.Parameters[key].(type) {
+		case []string:
+			for _, stop := range v {
+				fmt.Fprintf(&sb, "%s%s %s\n", prefix, key, strconv.Quote(stop))
+			}
+		case []interface{}:
+			for _, stop := range v {
+				fmt.Fprintf(&sb, "%s%s %s\n", prefix, key, strconv.Quote(fmt.Sprint(stop)))
+			}
+		default:
+			fmt.Fprintf(&sb, "%s%s %v\n", prefix, key, v)
+		}
+	}
+	return sb.String()
+}
+
+func parameterFloat(params map[string]interface{}, key string) (float64, bool) {
+	switch v := params[key].(type) {
+	case float64:
+		return v, true
+	case int:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// apply 把虚拟模型的设置合并进请求：没有 system 消息时加上 SYSTEM，MESSAGE 示例放在对话之前，
+// 参数只在请求未指定时生效
+func (vm *VirtualModel) apply(req *ChatCompletionRequest) *ChatCompletionRequest {
+	out := *req
+	var head []ChatCompletionMessage
+	hasSystem := false
+	for _, m := range req.Messages {
+		if m.Role == "system" || m.Role == "developer" {
+			hasSystem = true
+			break
+		}
+	}
+	if vm.System != "" && !hasSystem {
+		head = append(head, ChatCompletionMessage{Role: "system", Content: vm.System})
+	}
+	for _, m := range vm.Messages {
+		head = append(head, ChatCompletionMessage{Role: m.Role, Content: m.Content})
+	}
+	if len(head) > 0 {
+		// system 消息需要保持在最前面
+		i := 0
+		for i < len(req.Messages) && (req.Messages[i].Role == "system" || req.Messages[i].Role == "developer") {
+			i++
+		}
+		messages := append([]ChatCompletionMessage{}, req.Messages[:i]...)
+		messages = append(messages, head...)
+		out.Messages = append(messages, req.Messages[i:]...)
+	}
+	if v, ok := parameterFloat(vm.Parameters, "temperature"); ok && out.Temperature == nil {
+		temperature := float32(v)
+		out.Temperature = &temperature
+	}
+	if v, ok := parameterFloat(vm.Parameters, "top_p"); ok && out.TopP == 0 {
+		out.TopP = float32(v)
+	}
+	if v, ok := parameterFloat(vm.Parameters, "num_predict"); ok && v > 0 && out.MaxTokens == 0 {
+		out.MaxTokens = int(v)
+	}
+	if v, ok := parameterFloat(vm.Parameters, "presence_penalty"); ok && out.PresencePenalty == 0 {
+		out.PresencePenalty = float32(v)
+	}
+	if v, ok := parameterFloat(vm.Parameters, "frequency_penalty"); ok && out.FrequencyPenalty == 0 {
+		out.FrequencyPenalty = float32(v)
+	}
+	if v, ok := parameterFloat(vm.Parameters, "top_k"); ok && out.TopK == nil {
+		topK := int(v)
+		out.TopK = &topK
+	}
+	if v, ok := parameterFloat(vm.Parameters, "repeat_penalty"); ok && out.RepetitionPenalty == nil {
+		penalty := float32(v)
+		out.RepetitionPenalty = &penalty
+	}
+	if v, ok := parameterFloat(vm.Parameters, "seed"); ok && out.Seed == nil {
+		seed := int(v)
+		out.Seed = &seed
+	}
+	if len(out.Stop) == 0 {
+		out.Stop = vm.stops()
+	}
+	return &out
+}
+
+func (vm *VirtualModel) stops() []string {
+	switch v := vm.Parameters["stop"].(type) {
+	case []string:
+		return v
+	case []interface{}:
+		stops := make([]string, 0, len(v))
+		for _, stop := range v {
+			stops = append(stops, fmt.Sprint(stop))
+		}
+		return stops
+	default:
+		return nil
+	}
+}
+
+// virtualProvider 在基础模型的后端之前应用虚拟模型设置
+type virtualProvider struct {
+	Model *VirtualModel
+	Inner ChatProvider
+}
+
+func (p *virtualProvider) ChatStream(ctx context.Context, req *ChatCompletionRequest, emit func(ChatEvent) error) error {
+	return p.Inner.ChatStream(ctx, p.Model.apply(req), emit)
+}
+
+// virtualCompletionProvider 基础后端支持原生续写时使用，续写只应用采样参数
+type virtualCompletionProvider struct {
+	*virtualProvider
+	Completer CompletionProvider
+}
+
+func (p *virtualCompletionProvider) Complete(ctx context.Context, req *CompletionRequest, emit func(ChatEvent) error) error {
+	out := *req
+	if v, ok := parameterFloat(p.Model.Parameters, "temperature"); ok && out.Temperature == nil {
+		temperature := float32(v)
+		out.Temperature = &temperature
+	}
+	if v, ok := parameterFloat(p.Model.Parameters, "top_p"); ok && out.TopP == 0 {
+		out.TopP = float32(v)
+	}
+	if v, ok := parameterFloat(p.Model.Parameters, "num_predict"); ok && v > 0 && out.MaxTokens == 0 {
+		out.MaxTokens = int(v)
+	}
+	if len(out.Stop) == 0 {
+		out.Stop = p.Model.stops()
+	}
+	return p.Completer.Complete(ctx, &out, emit)
+}
+
+func wrapVirtualProvider(vm *VirtualModel, inner ChatProvider) ChatProvider {
+	base := &virtualProvider{Model: vm, Inner: inner}
+	if completer, ok := inner.(CompletionProvider); ok {
+		return &virtualCompletionProvider{virtualProvider: base, Completer: completer}
+	}
+	return base
+}
+
+// innerProvider 去掉虚拟模型的包装，返回 FROM 链最底层的后端；向量化不使用虚拟模型的提示词和参数
+func innerProvider(provider ChatProvider) ChatProvider {
+	for {
+		switch p := provider.(type) {
+		case *virtualProvider:
+			provider = p.Inner
+		case *virtualCompletionProvider:
+			provider = p.Inner
+		default:
+			return provider
+		}
+	}
+}
+
+// ollamaCreateRequest /api/create 请求，新版客户端在本地解析 Modelfile 后发送结构化字段，旧版直接发送 modelfile 文本
+type ollamaCreateRequest struct {
+	Model      string                 `json:"model"`
+	Name       string                 `json:"name"`
+	From       string                 `json:"from"`
+	System     string                 `json:"system"`
+	Template   string                 `json:"template"`
+	Parameters map[string]interface{} `json:"parameters"`
+	Messages   []OllamaMessage        `json:"messages"`
+	Modelfile  string                 `json:"modelfile"`
+	Stream     *bool                  `json:"stream"`
+}
+
+// OllamaCreateHandler POST /api/create
+func OllamaCreateHandler(c *gin.Context) {
+	var input ollamaCreateRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	name := ollamaModelName(input.Model)
+	if name == "" {
+		name = ollamaModelName(input.Name)
+	}
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+	vm := &VirtualModel{
+		From:       input.From,
+		System:     input.System,
+		Template:   input.Template,
+		Parameters: input.Parameters,
+		Messages:   input.Messages,
+	}
+	if input.Modelfile != "" {
+		parsed, err := parseModelfile(input.Modelfile)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		vm = parsed
+	}
+	vm.Name = name
+	vm.From = ollamaModelName(vm.From)
+	vm.ModifiedAt = time.Now().UTC()
+	if vm.From == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "neither 'from' or 'modelfile' was specified"})
+		return
+	}
+	if virtualModelCycle(name, vm.From) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("model '%s' cannot be created from itself", name)})
+		return
+	}
+	if !ollamaModelKnown(vm.From) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("base model '%s' not found", vm.From)})
+		return
+	}
+
+	virtualModelsMu.Lock()
+	virtualModels[name] = vm
+	err := saveVirtualModels()
+	virtualModelsMu.Unlock()
+	if err != nil {
+		log.Println("保存虚拟模型失败:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	log.Println("创建虚拟模型:", name, "FROM", vm.From)
+
+	if input.Stream != nil && !*input.Stream {
+		c.JSON(http.StatusOK, gin.H{"status": "success"})
+		return
+	}
+	stream := &ndjsonStream{c: c}
+	for _, status := range []string{"using existing layer sha256:" + ollamaModelDigest(vm.From), "creating new layer sha256:" + ollamaModelDigest(name), "writing manifest", "success"} {
+		if err := stream.write(gin.H{"status": status}); err != nil {
+			log.Println("Stream error:", err)
+			return
+		}
+	}
+}
+
+// deleteVirtualModel 删除虚拟模型，不存在时返回 false
+func deleteVirtualModel(name string) (bool, error) {
+	virtualModelsMu.Lock()
+	defer virtualModelsMu.Unlock()
+	name = ollamaModelName(name)
+	if _, ok := virtualModels[name]; !ok {
+		return false, nil
+	}
+	delete(virtualModels, name)
+	return true, saveVirtualModels()
+}
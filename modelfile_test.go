@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestParseModelfile(t *testing.T) {
+	vm, err := parseModelfile(`# reviewer
+FROM coder:latest
+PARAMETER temperature 0.2
+PARAMETER stop "<|end|>"
+PARAMETER stop ###
+SYSTEM """You review Go code.
+Be strict."""
+MESSAGE user "is this ok?"
+MESSAGE assistant no
+LICENSE MIT
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vm.From != "coder:latest" || vm.System != "You review Go code.\nBe strict." || vm.Parameters["temperature"] != 0.2 {
+		t.Errorf("vm = %+v", vm)
+	}
+	if stops := vm.stops(); len(stops) != 2 || stops[0] != "<|end|>" || stops[1] != "###" {
+		t.Errorf("stops = %q", stops)
+	}
+	if len(vm.Messages) != 2 || vm.Messages[0].Content != "is this ok?" || vm.Messages[1].Role != "assistant" {
+		t.Errorf("messages = %+v", vm.Messages)
+	}
+	for _, bad := range []string{"SYSTEM hi", "FROM a\nMESSAGE tool x", "FROM a\nSYSTEM \"\"\"open", "FROM a\nBOGUS x"} {
+		if _, err := parseModelfile(bad); err == nil {
+			t.Errorf("parseModelfile(%q) should fail", bad)
+		}
+	}
+}
+
+func TestVirtualModelLifecycle(t *testing.T) {
+	server := openaiStandIn()
+	defer server.Close()
+
+	modelsFile := filepath.Join(t.TempDir(), "models.json")
+	XConfig = &Config{
+		ChatType:   "dify",
+		ModelsFile: modelsFile,
+		Providers:  []ProviderConfig{{Name: "local", Type: "openai", BaseUrl: server.URL, Models: []string{"coder"}}},
+	}
+	defer func() {
+		XConfig = nil
+		virtualModels = map[string]*VirtualModel{}
+	}()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/create", OllamaCreateHandler)
+	router.POST("/api/show", OllamaShowHandler)
+	router.GET("/api/tags", getModels)
+	router.GET("/v1/models", GetGptModels)
+	router.POST("/api/chat", chatHandlerSteam)
+	router.DELETE("/api/delete", OllamaDeleteHandler)
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	modelfile, _ := json.Marshal("FROM coder\nSYSTEM be strict\nPARAMETER temperature 0.2\nPARAMETER num_ctx 8192")
+	w := call("POST", "/api/create", `{"model":"reviewer","modelfile":`+string(modelfile)+`}`)
+	if lines := strings.Split(strings.TrimSpace(w.Body.String()), "\r\n"); w.Code != http.StatusOK || lines[len(lines)-1] != `{"status":"success"}` {
+		t.Fatalf("create = %d %s", w.Code, w.Body.String())
+	}
+	// 新版客户端发送结构化字段，可以基于另一个虚拟模型创建
+	if w := call("POST", "/api/create", `{"model":"nitpicker","from":"reviewer","parameters":{"stop":["END"]},"stream":false}`); w.Code != http.StatusOK {
+		t.Fatalf("create from virtual = %d %s", w.Code, w.Body.String())
+	}
+	if w := call("POST", "/api/create", `{"model":"x","from":"missing"}`); w.Code != http.StatusNotFound {
+		t.Errorf("create from missing = %d", w.Code)
+	}
+	if w := call("POST", "/api/create", `{"model":"reviewer","from":"nitpicker"}`); w.Code != http.StatusBadRequest {
+		t.Errorf("create cycle = %d", w.Code)
+	}
+
+	show := map[string]interface{}{}
+	_ = json.Unmarshal(call("POST", "/api/show", `{"model":"reviewer"}`).Body.Bytes(), &show)
+	if show["system"] != "be strict" || !strings.Contains(show["modelfile"].(string), "PARAMETER temperature 0.2") ||
+		show["model_info"].(map[string]interface{})["coder.context_length"] != float64(8192) {
+		t.Errorf("show = %v", show)
+	}
+	for _, path := range []string{"/api/tags", "/v1/models"} {
+		if body := call("GET", path, "").Body.String(); !strings.Contains(body, `"reviewer"`) || !strings.Contains(body, `"nitpicker"`) {
+			t.Errorf("%s = %s", path, body)
+		}
+	}
+
+	// 请求时追加 system 并应用参数，参数沿 FROM 链叠加
+	call("POST", "/api/chat", `{"model":"nitpicker","stream":false,"messages":[{"role":"user","content":"review"}]}`)
+	bodies := server.bodies()
+	messages := bodies[0]["messages"].([]interface{})
+	if bodies[0]["model"] != "coder" || len(messages) != 2 || messages[0].(map[string]interface{})["content"] != "be strict" ||
+		bodies[0]["temperature"] != 0.2 || bodies[0]["stop"].([]interface{})[0] != "END" {
+		t.Errorf("upstream body = %v", bodies[0])
+	}
+
+	// 重新加载持久化文件
+	virtualModels = map[string]*VirtualModel{}
+	if err := loadVirtualModels(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := lookupVirtualModel("reviewer:latest"); !ok {
+		t.Errorf("reviewer not persisted")
+	}
+
+	if w := call("DELETE", "/api/delete", `{"model":"nitpicker"}`); w.Code != http.StatusOK {
+		t.Errorf("delete = %d", w.Code)
+	}
+	if w := call("POST", "/api/show", `{"model":"nitpicker"}`); w.Code != http.StatusNotFound {
+		t.Errorf("show deleted = %d", w.Code)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// modelRewriter 按 replace 改写写出的 JSON 中顶层 "model" 字段的值，逐字节处理，只缓冲 model 的值，不缓冲整个请求或响应。
+// 流中可以有多个顶层 JSON（SSE 每条 data 一个对象、NDJSON 每行一个对象），JSON 之外的内容原样输出。
+type modelRewriter struct {
+	w       io.Writer
+	replace func(model string) string
+
+	depth    int
+	inObject bool // 当前顶层 JSON 是对象
+	inString bool
+	escaped  bool
+	// expectKey 顶层对象中下一个字符串是字段名；key 记录字段名，超过 len("model") 后不再记录
+	expectKey bool
+	readKey   bool
+	key       []byte
+	// 读完 "model": 等待字段值；replacing 为正在读取原值，value 为读到的原值（含引号）
+	expectValue bool
+	replacing   bool
+	value       []byte
+}
+
+func newModelRewriter(w io.Writer, replace func(model string) string) *modelRewriter {
+	return &modelRewriter{w: w, replace: replace}
+}
+
+// modelAlias 总是替换为同一个名称的 replace
+func modelAlias(model string) func(string) string {
+	return func(string) string { return model }
+}
+
+func (r *modelRewriter) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		if r.inString {
+			closing := false
+			switch {
+			case r.escaped:
+				r.escaped = false
+			case b == '\\':
+				r.escaped = true
+			case b == '"':
+				closing, r.inString = true, false
+			}
+			if r.replacing {
+				r.value = append(r.value, b)
+				if closing {
+					r.replacing = false
+					out = append(out, r.rewrite()...)
+				}
+				continue
+			}
+			if r.readKey {
+				if closing {
+					r.readKey = false
+					r.expectValue = string(r.key) == "model"
+				} else if len(r.key) <= len("model") {
+					r.key = append(r.key, b)
+				}
+			}
+			out = append(out, b)
+			continue
+		}
+		switch b {
+		case '"':
+			if r.depth == 1 && r.expectValue {
+				// 原值读完后再写出替换后的值
+				r.inString, r.replacing, r.expectValue = true, true, false
+				r.value = append(r.value[:0], b)
+				continue
+			}
+			r.inString = true
+			if r.depth == 1 && r.inObject && r.expectKey {
+				r.expectKey, r.readKey, r.key = false, true, r.key[:0]
+			}
+		case ':', ' ', '\t', '\r', '\n':
+		default:
+			// model 为 null 等非字符串时保持原样
+			r.expectValue = false
+			switch b {
+			case '{', '[':
+				if r.depth == 0 {
+					r.inObject = b == '{'
+					r.expectKey = r.inObject
+				}
+				r.depth++
+			case '}', ']':
+				if r.depth > 0 {
+					r.depth--
+				}
+			case ',':
+				r.expectKey = r.depth == 1 && r.inObject
+			}
+		}
+		out = append(out, b)
+	}
+	if _, err := r.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// rewrite 解析读到的原值并编码替换后的值，解析失败时原样输出
+func (r *modelRewriter) rewrite() []byte {
+	var model string
+	if err := json.Unmarshal(r.value, &model); err != nil {
+		return r.value
+	}
+	encoded, err := json.Marshal(r.replace(model))
+	if err != nil {
+		return r.value
+	}
+	return encoded
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestModelRewriter(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{`{"id":"1","model":"claude-sonnet-4-20250514","object":"chat.completion"}`, `{"id":"1","model":"deepseek-chat","object":"chat.completion"}`},
+		{`{ "model" : "a\"b" , "x":1}`, `{ "model" : "deepseek-chat" , "x":1}`},
+		{`{"choices":[{"model":"inner"}],"model":null,"object":"model"}`, `{"choices":[{"model":"inner"}],"model":null,"object":"model"}`},
+		{`{"meta":{"model":"inner"},"models":"x","model":"up"}`, `{"meta":{"model":"inner"},"models":"x","model":"deepseek-chat"}`},
+		{"data: {\"model\":\"up\",\"choices\":[]}\n\n: PING\n\ndata: {\"model\":\"up\"}\n\ndata: [DONE]\n\n",
+			"data: {\"model\":\"deepseek-chat\",\"choices\":[]}\n\n: PING\n\ndata: {\"model\":\"deepseek-chat\"}\n\ndata: [DONE]\n\n"},
+		{"{\"model\":\"up\",\"done\":false}\n{\"model\":\"up\",\"done\":true}\n", "{\"model\":\"deepseek-chat\",\"done\":false}\n{\"model\":\"deepseek-chat\",\"done\":true}\n"},
+	}
+	for _, tc := range cases {
+		var b strings.Builder
+		_, _ = newModelRewriter(&b, modelAlias("deepseek-chat")).Write([]byte(tc.in))
+		if b.String() != tc.want {
+			t.Errorf("rewrite %s = %s", tc.in, b.String())
+		}
+		// 任意切分写入结果相同
+		b.Reset()
+		r := newModelRewriter(&b, modelAlias("deepseek-chat"))
+		for i := 0; i < len(tc.in); i++ {
+			_, _ = r.Write([]byte{tc.in[i]})
+		}
+		if b.String() != tc.want {
+			t.Errorf("byte by byte %s = %s", tc.in, b.String())
+		}
+	}
+}
+
+func TestResponseModelAlias(t *testing.T) {
+	server := newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		var body ChatCompletionRequest
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		if !body.Stream {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprint(w, `{"id":"1","object":"chat.completion","model":"claude-sonnet-4-20250514","choices":[{"index":0,"message":{"role":"assistant","content":"Hi"},"finish_reason":"stop"}]}`)
+			return
+		}
+		writeSSE(w, `{"id":"1","model":"claude-sonnet-4-20250514","choices":[{"index":0,"delta":{"content":"Hi"}}]}`, "[DONE]")
+	})
+	defer server.Close()
+	XConfig = &Config{
+		BaseUrl:      server.URL,
+		ProxyMapping: map[string]string{"deepseek-chat": "claude-sonnet-4"},
+		Mapping:      map[string]string{"deepseek-chat": "claude-sonnet-4"},
+		Providers:    []ProviderConfig{{Name: "openai", Type: "openai", BaseUrl: server.URL, Models: []string{"claude-sonnet-4"}}},
+	}
+	defer func() { XConfig = nil }()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/proxy/chat/completions", ProxyChatHandle)
+	router.POST("/v1/chat/completions", OpenaiHandler)
+
+	for _, path := range []string{"/proxy/chat/completions", "/v1/chat/completions"} {
+		for _, stream := range []bool{false, true} {
+			server.reset()
+			w := httptest.NewRecorder()
+			body := fmt.Sprintf(`{"model":"deepseek-chat","stream":%v,"messages":[{"role":"user","content":"hi"}]}`, stream)
+			router.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+			if sent := standInBodies[ChatCompletionRequest](server); len(sent) != 1 || sent[0].Model != "claude-sonnet-4" {
+				t.Fatalf("%s stream=%v: upstream requests %+v", path, stream, sent)
+			}
+			if strings.Contains(w.Body.String(), "claude-sonnet-4") || !strings.Contains(w.Body.String(), `"model":"deepseek-chat"`) {
+				t.Fatalf("%s stream=%v:\n%s", path, stream, w.Body.String())
+			}
+		}
+	}
+}
@@ -1,50 +1,831 @@
 package main
 
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
 type OllamaMessage struct {
-	Role    string `json:"role"`
-	Content string `json:"content"`
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	Thinking  string           `json:"thinking,omitempty"`
+	Images    []string         `json:"images,omitempty"` // base64
+	ToolCalls []OllamaToolCall `json:"tool_calls,omitempty"`
+}
+
+type OllamaToolCall struct {
+	Function struct {
+		Name      string                 `json:"name"`
+		Arguments map[string]interface{} `json:"arguments"`
+	} `json:"function"`
+}
+
+// OllamaOptions 采样参数用指针区分未设置与 0
+type OllamaOptions struct {
+	Context          []string `json:"context"`
+	NumCtx           int      `json:"num_ctx"`
+	NumGpu           int      `json:"num_gpu"`
+	NumGqa           int      `json:"num_gqa"`
+	NumMp            int      `json:"num_mp"`
+	Temperature      *float32 `json:"temperature,omitempty"`
+	TopP             *float32 `json:"top_p,omitempty"`
+	TopK             *int     `json:"top_k,omitempty"`
+	NumPredict       *int     `json:"num_predict,omitempty"` // -1 不限，-2 填满上下文，都按未设置处理
+	Stop             []string `json:"stop,omitempty"`
+	Seed             *int     `json:"seed,omitempty"`
+	RepeatPenalty    *float32 `json:"repeat_penalty,omitempty"`
+	PresencePenalty  *float32 `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float32 `json:"frequency_penalty,omitempty"`
+}
+
+// apply 把 options 中的采样参数写入中立请求
+func (o *OllamaOptions) apply(req *ChatCompletionRequest) {
+	req.Temperature = o.Temperature
+	if o.TopP != nil {
+		req.TopP = *o.TopP
+	}
+	req.TopK = o.TopK
+	if o.NumPredict != nil && *o.NumPredict > 0 {
+		req.MaxTokens = *o.NumPredict
+	}
+	req.Stop = o.Stop
+	req.Seed = o.Seed
+	req.RepetitionPenalty = o.RepeatPenalty
+	if o.PresencePenalty != nil {
+		req.PresencePenalty = *o.PresencePenalty
+	}
+	if o.FrequencyPenalty != nil {
+		req.FrequencyPenalty = *o.FrequencyPenalty
+	}
+}
+
+// applyCompletion 续写接口只有 temperature / top_p / max_tokens / stop，返回其余被忽略的参数名（与对话接口的命名一致）
+func (o *OllamaOptions) applyCompletion(req *CompletionRequest) []string {
+	chat := &ChatCompletionRequest{}
+	o.apply(chat)
+	req.Temperature = chat.Temperature
+	req.TopP = chat.TopP
+	req.MaxTokens = chat.MaxTokens
+	req.Stop = chat.Stop
+	var ignored []string
+	if chat.TopK != nil {
+		ignored = append(ignored, "top_k")
+	}
+	if chat.Seed != nil {
+		ignored = append(ignored, "seed")
+	}
+	if chat.PresencePenalty != 0 {
+		ignored = append(ignored, "presence_penalty")
+	}
+	if chat.FrequencyPenalty != 0 {
+		ignored = append(ignored, "frequency_penalty")
+	}
+	if chat.RepetitionPenalty != nil {
+		ignored = append(ignored, "repetition_penalty")
+	}
+	return ignored
 }
 
 type OllamaChatRequest struct {
 	Model      string          `json:"model"`
 	Messages   []OllamaMessage `json:"messages"`
+	Tools      []Tool          `json:"tools,omitempty"`
 	KeepAlives bool            `json:"keep_alives"`
-	Stream     bool            `json:"stream"`
-	Options    struct {
-		Context []string `json:"context"`
-		NumCtx  int      `json:"num_ctx"`
-		NumGpu  int      `json:"num_gpu"`
-		NumGqa  int      `json:"num_gqa"`
-		NumMp   int      `json:"num_mp"`
-	} `json:"options"`
+	Stream     *bool           `json:"stream"`           // 不传时默认为流式
+	Think      interface{}     `json:"think,omitempty"`  // true/false 或 "low"/"medium"/"high"
+	Format     json.RawMessage `json:"format,omitempty"` // "json" 或 JSON Schema
+	Options    OllamaOptions   `json:"options"`
+}
+
+// OllamaMetrics 结束分片中的统计字段
+type OllamaMetrics struct {
+	TotalDuration      int64 `json:"total_duration,omitempty"`       // 使用 int64 表示可能较大的时间值（单位可能为纳秒）
+	LoadDuration       int64 `json:"load_duration,omitempty"`        // 同样为 int64 类型
+	PromptEvalCount    int   `json:"prompt_eval_count,omitempty"`    // prompt 评估的计数
+	PromptEvalDuration int64 `json:"prompt_eval_duration,omitempty"` // prompt 评估的时间
+	EvalCount          int   `json:"eval_count,omitempty"`           // 评估的次数
+	EvalDuration       int64 `json:"eval_duration,omitempty"`        // 评估的耗时（单位可能为纳秒）
 }
 
 // Ollama响应结构
 type OllamaResponse struct {
-	Model              string        `json:"model"`
-	CreatedAt          string        `json:"created_at"`
-	Message            OllamaMessage `json:"message"`
-	Done               bool          `json:"done"`
-	DoneReason         string        `json:"done_reason,omitempty"`          // 停止原因
-	TotalDuration      int64         `json:"total_duration,omitempty"`       // 使用 int64 表示可能较大的时间值（单位可能为纳秒）
-	LoadDuration       int64         `json:"load_duration,omitempty"`        // 同样为 int64 类型
-	PromptEvalCount    int           `json:"prompt_eval_count,omitempty"`    // prompt 评估的计数
-	PromptEvalDuration int64         `json:"prompt_eval_duration,omitempty"` // prompt 评估的时间
-	EvalCount          int           `json:"eval_count,omitempty"`           // 评估的次数
-	EvalDuration       int64         `json:"eval_duration,omitempty"`        // 评估的耗时（单位可能为纳秒）
-	Error              string        `json:"error,omitempty"`
-}
-
-func toClaudeRequest(input []OllamaMessage) []ClaudeMessageItem {
-	msg := make([]ClaudeMessageItem, 0, len(input))
-	for _, m := range input {
-		if m.Role == "system" {
-			m.Role = "assistant"
-		}
-		msg = append(msg, ClaudeMessageItem{
-			Role:    m.Role,
-			Content: []ClaudeMessageContent{{Type: "text", Text: m.Content}},
+	Model      string        `json:"model"`
+	CreatedAt  string        `json:"created_at"`
+	Message    OllamaMessage `json:"message"`
+	Done       bool          `json:"done"`
+	DoneReason string        `json:"done_reason,omitempty"` // 停止原因
+	OllamaMetrics
+	Error string `json:"error,omitempty"`
+}
+
+// OllamaGenerateRequest /api/generate 请求
+type OllamaGenerateRequest struct {
+	Model     string          `json:"model"`
+	Prompt    string          `json:"prompt"`
+	Suffix    string          `json:"suffix,omitempty"`
+	System    string          `json:"system,omitempty"`
+	Template  string          `json:"template,omitempty"`
+	Context   []int           `json:"context,omitempty"`
+	Raw       bool            `json:"raw,omitempty"`
+	Images    []string        `json:"images,omitempty"` // base64
+	Stream    *bool           `json:"stream"`           // 不传时默认为流式
+	Think     interface{}     `json:"think,omitempty"`
+	Format    json.RawMessage `json:"format,omitempty"`
+	KeepAlive interface{}     `json:"keep_alive,omitempty"`
+	Options   OllamaOptions   `json:"options"`
+}
+
+// OllamaGenerateResponse /api/generate 响应分片
+type OllamaGenerateResponse struct {
+	Model      string `json:"model"`
+	CreatedAt  string `json:"created_at"`
+	Response   string `json:"response"`
+	Thinking   string `json:"thinking,omitempty"`
+	Done       bool   `json:"done"`
+	DoneReason string `json:"done_reason,omitempty"`
+	Context    []int  `json:"context,omitempty"`
+	OllamaMetrics
+	Error string `json:"error,omitempty"`
+}
+
+// ollamaFormat format 为 "json" 时要求输出 JSON 对象，为对象时按 JSON Schema 约束
+func ollamaFormat(raw json.RawMessage) *ChatCompletionResponseFormat {
+	var text string
+	switch {
+	case len(raw) == 0 || string(raw) == "null":
+		return nil
+	case json.Unmarshal(raw, &text) == nil:
+		if text == "json" {
+			return &ChatCompletionResponseFormat{Type: "json_object"}
+		}
+		return nil
+	default:
+		return jsonSchemaFormat(raw)
+	}
+}
+
+// OllamaToChatRequest Ollama /api/chat 请求转为中立的 ChatCompletionRequest
+func OllamaToChatRequest(input *OllamaChatRequest) *ChatCompletionRequest {
+	req := &ChatCompletionRequest{Model: input.Model, Tools: input.Tools, Stream: true, ReasoningEffort: ollamaThinkEffort(input.Think), ResponseFormat: ollamaFormat(input.Format)}
+	input.Options.apply(req)
+	// Ollama 的工具调用没有 id，按顺序生成并分配给之后的 tool 消息
+	var pending []string
+	for i, m := range input.Messages {
+		msg := ChatCompletionMessage{Role: m.Role, Content: m.Content}
+		if len(m.Images) > 0 {
+			parts := make([]ChatMessagePart, 0, len(m.Images)+1)
+			if m.Content != "" {
+				parts = append(parts, ChatMessagePart{Type: "text", Text: m.Content})
+			}
+			for _, img := range m.Images {
+				parts = append(parts, ChatMessagePart{Type: "image_url", ImageURL: &ChatMessageImageURL{URL: imageDataURL(img)}})
+			}
+			msg.Content = parts
+		}
+		for j, call := range m.ToolCalls {
+			id := fmt.Sprintf("call_%d_%d", i, j)
+			pending = append(pending, id)
+			args, _ := json.Marshal(call.Function.Arguments)
+			msg.ToolCalls = append(msg.ToolCalls, ToolCall{
+				ID:       id,
+				Type:     "function",
+				Function: FunctionCall{Name: call.Function.Name, Arguments: string(args)},
+			})
+		}
+		if m.Role == ChatMessageRoleTool && len(pending) > 0 {
+			msg.ToolCallID = pending[0]
+			pending = pending[1:]
+		}
+		req.Messages = append(req.Messages, msg)
+	}
+	return req
+}
+
+// imageDataURL base64 图片转为 data URL，按内容识别 mime 类型
+func imageDataURL(b64 string) string {
+	mimeType := "image/png"
+	if raw, err := base64.StdEncoding.DecodeString(b64); err == nil {
+		mimeType = http.DetectContentType(raw)
+	}
+	return "data:" + mimeType + ";base64," + b64
+}
+
+// ChatEventToOllama 中立事件渲染为 Ollama /api/chat 响应分片
+func ChatEventToOllama(ev ChatEvent, model string) *OllamaResponse {
+	msg := OllamaResponse{
+		Model:     model,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+		Message: OllamaMessage{
+			Role:     "assistant",
+			Content:  ev.Content,
+			Thinking: ev.Reasoning,
+		},
+	}
+	for _, call := range ev.ToolCalls {
+		tc := OllamaToolCall{}
+		tc.Function.Name = call.Function.Name
+		tc.Function.Arguments = map[string]interface{}{}
+		if call.Function.Arguments != "" {
+			_ = json.Unmarshal([]byte(call.Function.Arguments), &tc.Function.Arguments)
+		}
+		msg.Message.ToolCalls = append(msg.Message.ToolCalls, tc)
+	}
+	return &msg
+}
+
+// finishOllama 填充结束分片的状态与统计字段
+func finishOllama(msg *OllamaResponse, reason FinishReason, usage Usage, start, firstToken time.Time) {
+	msg.Done = true
+	msg.DoneReason = ollamaDoneReason(reason)
+	msg.OllamaMetrics.finish(usage, start, firstToken)
+}
+
+func ollamaDoneReason(reason FinishReason) string {
+	if reason == FinishReasonLength {
+		return "length"
+	}
+	return "stop"
+}
+
+// finish 根据用量和首个分片时间计算统计字段，耗时均为纳秒
+func (m *OllamaMetrics) finish(usage Usage, start, firstToken time.Time) {
+	if firstToken.IsZero() {
+		firstToken = time.Now()
+	}
+	m.TotalDuration = time.Since(start).Nanoseconds()
+	m.PromptEvalCount = usage.PromptTokens
+	m.PromptEvalDuration = firstToken.Sub(start).Nanoseconds()
+	m.EvalCount = usage.CompletionTokens
+	m.EvalDuration = time.Since(firstToken).Nanoseconds()
+}
+
+// ndjsonStream 逐行写出 NDJSON，首行写出前才设置响应头，便于把上游错误原样返回
+type ndjsonStream struct {
+	c       *gin.Context
+	started bool
+}
+
+func (s *ndjsonStream) write(v interface{}) error {
+	if !s.started {
+		s.started = true
+		s.c.Header("content-Type", "application/x-ndjson")
+		s.c.Header("cache-control", "no-cache")
+		s.c.Header("Connection", "keep-alive")
+	}
+	jsonStr, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	// 一行一次写出，续传缓冲按写出次数计行
+	if _, err := s.c.Writer.Write(append(jsonStr, '\r', '\n')); err != nil {
+		return err
+	}
+	s.c.Writer.Flush()
+	return nil
+}
+
+// OllamaGenerateHandler POST /api/generate
+// 按请求选择上游调用方式：
+//   - suffix 非空：FIM，上游支持原生续写时直接转发，否则用对话模拟
+//   - raw 或 template：按模板渲染出完整提示词后续写，不维护 context
+//   - 其它：system + context 中的历史 + prompt 组成对话
+func OllamaGenerateHandler(c *gin.Context) {
+	var input OllamaGenerateRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return
+	}
+	provider, upstreamModel, err := selectProvider(input.Model)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	start := time.Now()
+	if input.Prompt == "" && input.Suffix == "" && len(input.Images) == 0 {
+		// 空 prompt 是客户端在预加载模型，直接返回
+		c.JSON(http.StatusOK, &OllamaGenerateResponse{
+			Model:      input.Model,
+			CreatedAt:  time.Now().UTC().Format(time.RFC3339Nano),
+			Done:       true,
+			DoneReason: "load",
 		})
+		return
+	}
+	run, history, err := ollamaGenerateRun(&input, provider, upstreamModel, func(p ChatProvider) ChatProvider {
+		return withThinkTags(c, input.Model, withContinuation(c, input.Model, p))
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// finish 生成结束分片，history 非空时把本轮回答追加进 context
+	finish := func(response string, reason FinishReason, usage Usage, firstToken time.Time) *OllamaGenerateResponse {
+		msg := &OllamaGenerateResponse{
+			Model:      input.Model,
+			CreatedAt:  time.Now().UTC().Format(time.RFC3339Nano),
+			Done:       true,
+			DoneReason: ollamaDoneReason(reason),
+		}
+		if history != nil {
+			msg.Context = encodeOllamaContext(append(history, OllamaMessage{Role: "assistant", Content: response}))
+		}
+		msg.OllamaMetrics.finish(usage, start, firstToken)
+		return msg
 	}
-	return msg
+
+	watch := watchClient(c, input.Model)
+	if input.Stream != nil && !*input.Stream {
+		result := chatResult{}
+		err := run(watch.ctx, watch.emit(func(ev ChatEvent) error {
+			result.add(ev)
+			return nil
+		}))
+		if watch.gone() {
+			return
+		}
+		err = watch.failure(err)
+		if err != nil {
+			ollamaUpstreamError(c, err)
+			return
+		}
+		msg := finish(result.Content, result.FinishReason, result.Usage, time.Time{})
+		msg.Response = result.Content
+		msg.Thinking = result.Reasoning
+		applyEventHeader(c, result.Header)
+		c.JSON(http.StatusOK, msg)
+		return
+	}
+
+	stream := &ndjsonStream{c: c}
+	if watch.rule.NDJSONHeartbeat {
+		watch.keepalive(func() error {
+			return stream.write(&OllamaGenerateResponse{Model: input.Model, CreatedAt: time.Now().UTC().Format(time.RFC3339Nano)})
+		})
+	}
+	var response strings.Builder
+	var firstToken time.Time
+	usage := Usage{}
+	reason := FinishReason("")
+	err = run(watch.ctx, watch.emit(func(ev ChatEvent) error {
+		applyEventHeader(c, ev.Header)
+		if ev.Usage != nil {
+			usage = *ev.Usage
+		}
+		if ev.FinishReason != "" {
+			reason = ev.FinishReason
+		}
+		if ev.Content == "" && ev.Reasoning == "" {
+			return nil
+		}
+		if firstToken.IsZero() {
+			firstToken = time.Now()
+		}
+		response.WriteString(ev.Content)
+		return stream.write(&OllamaGenerateResponse{
+			Model:     input.Model,
+			CreatedAt: time.Now().UTC().Format(time.RFC3339Nano),
+			Response:  ev.Content,
+			Thinking:  ev.Reasoning,
+		})
+	}))
+	if watch.gone() {
+		return
+	}
+	err = watch.failure(err)
+	if err != nil && !stream.started {
+		ollamaUpstreamError(c, err)
+		return
+	}
+	if err != nil {
+		log.Println("Stream error:", err)
+		_ = stream.write(&OllamaGenerateResponse{Model: input.Model, Error: err.Error(), Done: true})
+		return
+	}
+	_ = stream.write(finish(response.String(), reason, usage, firstToken))
+}
+
+// ollamaGenerateRun 构造上游调用，history 为需要写回 context 的对话历史（不含本轮回答），raw/模板/FIM 模式下为 nil；
+// wrap（<think> 标签、自动续写等按请求生效的规则）只作用于对话模式，续写不输出思考内容
+func ollamaGenerateRun(input *OllamaGenerateRequest, provider ChatProvider, model string, wrap func(ChatProvider) ChatProvider) (func(context.Context, func(ChatEvent) error) error, []OllamaMessage, error) {
+	if input.Suffix != "" {
+		req := &CompletionRequest{Model: model, Prompt: input.Prompt, Suffix: input.Suffix, Stream: true}
+		ignored := input.Options.applyCompletion(req)
+		return completionRun(provider, req, ignored), nil, nil
+	}
+	if input.Raw || input.Template != "" {
+		prompt := input.Prompt
+		if !input.Raw {
+			rendered, err := renderOllamaTemplate(input.Template, input)
+			if err != nil {
+				return nil, nil, err
+			}
+			prompt = rendered
+		}
+		req := &CompletionRequest{Model: model, Prompt: prompt, Stream: true}
+		ignored := input.Options.applyCompletion(req)
+		return completionRun(provider, req, ignored), nil, nil
+	}
+
+	history := decodeOllamaContext(input.Context)
+	history = append(history, OllamaMessage{Role: "user", Content: input.Prompt, Images: input.Images})
+	messages := history
+	if input.System != "" {
+		messages = append([]OllamaMessage{{Role: "system", Content: input.System}}, history...)
+	}
+	req := OllamaToChatRequest(&OllamaChatRequest{Model: model, Messages: messages, Think: input.Think, Format: input.Format, Options: input.Options})
+	provider = wrap(provider)
+	run := func(ctx context.Context, emit func(ChatEvent) error) error {
+		return provider.ChatStream(ctx, req, emit)
+	}
+	return run, history, nil
+}
+
+// completionRun 续写按 runCompletion 的规则选择原生接口或对话模拟，先通过响应头报告被忽略的参数
+func completionRun(provider ChatProvider, req *CompletionRequest, ignored []string) func(context.Context, func(ChatEvent) error) error {
+	return func(ctx context.Context, emit func(ChatEvent) error) error {
+		if err := reportIgnoredParams(emit, ignored); err != nil {
+			return err
+		}
+		return runCompletion(ctx, provider, req, emit)
+	}
+}
+
+// renderOllamaTemplate 按 Ollama 模板语法渲染，支持 .System .Prompt .Suffix .Response
+func renderOllamaTemplate(tmpl string, input *OllamaGenerateRequest) (string, error) {
+	t, err := template.New("prompt").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("invalid template: %w", err)
+	}
+	var sb strings.Builder
+	err = t.Execute(&sb, map[string]string{
+		"System":   input.System,
+		"Prompt":   input.Prompt,
+		"Suffix":   input.Suffix,
+		"Response": "",
+	})
+	if err != nil {
+		return "", fmt.Errorf("invalid template: %w", err)
+	}
+	return sb.String(), nil
+}
+
+// encodeOllamaContext 代理不持有 token，context 直接保存对话历史 JSON 的字节值，下次请求原样带回即可还原
+func encodeOllamaContext(history []OllamaMessage) []int {
+	data, err := json.Marshal(history)
+	if err != nil {
+		return nil
+	}
+	ctx := make([]int, len(data))
+	for i, b := range data {
+		ctx[i] = int(b)
+	}
+	return ctx
+}
+
+// decodeOllamaContext 还原 encodeOllamaContext 的结果；来自真实 Ollama 的 token 序列无法还原，忽略
+func decodeOllamaContext(ctx []int) []OllamaMessage {
+	if len(ctx) == 0 {
+		return nil
+	}
+	data := make([]byte, len(ctx))
+	for i, v := range ctx {
+		if v < 0 || v > 255 {
+			log.Println("忽略无法识别的 context")
+			return nil
+		}
+		data[i] = byte(v)
+	}
+	var history []OllamaMessage
+	if err := json.Unmarshal(data, &history); err != nil {
+		log.Println("忽略无法识别的 context:", err)
+		return nil
+	}
+	return history
+}
+
+// ollamaVersion /api/version 返回的版本号，部分客户端按版本判断是否支持 thinking、tools 等能力
+const ollamaVersion = "0.12.6"
+
+// ollamaKeepAlive Ollama 默认模型驻留时长，/api/ps 中最近使用过的模型在此时长内视为已加载
+const ollamaKeepAlive = 5 * time.Minute
+
+// loadedModels 模型名 -> 最近一次使用时间
+var loadedModels sync.Map
+
+// markModelLoaded 记录模型被使用，供 /api/ps 展示
+func markModelLoaded(model string) {
+	loadedModels.Store(ollamaModelName(model), time.Now())
+}
+
+// ollamaModelName 去掉客户端自动补上的 :latest 标签
+func ollamaModelName(name string) string {
+	return strings.TrimSuffix(name, ":latest")
+}
+
+// ollamaModelKnown 模型是否由代理提供；chatType 为 claude 时上游接受任意模型名
+func ollamaModelKnown(name string) bool {
+	name = ollamaModelName(name)
+	if XConfig == nil {
+		return false
+	}
+	models := catalogModels()
+	if XConfig.Mock {
+		models = append(models, enabledModels...)
+	}
+	for _, m := range models {
+		if m == name {
+			return true
+		}
+	}
+	return XConfig.ChatType == "claude"
+}
+
+// ollamaModelFamily 与 /api/tags 一致，取基础模型名第一段作为 family
+func ollamaModelFamily(name string) string {
+	return strings.Split(ollamaBaseModel(name), "-")[0]
+}
+
+// ollamaModelDigest 由模型名生成固定的 digest，同一模型多次查询结果一致
+func ollamaModelDigest(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:])
+}
+
+func ollamaModelDetails(name string) map[string]interface{} {
+	family := ollamaModelFamily(name)
+	return map[string]interface{}{
+		"parent_model":       "",
+		"format":             "unknown",
+		"family":             family,
+		"families":           []string{family},
+		"parameter_size":     "unknown",
+		"quantization_level": "unknown",
+	}
+}
+
+// ollamaContextLength 按基础模型名估算上下文长度，虚拟模型设置了 num_ctx 时以其为准
+func ollamaContextLength(name string) int {
+	if vm, ok := lookupVirtualModel(name); ok {
+		if v, ok := parameterFloat(vm.Parameters, "num_ctx"); ok && v > 0 {
+			return int(v)
+		}
+	}
+	lower := strings.ToLower(ollamaBaseModel(name))
+	switch {
+	case strings.Contains(lower, "gemini"):
+		return 1048576
+	case strings.Contains(lower, "gpt-4.1"):
+		return 1047576
+	case strings.Contains(lower, "claude"):
+		return 200000
+	case strings.Contains(lower, "deepseek"):
+		return 65536
+	default:
+		return 131072
+	}
+}
+
+// ollamaCapabilities 按模型名和所在后端推断能力：
+// completion 总是支持；insert 表示后端支持原生 FIM；dify 应用不接收工具定义
+func ollamaCapabilities(name string) []string {
+	name = ollamaBaseModel(name)
+	lower := strings.ToLower(name)
+	capabilities := []string{"completion"}
+	provider, _, err := resolveProvider(name)
+	if err == nil {
+		if _, ok := provider.(*DifyProvider); !ok {
+			capabilities = append(capabilities, "tools")
+		}
+		if _, ok := provider.(CompletionProvider); ok {
+			capabilities = append(capabilities, "insert")
+		}
+	}
+	for _, key := range []string{"gpt-4o", "gpt-4.1", "gpt-5", "claude", "gemini", "vision", "-vl", "llava", "grok"} {
+		if strings.Contains(lower, key) {
+			capabilities = append(capabilities, "vision")
+			break
+		}
+	}
+	for _, key := range []string{"reasoner", "thinking", "r1", "qwq", "o1", "o3", "o4", "gemini-2.5", "claude-3-7", "claude-4", "claude-sonnet-4", "claude-opus-4"} {
+		if strings.Contains(lower, key) {
+			capabilities = append(capabilities, "thinking")
+			break
+		}
+	}
+	return capabilities
+}
+
+// ollamaModelRequest /api/show /api/pull /api/delete 请求，旧版客户端使用 name 字段
+type ollamaModelRequest struct {
+	Model  string `json:"model"`
+	Name   string `json:"name"`
+	Stream *bool  `json:"stream"`
+}
+
+func bindOllamaModelRequest(c *gin.Context) (*ollamaModelRequest, bool) {
+	var input ollamaModelRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
+		return nil, false
+	}
+	if input.Model == "" {
+		input.Model = input.Name
+	}
+	if input.Model == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return nil, false
+	}
+	if !ollamaModelKnown(input.Model) {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", input.Model)})
+		return nil, false
+	}
+	return &input, true
+}
+
+// OllamaShowHandler POST /api/show
+func OllamaShowHandler(c *gin.Context) {
+	input, ok := bindOllamaModelRequest(c)
+	if !ok {
+		return
+	}
+	name := ollamaModelName(input.Model)
+	family := ollamaModelFamily(name)
+	contextLength := ollamaContextLength(name)
+	tmpl := "{{- if .System }}{{ .System }}\n\n{{ end }}{{ .Prompt }}"
+	parameters := fmt.Sprintf("num_ctx                        %d", contextLength)
+	modelfile := fmt.Sprintf("# Modelfile generated by \"ollama show\"\n# To build a new Modelfile based on this, replace FROM with:\n# FROM %s\n\nFROM %s\nTEMPLATE \"\"\"%s\"\"\"\nPARAMETER num_ctx %d\n",
+		name, name, tmpl, contextLength)
+	system := ""
+	if vm, ok := lookupVirtualModel(name); ok {
+		modelfile = vm.modelfile()
+		parameters = strings.TrimSuffix(vm.parameterLines(""), "\n")
+		system = vm.System
+		if vm.Template != "" {
+			tmpl = vm.Template
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"system":     system,
+		"modelfile":  modelfile,
+		"parameters": parameters,
+		"template":   tmpl,
+		"details":    ollamaModelDetails(name),
+		"model_info": map[string]interface{}{
+			"general.architecture":           family,
+			"general.basename":               name,
+			"general.parameter_count":        0,
+			family + ".context_length":       contextLength,
+			family + ".embedding_length":     0,
+			"tokenizer.ggml.model":           "unknown",
+			"general.quantization_version":   0,
+			"general.file_type":              0,
+			family + ".attention.head_count": 0,
+		},
+		"capabilities": ollamaCapabilities(name),
+		"modified_at":  time.Now().UTC().Format(time.RFC3339Nano),
+	})
+}
+
+// OllamaPsHandler GET /api/ps 列出 keep_alive 时长内使用过的模型
+func OllamaPsHandler(c *gin.Context) {
+	models := make([]map[string]interface{}, 0)
+	loadedModels.Range(func(key, value interface{}) bool {
+		name := key.(string)
+		expiresAt := value.(time.Time).Add(ollamaKeepAlive)
+		if time.Now().After(expiresAt) {
+			loadedModels.Delete(key)
+			return true
+		}
+		models = append(models, map[string]interface{}{
+			"name":           name,
+			"model":          name,
+			"size":           0,
+			"digest":         ollamaModelDigest(name),
+			"details":        ollamaModelDetails(name),
+			"expires_at":     expiresAt.UTC().Format(time.RFC3339Nano),
+			"size_vram":      0,
+			"context_length": ollamaContextLength(name),
+		})
+		return true
+	})
+	c.JSON(http.StatusOK, gin.H{"models": models})
+}
+
+// OllamaVersionHandler GET /api/version
+func OllamaVersionHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"version": ollamaVersion})
+}
+
+// OllamaPullHandler POST /api/pull 模型由上游提供，无需下载，按 Ollama 的进度格式直接报告完成
+func OllamaPullHandler(c *gin.Context) {
+	input, ok := bindOllamaModelRequest(c)
+	if !ok {
+		return
+	}
+	if input.Stream != nil && !*input.Stream {
+		c.JSON(http.StatusOK, gin.H{"status": "success"})
+		return
+	}
+	name := ollamaModelName(input.Model)
+	digest := "sha256:" + ollamaModelDigest(name)
+	const total = 1 << 20
+	stream := &ndjsonStream{c: c}
+	progress := []interface{}{
+		gin.H{"status": "pulling manifest"},
+		gin.H{"status": "pulling " + digest[7:19], "digest": digest, "total": total},
+		gin.H{"status": "pulling " + digest[7:19], "digest": digest, "total": total, "completed": total / 2},
+		gin.H{"status": "pulling " + digest[7:19], "digest": digest, "total": total, "completed": total},
+		gin.H{"status": "verifying sha256 digest"},
+		gin.H{"status": "writing manifest"},
+		gin.H{"status": "success"},
+	}
+	for _, p := range progress {
+		if err := stream.write(p); err != nil {
+			log.Println("Stream error:", err)
+			return
+		}
+	}
+}
+
+// OllamaDeleteHandler DELETE /api/delete 删除虚拟模型；上游模型不能删除，只确认模型存在
+func OllamaDeleteHandler(c *gin.Context) {
+	input, ok := bindOllamaModelRequest(c)
+	if !ok {
+		return
+	}
+	if _, err := deleteVirtualModel(input.Model); err != nil {
+		log.Println("保存虚拟模型失败:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+const ollamaDefaultBaseUrl = "http://127.0.0.1:11434"
+
+// OllamaProvider 转发到真实的 Ollama 服务：对话走其 OpenAI 兼容接口 /v1/chat/completions，向量化走 /api/embed
+type OllamaProvider struct {
+	Config *ProviderConfig
+}
+
+func (p *OllamaProvider) baseUrl() string {
+	if p.Config.BaseUrl == "" {
+		return ollamaDefaultBaseUrl
+	}
+	return strings.TrimSuffix(p.Config.BaseUrl, "/")
+}
+
+func (p *OllamaProvider) header() http.Header {
+	header := http.Header{}
+	if p.Config.APIKey != "" {
+		header.Set("Authorization", "Bearer "+p.Config.APIKey)
+	}
+	return header
+}
+
+// ChatStream Ollama 的 OpenAI 兼容接口不接受 top_k / repeat_penalty，stop 数量不限
+func (p *OllamaProvider) ChatStream(ctx context.Context, req *ChatCompletionRequest, emit func(ChatEvent) error) error {
+	support := openaiSampling
+	support.MaxStop = -1
+	req, ignored := adaptSampling(req, support)
+	if err := reportIgnoredParams(emit, ignored); err != nil {
+		return err
+	}
+	return openaiChatStream(ctx, p.baseUrl()+"/v1/chat/completions", p.header(), req, emit)
+}
+
+// Embed 通过 /api/embed 向量化
+func (p *OllamaProvider) Embed(ctx context.Context, req *EmbeddingRequest) (*EmbeddingResult, error) {
+	return embedInBatches(req, ollamaEmbeddingBatchSize, func(batch *EmbeddingRequest) (*EmbeddingResult, error) {
+		body := map[string]interface{}{"model": batch.Model, "input": batch.Input}
+		if batch.Dimensions > 0 {
+			body["dimensions"] = batch.Dimensions
+		}
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := postUpstream(ctx, p.baseUrl()+"/api/embed", payload, p.header())
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		out := OllamaEmbedResponse{}
+		if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+			return nil, err
+		}
+		return &EmbeddingResult{Embeddings: out.Embeddings, PromptTokens: out.PromptEvalCount}, nil
+	})
 }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// openaiStandIn /completions 与 /chat/completions 分别按各自格式返回流
+func openaiStandIn() *standIn {
+	return newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		if strings.HasSuffix(r.URL.Path, "/chat/completions") {
+			writeSSE(w, openaiContent("Hi", ""), openaiContent(" there", "stop"), openaiUsage(7, 2), "[DONE]")
+			return
+		}
+		writeSSE(w, `{"choices":[{"text":"return a"}]}`, `{"choices":[{"text":" + b","finish_reason":"stop"}]}`, openaiUsage(7, 2), "[DONE]")
+	})
+}
+
+func TestOllamaGenerate(t *testing.T) {
+	server := openaiStandIn()
+	defer server.Close()
+
+	XConfig = &Config{
+		ChatType:  "dify",
+		Providers: []ProviderConfig{{Name: "local", Type: "openai", BaseUrl: server.URL, Models: []string{"coder"}}},
+	}
+	defer func() { XConfig = nil }()
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/generate", OllamaGenerateHandler)
+	generate := func(body string) []OllamaGenerateResponse {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/api/generate", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+		var out []OllamaGenerateResponse
+		for _, line := range strings.Split(strings.TrimSpace(w.Body.String()), "\r\n") {
+			msg := OllamaGenerateResponse{}
+			_ = json.Unmarshal([]byte(line), &msg)
+			out = append(out, msg)
+		}
+		return out
+	}
+
+	// 对话模式：结束分片带回 context，下一轮带上后历史被还原
+	out := generate(`{"model":"coder","system":"be brief","prompt":"hello"}`)
+	last := out[len(out)-1]
+	if len(out) != 3 || out[0].Response != "Hi" || !last.Done || last.EvalCount != 2 || len(last.Context) == 0 {
+		t.Fatalf("chat = %+v", out)
+	}
+	ctx, _ := json.Marshal(last.Context)
+	generate(`{"model":"coder","prompt":"again","stream":false,"context":` + string(ctx) + `}`)
+	bodies, paths := server.bodies(), server.paths()
+	messages := bodies[1]["messages"].([]interface{})
+	if paths[1] != "/chat/completions" || len(messages) != 3 || messages[1].(map[string]interface{})["content"] != "Hi there" {
+		t.Errorf("history = %v", messages)
+	}
+
+	// FIM：openai 上游走原生 /completions，suffix 原样透传
+	out = generate(`{"model":"coder","prompt":"def add(a, b):\n    ","suffix":"\n\nprint(add(1, 2))","stream":false}`)
+	bodies, paths = server.bodies(), server.paths()
+	if paths[2] != "/completions" || bodies[2]["suffix"] != "\n\nprint(add(1, 2))" {
+		t.Errorf("fim request = %s %v", paths[2], bodies[2])
+	}
+	if out[0].Response != "return a + b" || len(out[0].Context) != 0 {
+		t.Errorf("fim = %+v", out[0])
+	}
+
+	// 模板模式：渲染后的提示词作为续写输入
+	generate(`{"model":"coder","prompt":"1+1","system":"math","template":"[{{.System}}] {{.Prompt}} =","stream":false}`)
+	bodies = server.bodies()
+	if bodies[3]["prompt"] != "[math] 1+1 =" {
+		t.Errorf("template prompt = %v", bodies[3]["prompt"])
+	}
+
+	// 空 prompt 只是预加载
+	out = generate(`{"model":"coder"}`)
+	if len(server.paths()) != 4 || out[0].DoneReason != "load" {
+		t.Errorf("load = %+v", out)
+	}
+
+	// raw 续写接口不接受的采样参数通过响应头报告
+	w := httptest.NewRecorder()
+	body := `{"model":"coder","prompt":"1+1=","raw":true,"stream":false,"options":{"temperature":0.1,"top_k":20,"seed":3,"repeat_penalty":1.1}}`
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/generate", strings.NewReader(body)))
+	if ignored := w.Header().Get(ignoredParamsHeader); ignored != "top_k, seed, repetition_penalty" {
+		t.Errorf("raw ignored = %q, body = %s", ignored, w.Body.String())
+	}
+}
+
+func TestOllamaModelManagement(t *testing.T) {
+	XConfig = &Config{
+		ChatType:   "dify",
+		DifyAppMap: map[string]string{"claude-4-sonnet": "app"},
+		Providers:  []ProviderConfig{{Name: "local", Type: "openai", Models: []string{"coder"}}},
+	}
+	defer func() { XConfig = nil }()
+	loadedModels = sync.Map{}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/show", OllamaShowHandler)
+	router.GET("/api/ps", OllamaPsHandler)
+	router.GET("/api/version", OllamaVersionHandler)
+	router.POST("/api/pull", OllamaPullHandler)
+	router.DELETE("/api/delete", OllamaDeleteHandler)
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return w
+	}
+
+	show := struct {
+		Capabilities []string               `json:"capabilities"`
+		ModelInfo    map[string]interface{} `json:"model_info"`
+		Modelfile    string                 `json:"modelfile"`
+	}{}
+	w := call("POST", "/api/show", `{"model":"claude-4-sonnet:latest"}`)
+	_ = json.Unmarshal(w.Body.Bytes(), &show)
+	if w.Code != http.StatusOK || show.ModelInfo["claude.context_length"] != float64(200000) || !strings.Contains(show.Modelfile, "FROM claude-4-sonnet") {
+		t.Fatalf("show = %d %s", w.Code, w.Body.String())
+	}
+	if strings.Join(show.Capabilities, ",") != "completion,vision,thinking" {
+		t.Errorf("dify capabilities = %v", show.Capabilities)
+	}
+	_ = json.Unmarshal(call("POST", "/api/show", `{"name":"coder"}`).Body.Bytes(), &show)
+	if strings.Join(show.Capabilities, ",") != "completion,tools,insert" {
+		t.Errorf("openai capabilities = %v", show.Capabilities)
+	}
+	if w := call("POST", "/api/show", `{"model":"missing"}`); w.Code != http.StatusNotFound {
+		t.Errorf("missing show = %d", w.Code)
+	}
+
+	// 查询模型信息不算加载，真正选择后端后才出现在 /api/ps
+	if w := call("GET", "/api/ps", ""); !strings.Contains(w.Body.String(), `"models":[]`) {
+		t.Errorf("ps before use = %s", w.Body.String())
+	}
+	_, _, _ = selectProvider("coder")
+	if w := call("GET", "/api/ps", ""); !strings.Contains(w.Body.String(), `"name":"coder"`) {
+		t.Errorf("ps after use = %s", w.Body.String())
+	}
+
+	if w := call("GET", "/api/version", ""); w.Body.String() != `{"version":"`+ollamaVersion+`"}` {
+		t.Errorf("version = %s", w.Body.String())
+	}
+
+	w = call("POST", "/api/pull", `{"model":"coder"}`)
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\r\n")
+	if !strings.Contains(lines[0], "pulling manifest") || lines[len(lines)-1] != `{"status":"success"}` {
+		t.Errorf("pull = %q", lines)
+	}
+	if w := call("POST", "/api/pull", `{"model":"coder","stream":false}`); w.Body.String() != `{"status":"success"}` {
+		t.Errorf("pull without stream = %s", w.Body.String())
+	}
+
+	if w := call("DELETE", "/api/delete", `{"model":"coder"}`); w.Code != http.StatusOK {
+		t.Errorf("delete = %d", w.Code)
+	}
+	if w := call("DELETE", "/api/delete", `{"model":"missing"}`); w.Code != http.StatusNotFound {
+		t.Errorf("delete missing = %d", w.Code)
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 透传代理：代理没有单独实现的接口（/v1/embeddings、/v1/files、/user/balance 等）按原方法、原路径转发到上游，
+// 替换凭据，请求体与响应体边读边转发不整体缓冲，只在 JSON 带 model 字段时按 proxyMapping 改写模型名。
+//
+//	TLS 服务（isTls）：未注册的路径转发到默认上游（baseUrl / apiKey）
+//	主服务：/proxy/openai/{path} 转发到默认上游，/proxy/{provider}/{path} 转发到 providers 中同名的后端
+
+// PassthroughConfig 透传代理配置，未配置时不开启
+type PassthroughConfig struct {
+	Target string   `json:"target"` // 上游根地址，请求路径原样拼接在后面；默认为 baseUrl 去掉末尾的版本号（/v1、/v1beta）
+	Allow  []string `json:"allow"`  // 允许的路径前缀，为空时全部允许
+	Deny   []string `json:"deny"`   // 拒绝的路径前缀，优先于 allow
+}
+
+// allowed deny 优先，allow 为空时全部允许
+func (p *PassthroughConfig) allowed(path string) bool {
+	for _, prefix := range p.Deny {
+		if strings.HasPrefix(path, prefix) {
+			return false
+		}
+	}
+	if len(p.Allow) == 0 {
+		return true
+	}
+	for _, prefix := range p.Allow {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// passthroughUpstream 透传的目标；auth 为替换客户端凭据的上游凭据，为空时保留客户端的凭据
+type passthroughUpstream struct {
+	config *PassthroughConfig
+	target string
+	auth   http.Header
+}
+
+var apiVersionSuffix = regexp.MustCompile(`/v\d+(alpha\d*|beta\d*)?$`)
+
+func passthroughTarget(config *PassthroughConfig, baseUrl string) string {
+	if config.Target != "" {
+		return strings.TrimSuffix(config.Target, "/")
+	}
+	return apiVersionSuffix.ReplaceAllString(strings.TrimSuffix(baseUrl, "/"), "")
+}
+
+// defaultPassthrough 默认上游，与 ProxyChatHandle 相同使用 baseUrl 与 apiKey
+func defaultPassthrough() *passthroughUpstream {
+	if XConfig.Passthrough == nil {
+		return nil
+	}
+	upstream := &passthroughUpstream{
+		config: XConfig.Passthrough,
+		target: passthroughTarget(XConfig.Passthrough, XConfig.BaseUrl),
+	}
+	if XConfig.APIKey != "" {
+		upstream.auth = http.Header{}
+		upstream.auth.Set("Authorization", "Bearer "+XConfig.APIKey)
+	}
+	return upstream
+}
+
+// providerPassthrough providers 中的后端，凭据与该后端的聊天请求相同；bedrock、jetbrains 需要签名或换取令牌，不支持透传
+func providerPassthrough(name string) *passthroughUpstream {
+	for i := range XConfig.Providers {
+		cfg := &XConfig.Providers[i]
+		if cfg.Name != name || cfg.Passthrough == nil {
+			continue
+		}
+		upstream := &passthroughUpstream{config: cfg.Passthrough}
+		switch cfg.Type {
+		case "openai":
+			upstream.auth = http.Header{}
+			upstream.auth.Set("Authorization", "Bearer "+cfg.APIKey)
+			upstream.target = passthroughTarget(cfg.Passthrough, cfg.BaseUrl)
+		case "azure":
+			upstream.auth = (&AzureProvider{Config: cfg}).header()
+			upstream.target = passthroughTarget(cfg.Passthrough, cfg.BaseUrl)
+		case "gemini":
+			upstream.auth = (&GeminiProvider{Config: cfg}).header()
+			upstream.target = passthroughTarget(cfg.Passthrough, (&GeminiProvider{Config: cfg}).baseUrl())
+		case "ollama":
+			upstream.auth = (&OllamaProvider{Config: cfg}).header()
+			upstream.target = passthroughTarget(cfg.Passthrough, (&OllamaProvider{Config: cfg}).baseUrl())
+		default:
+			log.Printf("后端 %s（%s）不支持透传\n", cfg.Name, cfg.Type)
+			return nil
+		}
+		if cfg.APIKey == "" {
+			upstream.auth = nil
+		}
+		return upstream
+	}
+	return nil
+}
+
+// PassthroughHandler 主服务的 NoRoute：/proxy/{name}/{path} 透传，其余路径 404
+func PassthroughHandler(c *gin.Context) {
+	rest, ok := strings.CutPrefix(c.Request.URL.Path, "/proxy/")
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "404 page not found"})
+		return
+	}
+	name, path, _ := strings.Cut(rest, "/")
+	var upstream *passthroughUpstream
+	if name == "openai" {
+		upstream = defaultPassthrough()
+	} else {
+		upstream = providerPassthrough(name)
+	}
+	if upstream == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "passthrough is not enabled for " + name})
+		return
+	}
+	passthrough(c, upstream, "/"+path)
+}
+
+// tlsPassthroughHandler TLS 服务的 NoRoute：路径原样透传到默认上游
+func tlsPassthroughHandler(c *gin.Context) {
+	upstream := defaultPassthrough()
+	if upstream == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "404 page not found"})
+		return
+	}
+	passthrough(c, upstream, c.Request.URL.Path)
+}
+
+// cleanPassthroughPath 规范化已解码的请求路径，allow / deny 与上游地址都使用规范化后的路径；
+// 含 .. 段（包括编码的 %2e%2e）或编码的 /（%2F，解码后无法与路径分隔符区分）时拒绝，避免绕过 deny 规则。末尾的 / 保留
+func cleanPassthroughPath(p, escaped string) (string, bool) {
+	if strings.Contains(strings.ToLower(escaped), "%2f") {
+		return "", false
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." {
+			return "", false
+		}
+	}
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned, true
+}
+
+// passthroughSkipHeaders 不转发的请求头与响应头：逐跳头部由 HTTP 客户端与 gin 处理；
+// Accept-Encoding 交给 HTTP 客户端处理，响应才能按需改写 model
+var passthroughSkipHeaders = map[string]bool{
+	"Host":                true,
+	"Connection":          true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Proxy-Connection":    true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+	"Content-Length":      true,
+	"Accept-Encoding":     true,
+}
+
+// passthroughAuthHeaders 使用上游凭据时移除的客户端凭据
+var passthroughAuthHeaders = []string{"Authorization", "Api-Key", "X-Api-Key", "X-Goog-Api-Key"}
+
+// rewritableContentType 带 model 字段的 JSON：普通 JSON、SSE 与 NDJSON
+func rewritableContentType(contentType string) bool {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "application/json", strings.HasSuffix(mediaType, "+json"):
+		return true
+	case mediaType == "text/event-stream", mediaType == "application/x-ndjson":
+		return true
+	}
+	return false
+}
+
+// passthroughAlias 请求中被 proxyMapping 替换的模型名，响应中的 model 改回该名称
+type passthroughAlias struct {
+	mu    sync.Mutex
+	model string
+}
+
+func (a *passthroughAlias) set(model string) {
+	a.mu.Lock()
+	a.model = model
+	a.mu.Unlock()
+}
+
+func (a *passthroughAlias) get() string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.model
+}
+
+func passthrough(c *gin.Context, upstream *passthroughUpstream, path string) {
+	path, ok := cleanPassthroughPath(path, c.Request.URL.EscapedPath())
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid path: " + c.Request.URL.EscapedPath()})
+		return
+	}
+	if !upstream.config.allowed(path) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "path is not allowed: " + path})
+		return
+	}
+	// 路径已解码，重新编码后拼接，%3F 等字符不会变成查询参数
+	targetURL := upstream.target + (&url.URL{Path: path}).EscapedPath()
+	if c.Request.URL.RawQuery != "" {
+		targetURL += "?" + c.Request.URL.RawQuery
+	}
+
+	// 客户端断开或按 timeouts 规则超时时上游请求随之取消
+	watch := watchClient(c, "")
+	defer watch.gone()
+
+	// 请求体边读边转发；JSON 请求体中的 model 按 proxyMapping 替换，长度因此未知
+	var body io.Reader = c.Request.Body
+	alias := &passthroughAlias{}
+	rewriteRequest := hasProxyAliases() && rewritableContentType(c.ContentType())
+	if rewriteRequest {
+		pr, pw := io.Pipe()
+		go func() {
+			rewriter := newModelRewriter(pw, func(model string) string {
+				res := resolveProxyAlias(model)
+				if !res.matched() {
+					return model
+				}
+				alias.set(model)
+				if XConfig.Debug {
+					log.Printf("模型替换: %s -> %s\n", model, res.Target)
+				}
+				return res.Target
+			})
+			_, err := io.Copy(rewriter, c.Request.Body)
+			pw.CloseWithError(err)
+		}()
+		body = pr
+	}
+	req, err := http.NewRequestWithContext(watch.ctx, c.Request.Method, targetURL, body)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request"})
+		return
+	}
+	if !rewriteRequest {
+		req.ContentLength = c.Request.ContentLength
+		if req.ContentLength == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	for key, values := range c.Request.Header {
+		if passthroughSkipHeaders[key] {
+			continue
+		}
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if upstream.auth != nil {
+		for _, key := range passthroughAuthHeaders {
+			req.Header.Del(key)
+		}
+		for key, values := range upstream.auth {
+			req.Header[key] = values
+		}
+	}
+	if XConfig.Debug {
+		log.Printf("透传请求到: %s %s\n", c.Request.Method, targetURL)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		log.Printf("透传请求失败: %v\n", watch.failure(err))
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Request failed"})
+		return
+	}
+	defer resp.Body.Close()
+
+	// 请求中的模型被替换过时把响应中的 model 改回客户端请求的名称；上游未解压的响应原样转发
+	var out io.Writer = c.Writer
+	rewriteResponse := rewriteRequest && alias.get() != "" &&
+		resp.Header.Get("Content-Encoding") == "" && rewritableContentType(resp.Header.Get("Content-Type"))
+	if rewriteResponse {
+		out = newModelRewriter(c.Writer, func(string) string { return alias.get() })
+	}
+	for key, values := range resp.Header {
+		if passthroughSkipHeaders[key] && (key != "Content-Length" || rewriteResponse) {
+			continue
+		}
+		for _, value := range values {
+			c.Writer.Header().Add(key, value)
+		}
+	}
+	c.Status(resp.StatusCode)
+	c.Writer.WriteHeaderNow()
+	c.Writer.Flush()
+
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := resp.Body.Read(buffer)
+		if n > 0 {
+			chunk := buffer[:n]
+			writeErr := watch.write(func() error {
+				if _, err := out.Write(chunk); err != nil {
+					return err
+				}
+				c.Writer.Flush()
+				return nil
+			})
+			if writeErr != nil {
+				log.Printf("透传写入响应错误: %v\n", writeErr)
+				return
+			}
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Printf("透传读取响应错误 %s: %v\n", targetURL, watch.failure(err))
+			return
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPassthrough(t *testing.T) {
+	upstream := newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path != "/v1/embeddings" {
+			fmt.Fprint(w, `{"is_available":true,"balance_infos":[]}`)
+			return
+		}
+		var body map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		fmt.Fprintf(w, `{"object":"list","model":%q,"data":[{"embedding":[0.1]}]}`, body["model"])
+	})
+	defer upstream.Close()
+	XConfig = &Config{
+		BaseUrl:      upstream.URL + "/v1",
+		APIKey:       "sk-upstream",
+		ProxyMapping: map[string]string{"text-embedding-3-small": "bge-m3"},
+		Passthrough:  &PassthroughConfig{Deny: []string{"/v1/files"}},
+		Providers: []ProviderConfig{{Name: "google", Type: "gemini", BaseUrl: upstream.URL + "/v1beta", APIKey: "g-key",
+			Passthrough: &PassthroughConfig{Allow: []string{"/v1beta/models"}}}},
+	}
+	defer func() { XConfig = nil }()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.NoRoute(PassthroughHandler)
+	tls := gin.New()
+	tls.NoRoute(tlsPassthroughHandler)
+	last := func() standInRequest {
+		received := upstream.received()
+		if len(received) == 0 {
+			return standInRequest{}
+		}
+		return received[len(received)-1]
+	}
+
+	// 模型名在请求中替换，在响应中改回；客户端凭据替换为上游凭据
+	for _, tc := range []struct {
+		engine *gin.Engine
+		path   string
+	}{{router, "/proxy/openai/v1/embeddings"}, {tls, "/v1/embeddings"}} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", tc.path, strings.NewReader(`{"model":"text-embedding-3-small","input":["hi"]}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer sk-client")
+		tc.engine.ServeHTTP(w, req)
+		got := last()
+		if got.Method != "POST" || got.URI != "/v1/embeddings" || got.Header.Get("Authorization") != "Bearer sk-upstream" ||
+			!strings.Contains(string(got.Body), `"model":"bge-m3"`) {
+			t.Fatalf("%s: upstream received %+v", tc.path, got)
+		}
+		if w.Code != 200 || !strings.Contains(w.Body.String(), `"model":"text-embedding-3-small"`) {
+			t.Fatalf("%s: %d %s", tc.path, w.Code, w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/proxy/openai/user/balance?currency=CNY", nil))
+	if got := last(); w.Code != 200 || got.Method != "GET" || got.URI != "/user/balance?currency=CNY" || !strings.Contains(w.Body.String(), "is_available") {
+		t.Fatalf("balance: %d %s, upstream received %+v", w.Code, w.Body.String(), got)
+	}
+
+	// 路径规范化后再匹配 deny：.. 与编码的 .. / 都不能绕过
+	upstream.reset()
+	for _, path := range []string{"/proxy/openai/v1/../v1/files", "/proxy/openai/v1/%2e%2e/v1/files", "/proxy/openai/v1%2Ffiles", "/proxy/openai/v1//files"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusBadRequest && w.Code != http.StatusForbidden {
+			t.Fatalf("%s: %d %s", path, w.Code, w.Body.String())
+		}
+	}
+	w = httptest.NewRecorder()
+	tls.ServeHTTP(w, httptest.NewRequest("GET", "/v1/models/../files", nil))
+	if w.Code != http.StatusBadRequest || len(upstream.received()) != 0 {
+		t.Fatalf("tls traversal: %d, upstream received %+v", w.Code, upstream.received())
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/proxy/openai/v1/./models/a%3Fb", nil))
+	if got := last(); w.Code != 200 || got.URI != "/v1/models/a%3Fb" {
+		t.Fatalf("clean: %d, upstream received %+v", w.Code, got)
+	}
+
+	// 后端凭据按类型设置
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/proxy/google/v1beta/models", nil))
+	if got := last(); w.Code != 200 || got.URI != "/v1beta/models" || got.Header.Get("x-goog-api-key") != "g-key" || got.Header.Get("Authorization") != "" {
+		t.Fatalf("gemini: %d, upstream received %+v", w.Code, got)
+	}
+
+	// allow / deny
+	for _, path := range []string{"/proxy/openai/v1/files", "/proxy/google/v1beta/cachedContents"} {
+		upstream.reset()
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusForbidden || len(upstream.received()) != 0 {
+			t.Fatalf("%s: %d, upstream received %+v", path, w.Code, upstream.received())
+		}
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unknown path: %d", w.Code)
+	}
+}
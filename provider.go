@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"ollamaproxy/sse"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ChatEvent 上游流式输出的中立事件，各入站协议（Ollama/OpenAI/Gemini 等）都从它渲染
+type ChatEvent struct {
+	Content   string
+	Reasoning string
+	// ToolCalls 为完整的工具调用，参数已拼接完毕
+	ToolCalls    []ToolCall
+	FinishReason FinishReason
+	Usage        *Usage
+	// ContentFilter/PromptFilterResults 为 Azure 等上游返回的内容过滤标注，原样带回给 OpenAI 格式的客户端
+	ContentFilter       *ContentFilterResults
+	PromptFilterResults []PromptFilterResult
+	// Header 需要带给客户端的响应头（如 JetBrains 额度），只在响应头写出前生效
+	Header http.Header
+}
+
+// hasOutput 是否包含需要渲染给客户端的内容
+func (ev ChatEvent) hasOutput() bool {
+	return ev.Content != "" || ev.Reasoning != "" || len(ev.ToolCalls) > 0 || ev.FinishReason != ""
+}
+
+// hasPayload 除响应头外是否还有需要写给客户端的数据：内容、用量或内容过滤标注
+func (ev ChatEvent) hasPayload() bool {
+	return ev.hasOutput() || ev.Usage != nil || ev.ContentFilter != nil || len(ev.PromptFilterResults) > 0
+}
+
+// empty 没有任何需要向下游传递的信息，包装 emit 的装饰器据此丢弃事件；响应头也要传递
+func (ev ChatEvent) empty() bool {
+	return !ev.hasPayload() && len(ev.Header) == 0
+}
+
+// ChatProvider 上游后端：把中立请求转换为上游协议，并把上游输出逐条回调为 ChatEvent
+type ChatProvider interface {
+	ChatStream(ctx context.Context, req *ChatCompletionRequest, emit func(ChatEvent) error) error
+}
+
+// CompletionRequest 纯文本续写请求，Suffix 非空时为 FIM（fill-in-the-middle）
+type CompletionRequest struct {
+	Model       string   `json:"model"`
+	Prompt      string   `json:"prompt"`
+	Suffix      string   `json:"suffix,omitempty"`
+	MaxTokens   int      `json:"max_tokens,omitempty"`
+	Temperature *float32 `json:"temperature,omitempty"`
+	TopP        float32  `json:"top_p,omitempty"`
+	Stop        []string `json:"stop,omitempty"`
+	Stream      bool     `json:"stream,omitempty"`
+}
+
+// CompletionProvider 支持原生文本续写/FIM 的后端，未实现时由对话接口模拟
+type CompletionProvider interface {
+	Complete(ctx context.Context, req *CompletionRequest, emit func(ChatEvent) error) error
+}
+
+// EmbeddingRequest 向量化请求，Dimensions 为 0 时使用模型默认维度
+type EmbeddingRequest struct {
+	Model      string
+	Input      []string
+	Dimensions int
+}
+
+// EmbeddingResult 与 Input 顺序一致的向量
+type EmbeddingResult struct {
+	Embeddings   [][]float64
+	PromptTokens int
+}
+
+// EmbeddingProvider 支持向量化的后端
+type EmbeddingProvider interface {
+	Embed(ctx context.Context, req *EmbeddingRequest) (*EmbeddingResult, error)
+}
+
+// UpstreamError 上游返回非 200 状态码
+type UpstreamError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *UpstreamError) Error() string {
+	return fmt.Sprintf("upstream status %d: %s", e.StatusCode, e.Body)
+}
+
+// providerClient 流式请求不设置总超时，避免长回答被截断；超时按 timeouts 规则由 clientWatch 控制
+var providerClient = &http.Client{Transport: newUpstreamTransport(http.DefaultTransport.(*http.Transport).Clone())}
+
+// selectProvider 按模型选择后端，返回后端实现和上游使用的模型名，并记录模型已被使用
+func selectProvider(model string) (ChatProvider, string, error) {
+	if XConfig == nil {
+		return nil, "", fmt.Errorf("XConfig is nil")
+	}
+	markModelLoaded(model)
+	return resolveProvider(model)
+}
+
+// resolveProvider 与 selectProvider 相同但没有副作用，用于查询模型信息
+func resolveProvider(model string) (ChatProvider, string, error) {
+	return resolveProviderDepth(model, 0)
+}
+
+// resolveProviderDepth 虚拟模型沿 FROM 逐层包装，depth 防止手工修改的模型文件出现循环
+func resolveProviderDepth(model string, depth int) (ChatProvider, string, error) {
+	if XConfig == nil {
+		return nil, "", fmt.Errorf("XConfig is nil")
+	}
+	if vm, ok := lookupVirtualModel(model); ok {
+		if depth >= maxVirtualModelDepth {
+			return nil, "", fmt.Errorf("virtual model %s: FROM chain too deep", model)
+		}
+		inner, upstreamModel, err := resolveProviderDepth(vm.From, depth+1)
+		if err != nil {
+			return nil, "", err
+		}
+		return wrapVirtualProvider(vm, inner), upstreamModel, nil
+	}
+	res := resolveModelAlias(model)
+	cfg, err := modelProviderConfig(&res)
+	if err != nil {
+		return nil, "", err
+	}
+	model = res.Target
+	if cfg != nil {
+		provider, err := newProvider(cfg)
+		return provider, model, err
+	}
+	switch XConfig.ChatType {
+	case "dify":
+		return &DifyProvider{}, model, nil
+	case "claude":
+		return &ClaudeProvider{URL: XConfig.APIURL, APIKey: XConfig.APIKey}, model, nil
+	default:
+		return nil, "", fmt.Errorf("unsupported chatType: %s", XConfig.ChatType)
+	}
+}
+
+// modelProviderConfig 映射后的模型所在的后端，规则指定了 provider 时直接使用；不属于任何后端时返回 nil，按 chatType 处理
+func modelProviderConfig(res *aliasResolution) (*ProviderConfig, error) {
+	for i := range XConfig.Providers {
+		cfg := &XConfig.Providers[i]
+		if res.Provider != "" {
+			if cfg.Name == res.Provider {
+				return cfg, nil
+			}
+			continue
+		}
+		for _, m := range providerModels(cfg) {
+			if m == res.Target {
+				return cfg, nil
+			}
+		}
+	}
+	if res.Provider != "" {
+		return nil, fmt.Errorf("model %s: provider %s not found", res.Model, res.Provider)
+	}
+	return nil, nil
+}
+
+// newProvider 根据 providers 配置创建后端
+func newProvider(cfg *ProviderConfig) (ChatProvider, error) {
+	switch cfg.Type {
+	case "gemini":
+		return &GeminiProvider{Config: cfg}, nil
+	case "openai":
+		return &OpenAIProvider{Config: cfg}, nil
+	case "azure":
+		return &AzureProvider{Config: cfg}, nil
+	case "bedrock":
+		return &BedrockProvider{Config: cfg}, nil
+	case "jetbrains":
+		return &JetBrainsProvider{Config: cfg}, nil
+	case "ollama":
+		return &OllamaProvider{Config: cfg}, nil
+	default:
+		return nil, fmt.Errorf("unsupported provider type: %s (%s)", cfg.Type, cfg.Name)
+	}
+}
+
+// catalogModels 代理对外提供的模型列表
+func catalogModels() []string {
+	models := make([]string, 0)
+	if XConfig == nil {
+		return models
+	}
+	seen := map[string]bool{}
+	add := func(m map[string]string) {
+		for key := range m {
+			if !seen[key] {
+				seen[key] = true
+				models = append(models, key)
+			}
+		}
+	}
+	add(XConfig.DifyAppMap)
+	add(XConfig.DifyAppMapProd)
+	add(XConfig.Mapping)
+	for i := range XConfig.Providers {
+		for _, m := range providerModels(&XConfig.Providers[i]) {
+			if !seen[m] {
+				seen[m] = true
+				models = append(models, m)
+			}
+		}
+	}
+	for _, m := range virtualModelNames() {
+		if !seen[m] {
+			seen[m] = true
+			models = append(models, m)
+		}
+	}
+	return models
+}
+
+// postUpstream 发送 JSON 请求，非 200 时读取响应体并返回 UpstreamError
+func postUpstream(ctx context.Context, url string, payload []byte, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := providerClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &UpstreamError{StatusCode: resp.StatusCode, Body: string(body)}
+	}
+	return resp, nil
+}
+
+// readSSEData 读取上游 SSE，只回调 data 字段内容，不关心事件名的上游使用
+func readSSEData(body io.Reader, fn func(data string) error) error {
+	return readSSE(body, func(ev sse.Event) error {
+		data := strings.TrimSpace(ev.Data)
+		if data == "" {
+			return nil
+		}
+		return fn(data)
+	})
+}
+
+// readSSE 逐条读取上游 SSE 事件，直到流结束
+func readSSE(body io.Reader, fn func(ev sse.Event) error) error {
+	decoder := sse.NewDecoder(body)
+	for {
+		ev, err := decoder.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+}
+
+// messageText 提取消息文本，兼容 string、[]ChatMessagePart、map[string]string 以及 JSON 反序列化后的 []interface{}
+func messageText(content interface{}) string {
+	switch v := content.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case map[string]string:
+		return v["text"]
+	case []ChatMessagePart:
+		var sb strings.Builder
+		for _, part := range v {
+			sb.WriteString(part.Text)
+		}
+		return sb.String()
+	case []interface{}:
+		var sb strings.Builder
+		for _, item := range v {
+			if part, ok := item.(map[string]interface{}); ok {
+				if text, ok := part["text"].(string); ok {
+					sb.WriteString(text)
+				}
+			}
+		}
+		return sb.String()
+	default:
+		return ""
+	}
+}
+
+// messageParts 把消息内容统一为 []ChatMessagePart，图片等非文本分片会被保留
+func messageParts(content interface{}) []ChatMessagePart {
+	switch v := content.(type) {
+	case nil:
+		return nil
+	case string:
+		return []ChatMessagePart{{Type: "text", Text: v}}
+	case []ChatMessagePart:
+		return v
+	case []interface{}:
+		var parts []ChatMessagePart
+		data, _ := json.Marshal(v)
+		if err := json.Unmarshal(data, &parts); err != nil {
+			return []ChatMessagePart{{Type: "text", Text: messageText(v)}}
+		}
+		return parts
+	default:
+		return []ChatMessagePart{{Type: "text", Text: messageText(v)}}
+	}
+}
+
+// chatResult 非流式场景下把事件聚合为一条完整回复
+type chatResult struct {
+	Content             string
+	Reasoning           string
+	ToolCalls           []ToolCall
+	FinishReason        FinishReason
+	Usage               Usage
+	ContentFilter       *ContentFilterResults
+	PromptFilterResults []PromptFilterResult
+	Header              http.Header
+}
+
+func (r *chatResult) add(ev ChatEvent) {
+	r.Content += ev.Content
+	r.Reasoning += ev.Reasoning
+	r.ToolCalls = append(r.ToolCalls, ev.ToolCalls...)
+	if ev.FinishReason != "" {
+		r.FinishReason = ev.FinishReason
+	}
+	if ev.Usage != nil {
+		r.Usage = *ev.Usage
+	}
+	if ev.ContentFilter != nil {
+		r.ContentFilter = ev.ContentFilter
+	}
+	r.PromptFilterResults = append(r.PromptFilterResults, ev.PromptFilterResults...)
+	if len(ev.Header) > 0 && r.Header == nil {
+		r.Header = http.Header{}
+	}
+	for key, values := range ev.Header {
+		r.Header[key] = values
+	}
+}
+
+// applyEventHeader 把事件携带的响应头写入 gin 响应，响应头已写出后不再生效
+func applyEventHeader(c *gin.Context, header http.Header) {
+	for key, values := range header {
+		for i, value := range values {
+			if i == 0 {
+				c.Writer.Header().Set(key, value)
+			} else {
+				c.Writer.Header().Add(key, value)
+			}
+		}
+	}
+}
@@ -16,13 +16,12 @@ import (
 var httpClient *http.Client
 
 func init() {
+	// 不设置总超时，长时间思考的流式回答会被截断；超时按 timeouts 规则控制
 	httpClient = &http.Client{
-		Timeout: 60 * time.Second,
-		Transport: &http.Transport{
+		Transport: newUpstreamTransport(&http.Transport{
 			TLSHandshakeTimeout:   10 * time.Second,
-			ResponseHeaderTimeout: 20 * time.Second,
 			ExpectContinueTimeout: 1 * time.Second,
-		},
+		}),
 	}
 }
 
@@ -36,7 +35,12 @@ func ProxyChatHandle(c *gin.Context) {
 		log.Println("Body", data)
 	}
 	if model, ok := data["model"].(string); ok {
-		if originalModel, ok := XConfig.ProxyMapping[model]; ok {
+		// 响应默认原样转发；模型被替换时把响应中的 model 改回客户端请求的名称
+		var out io.Writer = c.Writer
+		alias := resolveProxyAlias(model)
+		originalModel, rewrite := alias.Target, alias.matched()
+		if rewrite {
+			out = newModelRewriter(c.Writer, modelAlias(model))
 			data["model"] = originalModel
 			if XConfig.Debug {
 				log.Printf("模型替换: %s -> %s\n", model, originalModel)
@@ -53,8 +57,10 @@ func ProxyChatHandle(c *gin.Context) {
 		// 构建目标URL
 		targetURL := fmt.Sprintf("%s/chat/completions", XConfig.BaseUrl)
 
-		// 创建新的请求
-		req, err := http.NewRequest("POST", targetURL, bytes.NewReader(modifiedBody))
+		// 创建新的请求，绑定客户端连接：客户端断开或按 timeouts 规则超时时上游请求随之取消
+		watch := watchClient(c, model)
+		defer watch.gone()
+		req, err := http.NewRequestWithContext(watch.ctx, "POST", targetURL, bytes.NewReader(modifiedBody))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request"})
 			return
@@ -63,8 +69,9 @@ func ProxyChatHandle(c *gin.Context) {
 		// 复制请求头，排除一些可能有问题的头部
 		for key, values := range c.Request.Header {
 			// 跳过这些头部，因为它们由HTTP客户端自动处理或可能引起问题
+			// Accept-Encoding 交给 HTTP 客户端处理，否则响应是压缩的原始字节，而 Content-Encoding 不会转发给客户端
 			switch key {
-			case "Host", "Content-Length", "Transfer-Encoding", "Connection":
+			case "Host", "Content-Length", "Transfer-Encoding", "Connection", "Accept-Encoding":
 				continue
 			}
 			for _, value := range values {
@@ -89,7 +96,7 @@ func ProxyChatHandle(c *gin.Context) {
 		// 发送请求
 		resp, err := httpClient.Do(req)
 		if err != nil {
-			log.Printf("请求失败: %v\n", err)
+			log.Printf("请求失败: %v\n", watch.failure(err))
 			c.JSON(http.StatusBadGateway, gin.H{"error": "Request failed"})
 			return
 		}
@@ -107,6 +114,11 @@ func ProxyChatHandle(c *gin.Context) {
 			switch key {
 			case "Transfer-Encoding", "Content-Encoding", "Connection":
 				continue
+			case "Content-Length":
+				// 改写 model 后长度会变化
+				if rewrite {
+					continue
+				}
 			}
 			for _, value := range values {
 				c.Header(key, value)
@@ -123,6 +135,14 @@ func ProxyChatHandle(c *gin.Context) {
 			}
 			c.Writer.Flush()
 
+			// 原样转发的字节可能停在一条事件中间，只在事件边界处插入保活
+			boundary := true
+			watch.keepalive(func() error {
+				if !boundary {
+					return nil
+				}
+				return PingData(c)
+			})
 			// 使用更大的缓冲区提高性能
 			buffer := make([]byte, 4096)
 			for {
@@ -131,18 +151,25 @@ func ProxyChatHandle(c *gin.Context) {
 					if XConfig.Debug {
 						log.Printf("流式响应: %d 字节\n", n)
 					}
-					_, writeErr := c.Writer.Write(buffer[:n])
+					chunk := buffer[:n]
+					writeErr := watch.write(func() error {
+						if _, err := out.Write(chunk); err != nil {
+							return err
+						}
+						boundary = bytes.HasSuffix(chunk, []byte("\n\n")) || bytes.HasSuffix(chunk, []byte("\r\n\r\n"))
+						c.Writer.Flush()
+						return nil
+					})
 					if writeErr != nil {
 						log.Printf("写入响应错误: %v\n", writeErr)
 						break
 					}
-					c.Writer.Flush()
 				}
 				if err == io.EOF {
 					break
 				}
 				if err != nil {
-					log.Printf("流式读取错误: %v\n", err)
+					log.Printf("流式读取错误: %v\n", watch.failure(err))
 					break
 				}
 			}
@@ -151,7 +178,7 @@ func ProxyChatHandle(c *gin.Context) {
 			if XConfig.Debug {
 				fmt.Println("处理非流式响应")
 			}
-			_, copyErr := io.Copy(c.Writer, resp.Body)
+			_, copyErr := io.Copy(out, resp.Body)
 			if copyErr != nil {
 				log.Printf("复制响应体错误: %v\n", copyErr)
 			}
@@ -189,6 +216,8 @@ func TlsServer() {
 	})
 	r.POST("/chat/completions", ProxyChatHandle)
 	r.GET("/models", GetGptModels)
+	// 其余路径透传到上游
+	r.NoRoute(tlsPassthroughHandler)
 
 	// 启动 HTTPS 服务（指定证书和密钥文件）
 	// cert.pem: 你的 SSL 证书
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strings"
+)
+
+// 思考过程（reasoning）在中立层只有两样东西：
+//   - 请求侧：ChatCompletionRequest.ReasoningEffort（none/minimal/low/medium/high）和 ThinkingBudget（token 数），
+//     各入口（OpenAI reasoning_effort、Ollama think、Anthropic thinking.budget_tokens）都转成这两个字段，
+//     各上游再按自己的协议换算（Anthropic/Bedrock thinking、Gemini thinkingConfig、OpenAI 兼容 reasoning_effort）
+//   - 输出侧：ChatEvent.Reasoning，由各入口渲染为 reasoning_content / message.thinking / thinking 块
+// 只有客户端明确关闭思考（reasoning_effort=none、think=false、thinking.type=disabled）时才丢弃上游的思考内容。
+
+const reasoningEffortNone = "none"
+
+// reasoningBudgets 档位对应的思考 token 预算，Anthropic 最小预算为 1024
+var reasoningBudgets = map[string]int{
+	"minimal": 1024,
+	"low":     2048,
+	"medium":  8192,
+	"high":    24576,
+}
+
+// reasoningDisabled 客户端是否明确关闭了思考
+func reasoningDisabled(req *ChatCompletionRequest) bool {
+	return req.ReasoningEffort == reasoningEffortNone
+}
+
+// reasoningBudget 请求的思考预算，ok 为 false 表示客户端没有指定（由上游默认行为决定）
+func reasoningBudget(req *ChatCompletionRequest) (budget int, ok bool) {
+	if reasoningDisabled(req) {
+		return 0, true
+	}
+	if req.ThinkingBudget > 0 {
+		return req.ThinkingBudget, true
+	}
+	if budget, ok := reasoningBudgets[req.ReasoningEffort]; ok {
+		return budget, true
+	}
+	return 0, false
+}
+
+// reasoningEffort 请求的思考档位，只指定了预算时按预算换算
+func reasoningEffort(req *ChatCompletionRequest) string {
+	if req.ReasoningEffort != "" || req.ThinkingBudget <= 0 {
+		return req.ReasoningEffort
+	}
+	switch {
+	case req.ThinkingBudget <= reasoningBudgets["low"]:
+		return "low"
+	case req.ThinkingBudget <= reasoningBudgets["medium"]:
+		return "medium"
+	default:
+		return "high"
+	}
+}
+
+// ollamaThinkEffort Ollama 的 think 可以是布尔值或 "low"/"medium"/"high"
+func ollamaThinkEffort(think interface{}) string {
+	switch v := think.(type) {
+	case bool:
+		if v {
+			return "medium"
+		}
+		return reasoningEffortNone
+	case string:
+		switch v = strings.ToLower(v); v {
+		case "low", "medium", "high":
+			return v
+		case "true":
+			return "medium"
+		case "false":
+			return reasoningEffortNone
+		}
+	}
+	return ""
+}
+
+// difyThoughts Dify agent_thought 事件中的 thought 是该步骤截至目前的完整内容（同一 id 会重复推送），
+// 最后一步的 thought 往往就是已经通过 agent_message 输出过的回答，需要去掉
+type difyThoughts struct {
+	seen   map[string]string
+	answer strings.Builder
+}
+
+// delta 返回该 thought 新增的、且不属于回答的部分
+func (d *difyThoughts) delta(id, thought string) string {
+	if d.seen == nil {
+		d.seen = map[string]string{}
+	}
+	prev := d.seen[id]
+	d.seen[id] = thought
+	if strings.TrimSpace(thought) == "" || strings.Contains(d.answer.String(), strings.TrimSpace(thought)) {
+		return ""
+	}
+	if strings.HasPrefix(thought, prev) {
+		return thought[len(prev):]
+	}
+	return thought
+}
+
+// dropReasoning 客户端关闭思考但上游仍输出思考内容时（如 deepseek-reasoner）在代理侧丢弃
+func dropReasoning(emit func(ChatEvent) error) func(ChatEvent) error {
+	return func(ev ChatEvent) error {
+		ev.Reasoning = ""
+		if ev.empty() {
+			return nil
+		}
+		return emit(ev)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// reasoningStandIn OpenAI 兼容上游，先输出思考再输出回答和一个工具调用
+func reasoningStandIn() *standIn {
+	return newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		writeSSE(w,
+			openaiChunk(`{"reasoning_content":"let me "}`, ""),
+			openaiChunk(`{"reasoning":"think"}`, ""),
+			openaiContent("Paris", ""),
+			openaiChunk(`{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Paris\"}"}}]}`, "tool_calls"),
+			openaiUsage(5, 3),
+			"[DONE]")
+	})
+}
+
+func TestClaudeThinkingToOllama(t *testing.T) {
+	server := newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		writeClaudeSSE(w,
+			`{"type":"message_start","message":{"usage":{"input_tokens":4}}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"thinking_delta","thinking":"hmm"}}`,
+			`{"type":"content_block_delta","index":1,"delta":{"type":"text_delta","text":"42"}}`,
+			`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":6}}`)
+	})
+	defer server.Close()
+
+	XConfig = &Config{ChatType: "claude", APIURL: server.URL}
+	defer func() { XConfig = nil }()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/chat", chatHandlerSteam)
+
+	chat := func(think string) OllamaResponse {
+		body := `{"model":"claude-sonnet-4","stream":false,"think":` + think + `,"messages":[{"role":"user","content":"?"}]}`
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/api/chat", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+		}
+		var resp OllamaResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+
+	resp := chat(`"high"`)
+	if resp.Message.Thinking != "hmm" || resp.Message.Content != "42" {
+		t.Fatalf("message = %+v", resp.Message)
+	}
+	bodies := server.bodies()
+	thinking, _ := bodies[0]["thinking"].(map[string]interface{})
+	if thinking["type"] != "enabled" || thinking["budget_tokens"] != float64(24576) {
+		t.Fatalf("thinking = %v", bodies[0]["thinking"])
+	}
+	if bodies[0]["max_tokens"].(float64) <= 24576 || bodies[0]["temperature"] != float64(1) {
+		t.Fatalf("max_tokens/temperature not adjusted: %v", bodies[0])
+	}
+
+	chat(`false`)
+	bodies = server.bodies()
+	if _, ok := bodies[1]["thinking"]; ok {
+		t.Fatalf("think=false should not enable thinking: %v", bodies[1])
+	}
+}
+
+func TestClaudeMessagesFrontEnd(t *testing.T) {
+	server := reasoningStandIn()
+	defer server.Close()
+
+	XConfig = &Config{
+		ChatType:  "dify",
+		Providers: []ProviderConfig{{Name: "local", Type: "openai", BaseUrl: server.URL, Models: []string{"coder"}}},
+	}
+	defer func() { XConfig = nil }()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/claude/v1/messages", ClaudeHandlerSteam)
+
+	request := `{"model":"coder","max_tokens":4096,"stream":%s,
+		"system":[{"type":"text","text":"be brief"}],
+		"thinking":{"type":"enabled","budget_tokens":2048},
+		"tools":[{"name":"weather","input_schema":{"type":"object"}}],
+		"messages":[
+			{"role":"user","content":"weather?"},
+			{"role":"assistant","content":[{"type":"thinking","thinking":"x","signature":"s"},{"type":"tool_use","id":"toolu_0","name":"weather","input":{"city":"Rome"}}]},
+			{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_0","content":"sunny"},{"type":"text","text":"and Paris?"}]}
+		]}`
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/claude/v1/messages", strings.NewReader(fmt.Sprintf(request, "false"))))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	sent := server.bodies()[0]
+	if sent["reasoning_effort"] != "low" {
+		t.Fatalf("reasoning_effort = %v", sent["reasoning_effort"])
+	}
+	messages := sent["messages"].([]interface{})
+	roles := []string{}
+	for _, m := range messages {
+		roles = append(roles, m.(map[string]interface{})["role"].(string))
+	}
+	if strings.Join(roles, ",") != "system,user,assistant,tool,user" {
+		t.Fatalf("roles = %v", roles)
+	}
+
+	var msg struct {
+		Content []map[string]interface{} `json:"content"`
+		Stop    string                   `json:"stop_reason"`
+		Usage   map[string]int           `json:"usage"`
+	}
+	_ = json.Unmarshal(w.Body.Bytes(), &msg)
+	if len(msg.Content) != 3 || msg.Content[0]["thinking"] != "let me think" || msg.Content[1]["text"] != "Paris" ||
+		msg.Content[2]["type"] != "tool_use" || msg.Stop != "tool_use" || msg.Usage["output_tokens"] != 3 {
+		t.Fatalf("message = %s", w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/claude/v1/messages", strings.NewReader(fmt.Sprintf(request, "true"))))
+	var events []string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(line, "event: ") {
+			events = append(events, strings.TrimPrefix(line, "event: "))
+		}
+	}
+	want := "message_start,content_block_start,content_block_delta,content_block_delta,content_block_stop," +
+		"content_block_start,content_block_delta,content_block_stop," +
+		"content_block_start,content_block_delta,content_block_stop,message_delta,message_stop"
+	if strings.Join(events, ",") != want {
+		t.Fatalf("events = %v", events)
+	}
+	if !strings.Contains(w.Body.String(), `"type":"thinking_delta"`) || !strings.Contains(w.Body.String(), `"partial_json":"{\"city\":\"Paris\"}"`) {
+		t.Fatalf("stream = %s", w.Body.String())
+	}
+}
+
+func TestReasoningEffortNone(t *testing.T) {
+	server := reasoningStandIn()
+	defer server.Close()
+
+	XConfig = &Config{
+		ChatType:  "dify",
+		Providers: []ProviderConfig{{Name: "local", Type: "openai", BaseUrl: server.URL, Models: []string{"coder"}}},
+	}
+	defer func() { XConfig = nil }()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/chat/completions", OpenaiHandler)
+
+	body := `{"model":"coder","reasoning_effort":"none","messages":[{"role":"user","content":"?"}]}`
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+	if sent := server.bodies()[0]; sent["reasoning_effort"] != nil {
+		t.Fatalf("none should not be forwarded: %v", sent)
+	}
+	if strings.Contains(w.Body.String(), "reasoning_content") {
+		t.Fatalf("reasoning should be dropped: %s", w.Body.String())
+	}
+}
+
+func TestDifyThoughts(t *testing.T) {
+	d := difyThoughts{}
+	if got := d.delta("t1", "I should "); got != "I should " {
+		t.Fatalf("first = %q", got)
+	}
+	if got := d.delta("t1", "I should call the tool"); got != "call the tool" {
+		t.Fatalf("grown = %q", got)
+	}
+	d.answer.WriteString("The answer is 42.")
+	if got := d.delta("t2", "The answer is 42."); got != "" {
+		t.Fatalf("answer repeated as thought: %q", got)
+	}
+}
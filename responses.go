@@ -0,0 +1,714 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OpenAI Responses API（/v1/responses）前端：input 条目转换为对话消息交给后端，
+// 后端输出再组装为 message / function_call / reasoning 输出项，流式时按 response.* 事件推送
+
+// ResponsesRequest /v1/responses 请求
+type ResponsesRequest struct {
+	Model              string              `json:"model"`
+	Input              json.RawMessage     `json:"input"`
+	Instructions       string              `json:"instructions,omitempty"`
+	PreviousResponseID string              `json:"previous_response_id,omitempty"`
+	Tools              []ResponsesTool     `json:"tools,omitempty"`
+	ToolChoice         interface{}         `json:"tool_choice,omitempty"`
+	ParallelToolCalls  *bool               `json:"parallel_tool_calls,omitempty"`
+	Stream             bool                `json:"stream,omitempty"`
+	Store              *bool               `json:"store,omitempty"` // 默认保存，供 previous_response_id 使用
+	Temperature        *float32            `json:"temperature,omitempty"`
+	TopP               float32             `json:"top_p,omitempty"`
+	MaxOutputTokens    int                 `json:"max_output_tokens,omitempty"`
+	Reasoning          *ResponsesReasoning `json:"reasoning,omitempty"`
+	Text               *ResponsesText      `json:"text,omitempty"`
+	Metadata           map[string]string   `json:"metadata,omitempty"`
+	User               string              `json:"user,omitempty"`
+}
+
+// ResponsesReasoning 思考配置，effort 与 Chat Completions 的 reasoning_effort 相同
+type ResponsesReasoning struct {
+	Effort  string `json:"effort,omitempty"`
+	Summary string `json:"summary,omitempty"`
+}
+
+// ResponsesText 输出格式，format 与 response_format 相同但 json_schema 的字段是扁平的
+type ResponsesText struct {
+	Format *struct {
+		Type        string          `json:"type"` // text / json_object / json_schema
+		Name        string          `json:"name,omitempty"`
+		Description string          `json:"description,omitempty"`
+		Schema      json.RawMessage `json:"schema,omitempty"`
+		Strict      bool            `json:"strict,omitempty"`
+	} `json:"format,omitempty"`
+}
+
+// responsesFormatToChat text.format 转为 response_format
+func responsesFormatToChat(text *ResponsesText) *ChatCompletionResponseFormat {
+	if text == nil || text.Format == nil {
+		return nil
+	}
+	switch f := text.Format; f.Type {
+	case "json_object":
+		return &ChatCompletionResponseFormat{Type: "json_object"}
+	case "json_schema":
+		return &ChatCompletionResponseFormat{Type: "json_schema", JSONSchema: &ChatCompletionResponseFormatJSONSchema{
+			Name:        f.Name,
+			Description: f.Description,
+			Schema:      f.Schema,
+			Strict:      f.Strict,
+		}}
+	default:
+		return nil
+	}
+}
+
+// ResponsesTool Responses 的函数工具定义是扁平的，不像 Chat Completions 嵌套在 function 中
+type ResponsesTool struct {
+	Type        string      `json:"type"`
+	Name        string      `json:"name,omitempty"`
+	Description string      `json:"description,omitempty"`
+	Parameters  interface{} `json:"parameters,omitempty"`
+	Strict      bool        `json:"strict,omitempty"`
+}
+
+type ResponsesContentPart struct {
+	Type        string        `json:"type"`
+	Text        string        `json:"text"`
+	Annotations []interface{} `json:"annotations"`
+}
+
+type ResponsesSummaryPart struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type ResponsesMessageItem struct {
+	Type    string                 `json:"type"`
+	ID      string                 `json:"id"`
+	Status  string                 `json:"status"`
+	Role    string                 `json:"role"`
+	Content []ResponsesContentPart `json:"content"`
+}
+
+type ResponsesFunctionCallItem struct {
+	Type      string `json:"type"`
+	ID        string `json:"id"`
+	CallID    string `json:"call_id"`
+	Name      string `json:"name"`
+	Arguments string `json:"arguments"`
+	Status    string `json:"status"`
+}
+
+type ResponsesReasoningItem struct {
+	Type    string                 `json:"type"`
+	ID      string                 `json:"id"`
+	Summary []ResponsesSummaryPart `json:"summary"`
+}
+
+type ResponsesUsage struct {
+	InputTokens        int `json:"input_tokens"`
+	InputTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"input_tokens_details"`
+	OutputTokens        int `json:"output_tokens"`
+	OutputTokensDetails struct {
+		ReasoningTokens int `json:"reasoning_tokens"`
+	} `json:"output_tokens_details"`
+	TotalTokens int `json:"total_tokens"`
+}
+
+type ResponsesError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type ResponsesIncompleteDetails struct {
+	Reason string `json:"reason"`
+}
+
+// ResponsesResponse response 对象，Output 中为各类输出项
+type ResponsesResponse struct {
+	ID                 string                      `json:"id"`
+	Object             string                      `json:"object"`
+	CreatedAt          int64                       `json:"created_at"`
+	Status             string                      `json:"status"` // in_progress / completed / incomplete / failed
+	Error              *ResponsesError             `json:"error"`
+	IncompleteDetails  *ResponsesIncompleteDetails `json:"incomplete_details"`
+	Instructions       *string                     `json:"instructions"`
+	MaxOutputTokens    *int                        `json:"max_output_tokens"`
+	Model              string                      `json:"model"`
+	Output             []interface{}               `json:"output"`
+	ParallelToolCalls  bool                        `json:"parallel_tool_calls"`
+	PreviousResponseID *string                     `json:"previous_response_id"`
+	Store              bool                        `json:"store"`
+	Temperature        *float32                    `json:"temperature"`
+	TopP               *float32                    `json:"top_p"`
+	ToolChoice         interface{}                 `json:"tool_choice"`
+	Tools              []ResponsesTool             `json:"tools"`
+	Usage              *ResponsesUsage             `json:"usage"`
+	Metadata           map[string]string           `json:"metadata"`
+}
+
+// storedResponse 保存的响应，Messages 为本轮结束后的完整对话（不含 instructions，与 OpenAI 一致不会沿用到下一轮）
+type storedResponse struct {
+	Response *ResponsesResponse
+	Messages []ChatCompletionMessage
+}
+
+// maxStoredResponses 内存中最多保存的响应数，超出后淘汰最早的
+const maxStoredResponses = 1000
+
+var (
+	responsesMu    sync.Mutex
+	responsesStore = map[string]*storedResponse{}
+	responsesOrder []string
+)
+
+func storeResponse(stored *storedResponse) {
+	responsesMu.Lock()
+	defer responsesMu.Unlock()
+	id := stored.Response.ID
+	if _, ok := responsesStore[id]; !ok {
+		responsesOrder = append(responsesOrder, id)
+	}
+	responsesStore[id] = stored
+	for len(responsesOrder) > maxStoredResponses {
+		delete(responsesStore, responsesOrder[0])
+		responsesOrder = responsesOrder[1:]
+	}
+}
+
+func lookupResponse(id string) (*storedResponse, bool) {
+	responsesMu.Lock()
+	defer responsesMu.Unlock()
+	stored, ok := responsesStore[id]
+	return stored, ok
+}
+
+func deleteResponse(id string) bool {
+	responsesMu.Lock()
+	defer responsesMu.Unlock()
+	if _, ok := responsesStore[id]; !ok {
+		return false
+	}
+	delete(responsesStore, id)
+	for i, v := range responsesOrder {
+		if v == id {
+			responsesOrder = append(responsesOrder[:i], responsesOrder[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+// responsesInputItem input 数组中的条目，按 type 区分 message / function_call / function_call_output
+type responsesInputItem struct {
+	Type      string          `json:"type"`
+	Role      string          `json:"role"`
+	Content   json.RawMessage `json:"content"`
+	CallID    string          `json:"call_id"`
+	Name      string          `json:"name"`
+	Arguments string          `json:"arguments"`
+	Output    json.RawMessage `json:"output"`
+}
+
+// responsesContent input 中的内容可以是字符串或 input_text / output_text / input_image 分片
+func responsesContent(raw json.RawMessage) (interface{}, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+	var parts []struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		ImageURL string `json:"image_url"`
+		Detail   string `json:"detail"`
+	}
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return nil, fmt.Errorf("invalid content: %w", err)
+	}
+	out := make([]ChatMessagePart, 0, len(parts))
+	hasImage := false
+	for _, part := range parts {
+		switch part.Type {
+		case "input_text", "output_text", "text", "summary_text":
+			out = append(out, ChatMessagePart{Type: "text", Text: part.Text})
+		case "input_image":
+			hasImage = true
+			out = append(out, ChatMessagePart{Type: "image_url", ImageURL: &ChatMessageImageURL{URL: part.ImageURL, Detail: ImageURLDetail(part.Detail)}})
+		default:
+			log.Println("忽略不支持的 input 内容类型:", part.Type)
+		}
+	}
+	if !hasImage {
+		return messageText(out), nil
+	}
+	return out, nil
+}
+
+// responsesInputToMessages input 转为对话消息；连续的 function_call 合并到同一条 assistant 消息
+func responsesInputToMessages(raw json.RawMessage) ([]ChatCompletionMessage, error) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return []ChatCompletionMessage{{Role: "user", Content: text}}, nil
+	}
+	var items []responsesInputItem
+	if err := json.Unmarshal(raw, &items); err != nil {
+		return nil, fmt.Errorf("input must be a string or an array of items")
+	}
+	messages := make([]ChatCompletionMessage, 0, len(items))
+	for _, item := range items {
+		switch item.Type {
+		case "", "message":
+			content, err := responsesContent(item.Content)
+			if err != nil {
+				return nil, err
+			}
+			role := item.Role
+			if role == "developer" {
+				role = "system"
+			}
+			messages = append(messages, ChatCompletionMessage{Role: role, Content: content})
+		case "function_call":
+			call := ToolCall{ID: item.CallID, Type: "function", Function: FunctionCall{Name: item.Name, Arguments: item.Arguments}}
+			if n := len(messages); n > 0 && messages[n-1].Role == "assistant" {
+				messages[n-1].ToolCalls = append(messages[n-1].ToolCalls, call)
+			} else {
+				messages = append(messages, ChatCompletionMessage{Role: "assistant", ToolCalls: []ToolCall{call}})
+			}
+		case "function_call_output":
+			output, err := responsesContent(item.Output)
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, ChatCompletionMessage{Role: "tool", ToolCallID: item.CallID, Content: messageText(output)})
+		case "reasoning":
+			// 思考过程不回传给后端
+		default:
+			return nil, fmt.Errorf("unsupported input item type: %s", item.Type)
+		}
+	}
+	return messages, nil
+}
+
+// responsesToolsToChat 只转换函数工具，web_search 等内置工具后端无法执行，忽略
+func responsesToolsToChat(tools []ResponsesTool) []Tool {
+	var out []Tool
+	for _, tool := range tools {
+		if tool.Type != "function" {
+			log.Println("忽略不支持的工具类型:", tool.Type)
+			continue
+		}
+		out = append(out, Tool{Type: "function", Function: &FunctionDefinition{
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  tool.Parameters,
+			Strict:      tool.Strict,
+		}})
+	}
+	return out
+}
+
+// responsesToolChoiceToChat {"type":"function","name":"x"} 转为 {"type":"function","function":{"name":"x"}}
+func responsesToolChoiceToChat(choice interface{}) interface{} {
+	if m, ok := choice.(map[string]interface{}); ok && m["type"] == "function" {
+		return map[string]interface{}{"type": "function", "function": map[string]interface{}{"name": m["name"]}}
+	}
+	return choice
+}
+
+// responsesBuilder 把后端事件组装为输出项；write 为 true 时同时推送流式事件
+type responsesBuilder struct {
+	c       *gin.Context
+	write   bool
+	started bool
+	seq     int
+	resp    *ResponsesResponse
+
+	// 当前打开的输出项，同一时间只有一个
+	msg       *ResponsesMessageItem
+	text      strings.Builder
+	reasoning *ResponsesReasoningItem
+	summary   strings.Builder
+
+	content   strings.Builder
+	toolCalls []ToolCall
+	reason    FinishReason
+	usage     *Usage
+}
+
+func (b *responsesBuilder) send(event string, payload gin.H) error {
+	if !b.write {
+		return nil
+	}
+	if err := b.begin(); err != nil {
+		return err
+	}
+	return b.sendEvent(event, payload)
+}
+
+// begin 第一次写出时发送 response.created 与 response.in_progress
+func (b *responsesBuilder) begin() error {
+	if b.started {
+		return nil
+	}
+	b.started = true
+	b.c.Header("content-Type", "text/event-stream")
+	b.c.Header("cache-control", "no-cache")
+	b.c.Header("Connection", "keep-alive")
+	created := *b.resp
+	if err := b.sendEvent("response.created", gin.H{"response": &created}); err != nil {
+		return err
+	}
+	return b.sendEvent("response.in_progress", gin.H{"response": &created})
+}
+
+// ping 保活，Responses 事件流没有 ping 事件，使用 SSE 注释
+func (b *responsesBuilder) ping() error {
+	if err := b.begin(); err != nil {
+		return err
+	}
+	return PingData(b.c)
+}
+
+func (b *responsesBuilder) sendEvent(event string, payload gin.H) error {
+	payload["type"] = event
+	payload["sequence_number"] = b.seq
+	b.seq++
+	return EventData(b.c, event, payload)
+}
+
+func (b *responsesBuilder) outputIndex() int {
+	return len(b.resp.Output)
+}
+
+func (b *responsesBuilder) openReasoning() error {
+	if b.reasoning != nil {
+		return nil
+	}
+	if err := b.closeMessage(); err != nil {
+		return err
+	}
+	b.reasoning = &ResponsesReasoningItem{Type: "reasoning", ID: "rs_" + RandString(24), Summary: []ResponsesSummaryPart{}}
+	b.summary.Reset()
+	if err := b.send("response.output_item.added", gin.H{"output_index": b.outputIndex(), "item": b.reasoning}); err != nil {
+		return err
+	}
+	return b.send("response.reasoning_summary_part.added", gin.H{
+		"item_id": b.reasoning.ID, "output_index": b.outputIndex(), "summary_index": 0,
+		"part": ResponsesSummaryPart{Type: "summary_text"},
+	})
+}
+
+func (b *responsesBuilder) closeReasoning() error {
+	if b.reasoning == nil {
+		return nil
+	}
+	item := b.reasoning
+	b.reasoning = nil
+	part := ResponsesSummaryPart{Type: "summary_text", Text: b.summary.String()}
+	item.Summary = []ResponsesSummaryPart{part}
+	index := b.outputIndex()
+	if err := b.send("response.reasoning_summary_text.done", gin.H{"item_id": item.ID, "output_index": index, "summary_index": 0, "text": part.Text}); err != nil {
+		return err
+	}
+	if err := b.send("response.reasoning_summary_part.done", gin.H{"item_id": item.ID, "output_index": index, "summary_index": 0, "part": part}); err != nil {
+		return err
+	}
+	b.resp.Output = append(b.resp.Output, item)
+	return b.send("response.output_item.done", gin.H{"output_index": index, "item": item})
+}
+
+func (b *responsesBuilder) openMessage() error {
+	if b.msg != nil {
+		return nil
+	}
+	if err := b.closeReasoning(); err != nil {
+		return err
+	}
+	b.msg = &ResponsesMessageItem{Type: "message", ID: "msg_" + RandString(24), Status: "in_progress", Role: "assistant", Content: []ResponsesContentPart{}}
+	b.text.Reset()
+	if err := b.send("response.output_item.added", gin.H{"output_index": b.outputIndex(), "item": b.msg}); err != nil {
+		return err
+	}
+	return b.send("response.content_part.added", gin.H{
+		"item_id": b.msg.ID, "output_index": b.outputIndex(), "content_index": 0,
+		"part": ResponsesContentPart{Type: "output_text", Annotations: []interface{}{}},
+	})
+}
+
+func (b *responsesBuilder) closeMessage() error {
+	if b.msg == nil {
+		return nil
+	}
+	item := b.msg
+	b.msg = nil
+	part := ResponsesContentPart{Type: "output_text", Text: b.text.String(), Annotations: []interface{}{}}
+	item.Content = []ResponsesContentPart{part}
+	item.Status = "completed"
+	index := b.outputIndex()
+	if err := b.send("response.output_text.done", gin.H{"item_id": item.ID, "output_index": index, "content_index": 0, "text": part.Text}); err != nil {
+		return err
+	}
+	if err := b.send("response.content_part.done", gin.H{"item_id": item.ID, "output_index": index, "content_index": 0, "part": part}); err != nil {
+		return err
+	}
+	b.resp.Output = append(b.resp.Output, item)
+	return b.send("response.output_item.done", gin.H{"output_index": index, "item": item})
+}
+
+func (b *responsesBuilder) add(ev ChatEvent) error {
+	if ev.Usage != nil {
+		b.usage = ev.Usage
+	}
+	if ev.FinishReason != "" {
+		b.reason = ev.FinishReason
+	}
+	if ev.Reasoning != "" {
+		if err := b.openReasoning(); err != nil {
+			return err
+		}
+		b.summary.WriteString(ev.Reasoning)
+		if err := b.send("response.reasoning_summary_text.delta", gin.H{"item_id": b.reasoning.ID, "output_index": b.outputIndex(), "summary_index": 0, "delta": ev.Reasoning}); err != nil {
+			return err
+		}
+	}
+	if ev.Content != "" {
+		if err := b.openMessage(); err != nil {
+			return err
+		}
+		b.text.WriteString(ev.Content)
+		b.content.WriteString(ev.Content)
+		if err := b.send("response.output_text.delta", gin.H{"item_id": b.msg.ID, "output_index": b.outputIndex(), "content_index": 0, "delta": ev.Content, "logprobs": []interface{}{}}); err != nil {
+			return err
+		}
+	}
+	b.toolCalls = append(b.toolCalls, ev.ToolCalls...)
+	return nil
+}
+
+// finish 关闭打开的输出项，输出函数调用，填充最终状态；返回本轮 assistant 消息供保存对话
+func (b *responsesBuilder) finish() (ChatCompletionMessage, error) {
+	if err := b.closeReasoning(); err != nil {
+		return ChatCompletionMessage{}, err
+	}
+	if err := b.closeMessage(); err != nil {
+		return ChatCompletionMessage{}, err
+	}
+	assistant := ChatCompletionMessage{Role: "assistant", Content: b.content.String()}
+	for i, call := range b.toolCalls {
+		if call.ID == "" {
+			call.ID = "call_" + RandString(24)
+		}
+		assistant.ToolCalls = append(assistant.ToolCalls, call)
+		item := &ResponsesFunctionCallItem{Type: "function_call", ID: "fc_" + RandString(24), CallID: call.ID, Name: call.Function.Name, Status: "in_progress"}
+		index := b.outputIndex()
+		if err := b.send("response.output_item.added", gin.H{"output_index": index, "item": item}); err != nil {
+			return assistant, err
+		}
+		if err := b.send("response.function_call_arguments.delta", gin.H{"item_id": item.ID, "output_index": index, "delta": call.Function.Arguments}); err != nil {
+			return assistant, err
+		}
+		if err := b.send("response.function_call_arguments.done", gin.H{"item_id": item.ID, "output_index": index, "arguments": call.Function.Arguments}); err != nil {
+			return assistant, err
+		}
+		done := *item
+		done.Arguments = call.Function.Arguments
+		done.Status = "completed"
+		b.resp.Output = append(b.resp.Output, &done)
+		if err := b.send("response.output_item.done", gin.H{"output_index": index, "item": &done}); err != nil {
+			return assistant, err
+		}
+		b.toolCalls[i] = call
+	}
+
+	b.resp.Status = "completed"
+	switch b.reason {
+	case FinishReasonLength:
+		b.resp.Status = "incomplete"
+		b.resp.IncompleteDetails = &ResponsesIncompleteDetails{Reason: "max_output_tokens"}
+	case FinishReasonContentFilter:
+		b.resp.Status = "incomplete"
+		b.resp.IncompleteDetails = &ResponsesIncompleteDetails{Reason: "content_filter"}
+	}
+	if b.usage != nil {
+		usage := &ResponsesUsage{InputTokens: b.usage.PromptTokens, OutputTokens: b.usage.CompletionTokens, TotalTokens: b.usage.TotalTokens}
+		if usage.TotalTokens == 0 {
+			usage.TotalTokens = usage.InputTokens + usage.OutputTokens
+		}
+		b.resp.Usage = usage
+	}
+	event := "response.completed"
+	if b.resp.Status == "incomplete" {
+		event = "response.incomplete"
+	}
+	return assistant, b.send(event, gin.H{"response": b.resp})
+}
+
+// newResponsesResponse 按请求参数生成 in_progress 状态的 response
+func newResponsesResponse(input *ResponsesRequest) *ResponsesResponse {
+	resp := &ResponsesResponse{
+		ID:                "resp_" + RandString(32),
+		Object:            "response",
+		CreatedAt:         time.Now().Unix(),
+		Status:            "in_progress",
+		Model:             input.Model,
+		Output:            []interface{}{},
+		ParallelToolCalls: input.ParallelToolCalls == nil || *input.ParallelToolCalls,
+		Store:             input.Store == nil || *input.Store,
+		ToolChoice:        input.ToolChoice,
+		Tools:             input.Tools,
+		Metadata:          input.Metadata,
+	}
+	if resp.ToolChoice == nil {
+		resp.ToolChoice = "auto"
+	}
+	if resp.Tools == nil {
+		resp.Tools = []ResponsesTool{}
+	}
+	if resp.Metadata == nil {
+		resp.Metadata = map[string]string{}
+	}
+	if input.Instructions != "" {
+		resp.Instructions = &input.Instructions
+	}
+	if input.PreviousResponseID != "" {
+		resp.PreviousResponseID = &input.PreviousResponseID
+	}
+	if input.MaxOutputTokens > 0 {
+		resp.MaxOutputTokens = &input.MaxOutputTokens
+	}
+	resp.Temperature = input.Temperature
+	if input.TopP != 0 {
+		resp.TopP = &input.TopP
+	}
+	return resp
+}
+
+func responsesError(c *gin.Context, code int, param, errCode, message string) {
+	c.JSON(code, gin.H{"error": gin.H{"message": message, "type": "invalid_request_error", "param": param, "code": errCode}})
+}
+
+// ResponsesHandler POST /v1/responses
+func ResponsesHandler(c *gin.Context) {
+	var input ResponsesRequest
+	if err := c.ShouldBindJSON(&input); err != nil {
+		responsesError(c, http.StatusBadRequest, "", "", "Invalid request: "+err.Error())
+		return
+	}
+	var history []ChatCompletionMessage
+	if input.PreviousResponseID != "" {
+		prev, ok := lookupResponse(input.PreviousResponseID)
+		if !ok {
+			responsesError(c, http.StatusNotFound, "previous_response_id", "previous_response_not_found",
+				fmt.Sprintf("Previous response with id '%s' not found.", input.PreviousResponseID))
+			return
+		}
+		history = append(history, prev.Messages...)
+	}
+	turn, err := responsesInputToMessages(input.Input)
+	if err != nil {
+		responsesError(c, http.StatusBadRequest, "input", "", err.Error())
+		return
+	}
+	history = append(history, turn...)
+	provider, upstreamModel, err := selectProvider(input.Model)
+	if err != nil {
+		responsesError(c, http.StatusBadRequest, "model", "", err.Error())
+		return
+	}
+	provider = withThinkTags(c, input.Model, withContinuation(c, input.Model, provider))
+
+	messages := history
+	if input.Instructions != "" {
+		messages = append([]ChatCompletionMessage{{Role: "system", Content: input.Instructions}}, history...)
+	}
+	req := &ChatCompletionRequest{
+		Model:          upstreamModel,
+		Messages:       messages,
+		Tools:          responsesToolsToChat(input.Tools),
+		ToolChoice:     responsesToolChoiceToChat(input.ToolChoice),
+		Temperature:    input.Temperature,
+		TopP:           input.TopP,
+		MaxTokens:      input.MaxOutputTokens,
+		ResponseFormat: responsesFormatToChat(input.Text),
+		User:           input.User,
+		Stream:         true,
+	}
+	if input.Reasoning != nil {
+		req.ReasoningEffort = input.Reasoning.Effort
+	}
+	// ParallelToolCalls 为 any，直接赋 nil 指针会被序列化为 null，只在客户端指定时设置
+	if input.ParallelToolCalls != nil {
+		req.ParallelToolCalls = *input.ParallelToolCalls
+	}
+
+	b := &responsesBuilder{c: c, write: input.Stream, resp: newResponsesResponse(&input)}
+	watch := watchClient(c, input.Model)
+	if input.Stream {
+		watch.keepalive(b.ping)
+	}
+	err = provider.ChatStream(watch.ctx, req, watch.emit(func(ev ChatEvent) error {
+		applyEventHeader(c, ev.Header)
+		return b.add(ev)
+	}))
+	if watch.gone() {
+		return
+	}
+	err = watch.failure(err)
+	if err != nil && !b.started {
+		openaiUpstreamError(c, err)
+		return
+	}
+	if err != nil {
+		log.Println("Stream error:", err)
+		b.resp.Status = "failed"
+		b.resp.Error = &ResponsesError{Code: "server_error", Message: err.Error()}
+		_ = b.send("response.failed", gin.H{"response": b.resp})
+		return
+	}
+	assistant, err := b.finish()
+	if err != nil {
+		log.Println("Stream error:", err)
+		return
+	}
+	if b.resp.Store {
+		storeResponse(&storedResponse{Response: b.resp, Messages: append(history, assistant)})
+	}
+	if !input.Stream {
+		c.JSON(http.StatusOK, b.resp)
+	}
+}
+
+// ResponsesGetHandler GET /v1/responses/:id
+func ResponsesGetHandler(c *gin.Context) {
+	stored, ok := lookupResponse(c.Param("id"))
+	if !ok {
+		responsesError(c, http.StatusNotFound, "", "", fmt.Sprintf("Response with id '%s' not found.", c.Param("id")))
+		return
+	}
+	c.JSON(http.StatusOK, stored.Response)
+}
+
+// ResponsesDeleteHandler DELETE /v1/responses/:id
+func ResponsesDeleteHandler(c *gin.Context) {
+	id := c.Param("id")
+	if !deleteResponse(id) {
+		responsesError(c, http.StatusNotFound, "", "", fmt.Sprintf("Response with id '%s' not found.", id))
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"id": id, "object": "response.deleted", "deleted": true})
+}
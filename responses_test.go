@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func responsesRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/responses", ResponsesHandler)
+	router.GET("/v1/responses/:id", ResponsesGetHandler)
+	router.DELETE("/v1/responses/:id", ResponsesDeleteHandler)
+	return router
+}
+
+func TestResponsesChaining(t *testing.T) {
+	server := openaiStandIn()
+	defer server.Close()
+
+	XConfig = &Config{
+		ChatType:  "dify",
+		Providers: []ProviderConfig{{Name: "local", Type: "openai", BaseUrl: server.URL, Models: []string{"coder"}}},
+	}
+	defer func() { XConfig = nil }()
+	router := responsesRouter()
+	post := func(body string) (int, ResponsesResponse, string) {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/v1/responses", strings.NewReader(body)))
+		var resp ResponsesResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &resp)
+		return w.Code, resp, w.Body.String()
+	}
+
+	code, first, raw := post(`{"model":"coder","instructions":"be brief","input":"hello"}`)
+	if code != http.StatusOK || first.Status != "completed" || !strings.HasPrefix(first.ID, "resp_") {
+		t.Fatalf("first response = %d %s", code, raw)
+	}
+	if !strings.Contains(raw, `"type":"output_text","text":"Hi there"`) || first.Usage == nil || first.Usage.TotalTokens != 9 {
+		t.Fatalf("output/usage not translated: %s", raw)
+	}
+	messages := server.bodies()[0]["messages"].([]interface{})
+	if len(messages) != 2 || messages[0].(map[string]interface{})["role"] != "system" {
+		t.Fatalf("instructions not sent as system: %v", messages)
+	}
+
+	code, _, raw = post(`{"model":"coder","previous_response_id":"` + first.ID + `","input":[{"type":"message","role":"user","content":[{"type":"input_text","text":"again"}]}]}`)
+	if code != http.StatusOK {
+		t.Fatalf("chained response = %d %s", code, raw)
+	}
+	// instructions 不沿用到下一轮，历史为 user / assistant / user
+	messages = server.bodies()[1]["messages"].([]interface{})
+	if len(messages) != 3 || messages[1].(map[string]interface{})["content"] != "Hi there" || messages[2].(map[string]interface{})["content"] != "again" {
+		t.Fatalf("history not chained: %v", messages)
+	}
+
+	if code, _, _ := post(`{"model":"coder","previous_response_id":"resp_missing","input":"x"}`); code != http.StatusNotFound {
+		t.Fatalf("unknown previous_response_id = %d", code)
+	}
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/v1/responses/"+first.ID, nil))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), first.ID) {
+		t.Fatalf("get = %d %s", w.Code, w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("DELETE", "/v1/responses/"+first.ID, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete = %d", w.Code)
+	}
+	if _, ok := lookupResponse(first.ID); ok {
+		t.Fatal("response not deleted")
+	}
+}
+
+func TestResponsesFunctionCalls(t *testing.T) {
+	server := newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		writeSSE(w,
+			openaiChunk(`{"reasoning_content":"need weather"}`, ""),
+			openaiChunk(`{"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"weather","arguments":"{\"city\":\"Paris\"}"}}]}`, "tool_calls"),
+			"[DONE]")
+	})
+	defer server.Close()
+
+	XConfig = &Config{
+		ChatType:  "dify",
+		Providers: []ProviderConfig{{Name: "local", Type: "openai", BaseUrl: server.URL, Models: []string{"coder"}}},
+	}
+	defer func() { XConfig = nil }()
+	router := responsesRouter()
+
+	body := `{"model":"coder","stream":true,"store":false,
+		"tools":[{"type":"function","name":"weather","parameters":{"type":"object"}},{"type":"web_search"}],
+		"tool_choice":{"type":"function","name":"weather"},
+		"input":[
+			{"role":"user","content":"weather?"},
+			{"type":"function_call","call_id":"call_0","name":"weather","arguments":"{}"},
+			{"type":"function_call_output","call_id":"call_0","output":"sunny"}
+		]}`
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/v1/responses", strings.NewReader(body)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", w.Code, w.Body.String())
+	}
+
+	sent := server.bodies()[0]
+	if tools := sent["tools"].([]interface{}); len(tools) != 1 {
+		t.Fatalf("non-function tools should be dropped: %v", tools)
+	}
+	if choice := sent["tool_choice"].(map[string]interface{}); choice["function"].(map[string]interface{})["name"] != "weather" {
+		t.Fatalf("tool_choice not converted: %v", choice)
+	}
+	messages := sent["messages"].([]interface{})
+	if len(messages) != 3 || messages[1].(map[string]interface{})["tool_calls"] == nil || messages[2].(map[string]interface{})["tool_call_id"] != "call_0" {
+		t.Fatalf("function call items not converted: %v", messages)
+	}
+
+	var events []string
+	var completed map[string]interface{}
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(line, "event: ") {
+			events = append(events, strings.TrimPrefix(line, "event: "))
+		}
+		if strings.HasPrefix(line, "data: ") && strings.Contains(line, `"type":"response.completed"`) {
+			_ = json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &completed)
+		}
+	}
+	want := []string{
+		"response.created", "response.in_progress",
+		"response.output_item.added", "response.reasoning_summary_part.added", "response.reasoning_summary_text.delta",
+		"response.reasoning_summary_text.done", "response.reasoning_summary_part.done", "response.output_item.done",
+		"response.output_item.added", "response.function_call_arguments.delta", "response.function_call_arguments.done", "response.output_item.done",
+		"response.completed",
+	}
+	if strings.Join(events, ",") != strings.Join(want, ",") {
+		t.Fatalf("events = %v", events)
+	}
+	output := completed["response"].(map[string]interface{})["output"].([]interface{})
+	call := output[1].(map[string]interface{})
+	if len(output) != 2 || call["type"] != "function_call" || call["call_id"] != "call_1" || call["arguments"] != `{"city":"Paris"}` {
+		t.Fatalf("output = %v", output)
+	}
+	if _, ok := lookupResponse(completed["response"].(map[string]interface{})["id"].(string)); ok {
+		t.Fatal("store=false response should not be saved")
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 断线续传：开启 resume 后，OpenAI / Anthropic 的 SSE 流为每条事件分配 id（生成 id:序号），Ollama NDJSON 流通过响应头 X-Generation-Id 返回生成 id。
+// 输出先写入按生成保存的有界缓冲再转发给客户端；客户端断开后上游继续生成 grace 时长，期间带 Last-Event-ID
+// （NDJSON 客户端用 X-Resume-Generation: 生成id:已收到的行数）重新请求即可补发缺失的事件并继续接收。
+
+// resumeDefaultEvents 未配置 maxEvents 时每个生成保留的事件数
+const resumeDefaultEvents = 2000
+
+// errGenerationEvicted 需要补发的事件已被淘汰
+var errGenerationEvicted = errors.New("missed events are no longer buffered")
+
+// ResumeConfig resume 配置，grace 为 0 时不开启
+type ResumeConfig struct {
+	Grace     configDuration `json:"grace"`     // 客户端断开后上游继续生成、等待重连的时间；生成结束后缓冲同样保留这么久
+	MaxEvents int            `json:"maxEvents"` // 每个生成保留的事件数，默认 2000
+}
+
+var (
+	generationsMu sync.Mutex
+	generations   = map[string]*generation{}
+)
+
+// generation 一次流式生成的输出缓冲，没有客户端连接超过 grace 时取消上游请求
+type generation struct {
+	id string
+	// owner 发起生成的客户端摘要，只有同一客户端可以续传
+	owner     [sha256.Size]byte
+	grace     time.Duration
+	maxEvents int
+	cancel    context.CancelFunc
+	closed    chan struct{}
+
+	mu sync.Mutex
+	// mode 按第一次写出时的 Content-Type 确定：sse / ndjson，其它响应（如错误 JSON）不缓冲
+	mode   string
+	events [][]byte
+	// first 为 events[0] 的序号，序号从 1 开始
+	first       int
+	done        bool
+	subscribers int
+	timer       *time.Timer
+	// notify 有新事件或生成结束时关闭并替换
+	notify chan struct{}
+}
+
+// startGeneration 开启续传时接管客户端写回并把上游请求与客户端连接解绑，未开启时返回 nil；调用方在输出结束后调用 finish
+func startGeneration(c *gin.Context) *generation {
+	if XConfig == nil || XConfig.Resume.Grace <= 0 {
+		return nil
+	}
+	maxEvents := XConfig.Resume.MaxEvents
+	if maxEvents <= 0 {
+		maxEvents = resumeDefaultEvents
+	}
+	id, err := newGenerationID()
+	if err != nil {
+		log.Println("生成续传 id 失败，本次不开启续传:", err)
+		return nil
+	}
+	client := c.Request.Context()
+	ctx, cancel := context.WithCancel(context.WithoutCancel(client))
+	g := &generation{
+		id:          id,
+		owner:       generationOwner(c),
+		grace:       time.Duration(XConfig.Resume.Grace),
+		maxEvents:   maxEvents,
+		cancel:      cancel,
+		closed:      make(chan struct{}),
+		first:       1,
+		subscribers: 1,
+		notify:      make(chan struct{}),
+	}
+	generationsMu.Lock()
+	generations[g.id] = g
+	generationsMu.Unlock()
+
+	w := &resumableWriter{ResponseWriter: c.Writer, gen: g, attached: true}
+	c.Writer = w
+	c.Request = c.Request.WithContext(ctx)
+	c.Header("X-Generation-Id", g.id)
+	go func() {
+		select {
+		case <-client.Done():
+			w.detach()
+		case <-g.closed:
+		}
+	}()
+	return g
+}
+
+// newGenerationID 生成 id 是续传凭据的一部分，必须不可预测
+func newGenerationID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "gen_" + hex.EncodeToString(b), nil
+}
+
+// generationOwner 按客户端的鉴权信息计算摘要，没有鉴权信息时使用客户端 IP
+func generationOwner(c *gin.Context) [sha256.Size]byte {
+	key := ""
+	for _, name := range []string{"Authorization", "x-api-key", "x-goog-api-key", "api-key"} {
+		if v := c.GetHeader(name); v != "" {
+			key = name + ":" + v
+			break
+		}
+	}
+	if key == "" && c.Query("key") != "" {
+		key = "key:" + c.Query("key")
+	}
+	if key == "" {
+		key = "ip:" + c.ClientIP()
+	}
+	return sha256.Sum256([]byte(key))
+}
+
+func lookupGeneration(id string) *generation {
+	generationsMu.Lock()
+	defer generationsMu.Unlock()
+	return generations[id]
+}
+
+// finish 生成结束，唤醒等待的客户端；缓冲再保留 grace 时长供断开的客户端补取
+func (g *generation) finish() {
+	g.mu.Lock()
+	g.done = true
+	if g.timer != nil {
+		g.timer.Stop()
+	}
+	close(g.notify)
+	close(g.closed)
+	g.mu.Unlock()
+	g.cancel()
+	time.AfterFunc(g.grace, func() {
+		generationsMu.Lock()
+		delete(generations, g.id)
+		generationsMu.Unlock()
+	})
+}
+
+func (g *generation) join() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.subscribers++
+	if g.timer != nil {
+		g.timer.Stop()
+		g.timer = nil
+	}
+}
+
+// leave 最后一个客户端断开后开始计时，grace 内没有重连则取消上游请求
+func (g *generation) leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.subscribers--
+	if g.subscribers > 0 || g.done {
+		return
+	}
+	g.timer = time.AfterFunc(g.grace, g.cancel)
+}
+
+// record 缓冲一次写出并返回发给客户端的内容；SSE 事件前加上 id，注释与 ping 事件只转发不缓冲
+func (g *generation) record(contentType string, p []byte) []byte {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.mode == "" {
+		switch {
+		case strings.HasPrefix(contentType, "text/event-stream"):
+			g.mode = "sse"
+		case strings.HasPrefix(contentType, "application/x-ndjson"):
+			g.mode = "ndjson"
+		}
+	}
+	frame := p
+	switch g.mode {
+	case "":
+		return p
+	case "sse":
+		if bytes.HasPrefix(p, []byte(":")) || bytes.HasPrefix(p, []byte("event: ping\n")) {
+			return p
+		}
+		frame = append([]byte(fmt.Sprintf("id: %s:%d\n", g.id, g.first+len(g.events))), p...)
+	default:
+		frame = append([]byte{}, p...)
+	}
+	g.events = append(g.events, frame)
+	if len(g.events) > g.maxEvents {
+		g.events = g.events[1:]
+		g.first++
+	}
+	close(g.notify)
+	g.notify = make(chan struct{})
+	return frame
+}
+
+// since 返回序号大于 after 的事件以及等待后续事件的 channel
+func (g *generation) since(after int) ([][]byte, chan struct{}, bool, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if after < g.first-1 {
+		return nil, nil, false, errGenerationEvicted
+	}
+	start := after - g.first + 1
+	if start > len(g.events) {
+		start = len(g.events)
+	}
+	return g.events[start:], g.notify, g.done, nil
+}
+
+func (g *generation) streamMode() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.mode
+}
+
+// resumableWriter 生成的写回：所有输出先进入缓冲，客户端连接时同时转发；客户端写回失败不影响生成继续
+type resumableWriter struct {
+	gin.ResponseWriter
+	gen *generation
+
+	mu       sync.Mutex
+	attached bool
+}
+
+func (w *resumableWriter) Write(p []byte) (int, error) {
+	frame := w.gen.record(w.Header().Get("Content-Type"), p)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.attached {
+		if _, err := w.ResponseWriter.Write(frame); err != nil {
+			w.attached = false
+			w.gen.leave()
+		}
+	}
+	return len(p), nil
+}
+
+func (w *resumableWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *resumableWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.attached {
+		w.ResponseWriter.Flush()
+	}
+}
+
+func (w *resumableWriter) detach() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.attached {
+		w.attached = false
+		w.gen.leave()
+	}
+}
+
+// resumeGeneration 请求带 Last-Event-ID 或 X-Resume-Generation 时补发缺失的事件并继续转发到生成结束，返回请求是否已处理
+func resumeGeneration(c *gin.Context) bool {
+	key := c.GetHeader("Last-Event-ID")
+	if key == "" {
+		key = c.GetHeader("X-Resume-Generation")
+	}
+	if key == "" {
+		return false
+	}
+	id, seq, _ := strings.Cut(key, ":")
+	after, _ := strconv.Atoi(seq)
+	g := lookupGeneration(id)
+	// 其它客户端的生成按不存在处理，不暴露 id 是否有效
+	if owner := generationOwner(c); g == nil || subtle.ConstantTimeCompare(g.owner[:], owner[:]) != 1 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "generation not found or expired: " + id})
+		return true
+	}
+	g.join()
+	defer g.leave()
+
+	started := false
+	ticker := time.NewTicker(DefaultPingInterval)
+	defer ticker.Stop()
+	for {
+		frames, notify, done, err := g.since(after)
+		mode := g.streamMode()
+		if err != nil {
+			if !started {
+				c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+			}
+			return true
+		}
+		if !started && done && mode == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "generation has no stream output: " + id})
+			return true
+		}
+		if !started && (len(frames) > 0 || done) {
+			started = true
+			switch mode {
+			case "sse":
+				c.Header("content-Type", "text/event-stream")
+			case "ndjson":
+				c.Header("content-Type", "application/x-ndjson")
+			}
+			c.Header("cache-control", "no-cache")
+			c.Header("X-Generation-Id", g.id)
+			c.Status(http.StatusOK)
+		}
+		for _, frame := range frames {
+			if _, err := c.Writer.Write(frame); err != nil {
+				return true
+			}
+			after++
+		}
+		c.Writer.Flush()
+		if done {
+			return true
+		}
+		select {
+		case <-notify:
+		case <-c.Request.Context().Done():
+			return true
+		case <-ticker.C:
+			if started && mode == "sse" && PingData(c) != nil {
+				return true
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestResumeAfterDisconnect(t *testing.T) {
+	release := make(chan struct{})
+	finished := make(chan bool, 1)
+	upstream := newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		writeSSE(w, openaiContent("Hello, ", ""))
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			finished <- false
+			return
+		}
+		writeSSE(w, openaiContent("world.", "stop"), "[DONE]")
+		finished <- true
+	})
+	defer upstream.Close()
+	XConfig = &Config{
+		Providers: []ProviderConfig{{Name: "openai", Type: "openai", BaseUrl: upstream.URL, Models: []string{"gpt-test"}}},
+		Resume:    ResumeConfig{Grace: configDuration(5 * time.Second)},
+	}
+	defer func() { XConfig = nil }()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/chat/completions", OpenaiHandler)
+	proxy := httptest.NewServer(router)
+	defer proxy.Close()
+	body := `{"model":"gpt-test","stream":true,"messages":[{"role":"user","content":"greet"}]}`
+
+	// 收到第一条事件后断开
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "POST", proxy.URL+"/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer alice")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(resp.Body)
+	idLine, _ := reader.ReadString('\n')
+	dataLine, _ := reader.ReadString('\n')
+	lastID := strings.TrimPrefix(strings.TrimSpace(idLine), "id: ")
+	if !strings.HasPrefix(idLine, "id: gen_") || !strings.Contains(dataLine, "Hello") ||
+		!strings.HasPrefix(lastID, resp.Header.Get("X-Generation-Id")+":") {
+		t.Fatalf("first event = %q %q, generation %q", idLine, dataLine, resp.Header.Get("X-Generation-Id"))
+	}
+	cancel()
+	resp.Body.Close()
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	if ok := <-finished; !ok {
+		t.Fatal("upstream was cancelled within the grace period")
+	}
+
+	if !regexp.MustCompile(`^gen_[0-9a-f]{32}$`).MatchString(resp.Header.Get("X-Generation-Id")) {
+		t.Fatalf("generation id = %q", resp.Header.Get("X-Generation-Id"))
+	}
+
+	// 其它客户端拿到 id 也不能续传
+	req, _ = http.NewRequest("POST", proxy.URL+"/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer mallory")
+	req.Header.Set("Last-Event-ID", lastID)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("other client resumed: %d", resp.StatusCode)
+	}
+
+	// 带 Last-Event-ID 重连，只补发之后的事件
+	req, _ = http.NewRequest("POST", proxy.URL+"/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer alice")
+	req.Header.Set("Last-Event-ID", lastID)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if strings.Contains(string(replay), "Hello") || !strings.Contains(string(replay), "world.") ||
+		!strings.HasSuffix(string(replay), "data: [DONE]\n\n") {
+		t.Fatalf("replay:\n%s", replay)
+	}
+
+	req, _ = http.NewRequest("POST", proxy.URL+"/v1/chat/completions", strings.NewReader(body))
+	req.Header.Set("Last-Event-ID", "gen_unknown:3")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unknown generation: %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ignoredParamsHeader 上游不支持而被忽略（或被截断到上游允许范围）的采样参数，便于排查
+const ignoredParamsHeader = "X-Proxy-Ignored-Params"
+
+// samplingSupport 上游支持的采样参数与取值范围
+type samplingSupport struct {
+	MaxTemperature    float32 // 0 表示不支持 temperature
+	TopP              bool
+	TopK              bool
+	MaxTokens         bool
+	MaxStop           int // 0 不支持 stop，-1 不限数量
+	Seed              bool
+	Penalties         bool // presence_penalty / frequency_penalty
+	RepetitionPenalty bool
+}
+
+var (
+	openaiSampling = samplingSupport{MaxTemperature: 2, TopP: true, MaxTokens: true, MaxStop: 4, Seed: true, Penalties: true}
+	geminiSampling = samplingSupport{MaxTemperature: 2, TopP: true, TopK: true, MaxTokens: true, MaxStop: 5, Seed: true, Penalties: true}
+	// noSampling Dify 的模型参数在应用内配置，JetBrains 由 profile 决定，接口都不接受采样参数
+	noSampling = samplingSupport{}
+)
+
+// claudeSampling Anthropic 没有 seed 与惩罚项；开启思考时不能修改 top_k（temperature 固定为 1）
+func claudeSampling(req *ChatCompletionRequest) samplingSupport {
+	s := samplingSupport{MaxTemperature: 1, TopP: true, TopK: true, MaxTokens: true, MaxStop: -1}
+	if budget, ok := reasoningBudget(req); ok && budget > 0 {
+		s.TopK = false
+	}
+	return s
+}
+
+// providerSampling OpenAI 兼容上游，passSampling 时透传 top_k / repetition_penalty（vLLM、SGLang 等支持）
+func providerSampling(cfg *ProviderConfig) samplingSupport {
+	s := openaiSampling
+	if cfg != nil && cfg.PassSampling {
+		s.TopK = true
+		s.RepetitionPenalty = true
+		s.MaxStop = -1
+	}
+	return s
+}
+
+// adaptSampling 去掉上游不支持的参数并截断到允许范围，返回新请求和被忽略的参数名
+func adaptSampling(req *ChatCompletionRequest, s samplingSupport) (*ChatCompletionRequest, []string) {
+	out := *req
+	var ignored []string
+	if out.Temperature != nil {
+		switch {
+		case s.MaxTemperature == 0:
+			out.Temperature = nil
+			ignored = append(ignored, "temperature")
+		case *out.Temperature > s.MaxTemperature:
+			limit := s.MaxTemperature
+			out.Temperature = &limit
+			ignored = append(ignored, "temperature>"+strconv.FormatFloat(float64(s.MaxTemperature), 'f', -1, 32))
+		}
+	}
+	if out.TopP != 0 && !s.TopP {
+		out.TopP = 0
+		ignored = append(ignored, "top_p")
+	}
+	if out.TopK != nil && !s.TopK {
+		out.TopK = nil
+		ignored = append(ignored, "top_k")
+	}
+	if (out.MaxTokens != 0 || out.MaxCompletionTokens != 0) && !s.MaxTokens {
+		out.MaxTokens, out.MaxCompletionTokens = 0, 0
+		ignored = append(ignored, "max_tokens")
+	}
+	if len(out.Stop) > 0 {
+		switch {
+		case s.MaxStop == 0:
+			out.Stop = nil
+			ignored = append(ignored, "stop")
+		case s.MaxStop > 0 && len(out.Stop) > s.MaxStop:
+			out.Stop = out.Stop[:s.MaxStop]
+			ignored = append(ignored, "stop>"+strconv.Itoa(s.MaxStop))
+		}
+	}
+	if out.Seed != nil && !s.Seed {
+		out.Seed = nil
+		ignored = append(ignored, "seed")
+	}
+	if out.PresencePenalty != 0 && !s.Penalties {
+		out.PresencePenalty = 0
+		ignored = append(ignored, "presence_penalty")
+	}
+	if out.FrequencyPenalty != 0 && !s.Penalties {
+		out.FrequencyPenalty = 0
+		ignored = append(ignored, "frequency_penalty")
+	}
+	if out.RepetitionPenalty != nil && !s.RepetitionPenalty {
+		out.RepetitionPenalty = nil
+		ignored = append(ignored, "repetition_penalty")
+	}
+	return &out, ignored
+}
+
+// reportIgnoredParams 通过响应头告知客户端被忽略的参数
+func reportIgnoredParams(emit func(ChatEvent) error, ignored []string) error {
+	if len(ignored) == 0 {
+		return nil
+	}
+	header := http.Header{}
+	header.Set(ignoredParamsHeader, strings.Join(ignored, ", "))
+	return emit(ChatEvent{Header: header})
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdaptSampling(t *testing.T) {
+	topK, seed := 40, 7
+	penalty, temperature := float32(1.1), float32(1.5)
+	req := &ChatCompletionRequest{
+		Temperature:       &temperature,
+		TopP:              0.9,
+		TopK:              &topK,
+		MaxTokens:         100,
+		Stop:              []string{"a", "b", "c", "d", "e", "f"},
+		Seed:              &seed,
+		PresencePenalty:   0.5,
+		RepetitionPenalty: &penalty,
+	}
+	cases := []struct {
+		name    string
+		support samplingSupport
+		ignored []string
+	}{
+		{"openai", openaiSampling, []string{"top_k", "stop>4", "repetition_penalty"}},
+		{"gemini", geminiSampling, []string{"stop>5", "repetition_penalty"}},
+		{"claude", claudeSampling(req), []string{"temperature>1", "seed", "presence_penalty", "repetition_penalty"}},
+		{"passSampling", providerSampling(&ProviderConfig{PassSampling: true}), nil},
+		{"dify", noSampling, []string{"temperature", "top_p", "top_k", "max_tokens", "stop", "seed", "presence_penalty", "repetition_penalty"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			out, ignored := adaptSampling(req, tc.support)
+			if !reflect.DeepEqual(ignored, tc.ignored) {
+				t.Fatalf("ignored = %v", ignored)
+			}
+			if tc.support.MaxStop > 0 && len(out.Stop) != tc.support.MaxStop {
+				t.Fatalf("stop = %v", out.Stop)
+			}
+		})
+	}
+	if len(req.Stop) != 6 || req.TopK == nil {
+		t.Fatal("adaptSampling modified the original request")
+	}
+}
+
+func TestOllamaOptionsForwarded(t *testing.T) {
+	server := reasoningStandIn()
+	defer server.Close()
+
+	XConfig = &Config{
+		ChatType: "dify",
+		Providers: []ProviderConfig{
+			{Name: "openai", Type: "openai", BaseUrl: server.URL, Models: []string{"gpt-test"}},
+			{Name: "vllm", Type: "openai", BaseUrl: server.URL, Models: []string{"qwen-test"}, PassSampling: true},
+		},
+	}
+	defer func() { XConfig = nil }()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/chat", chatHandlerSteam)
+
+	for _, model := range []string{"gpt-test", "qwen-test"} {
+		body := `{"model":"` + model + `","stream":false,"messages":[{"role":"user","content":"?"}],` +
+			`"options":{"temperature":0,"top_k":20,"num_predict":64,"stop":["\n\n"],"seed":3,"repeat_penalty":1.2}}`
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/api/chat", strings.NewReader(body)))
+		if w.Code != 200 {
+			t.Fatalf("%s: %d %s", model, w.Code, w.Body.String())
+		}
+		bodies := server.bodies()
+		got := bodies[len(bodies)-1]
+		if temp, ok := got["temperature"].(float64); !ok || temp != 0 {
+			t.Fatalf("%s: explicit zero temperature lost: %v", model, got["temperature"])
+		}
+		if got["max_tokens"] != float64(64) || got["seed"] != float64(3) || !reflect.DeepEqual(got["stop"], []interface{}{"\n\n"}) {
+			t.Fatalf("%s: body = %v", model, got)
+		}
+		ignored := w.Header().Get(ignoredParamsHeader)
+		if model == "gpt-test" {
+			if got["top_k"] != nil || got["repetition_penalty"] != nil || ignored != "top_k, repetition_penalty" {
+				t.Fatalf("%s: top_k forwarded or not reported: %v %q", model, got, ignored)
+			}
+		} else if got["top_k"] != float64(20) || math.Abs(got["repetition_penalty"].(float64)-1.2) > 1e-6 || ignored != "" {
+			t.Fatalf("%s: passSampling body = %v, ignored = %q", model, got, ignored)
+		}
+	}
+}
+
+func TestSamplingToClaudeAndGemini(t *testing.T) {
+	// OpenAI 客户端明确传 0 时也要转发，未设置时不发送
+	req := &ChatCompletionRequest{}
+	if err := json.Unmarshal([]byte(`{"model":"m","messages":[{"role":"user","content":"?"}],"temperature":0,"top_p":0.8,"top_k":20,"max_tokens":64,"stop":["END"]}`), req); err != nil {
+		t.Fatal(err)
+	}
+	claude := ChatToClaudeRequest(req)
+	if claude.Temperature == nil || *claude.Temperature != 0 || claude.MaxTokens != 64 || claude.TopP != 0.8 ||
+		claude.TopK == nil || *claude.TopK != 20 || !reflect.DeepEqual(claude.StopSequences, []string{"END"}) {
+		data, _ := json.Marshal(claude)
+		t.Fatalf("claude = %s", data)
+	}
+	gemini := ChatToGeminiRequest(req, nil)
+	cfg := gemini.GenerationConfig
+	if cfg == nil || cfg.Temperature == nil || *cfg.Temperature != 0 || cfg.TopK == nil || *cfg.TopK != 20 || cfg.MaxOutputTokens != 64 {
+		data, _ := json.Marshal(gemini)
+		t.Fatalf("gemini = %s", data)
+	}
+
+	req.Temperature = nil
+	data, _ := json.Marshal(ChatToClaudeRequest(req))
+	if strings.Contains(string(data), `"temperature"`) {
+		t.Fatalf("unset temperature sent: %s", data)
+	}
+}
@@ -0,0 +1,164 @@
+// Package sse 按 WHATWG HTML 规范（9.2 Server-sent events）解析和输出事件流：
+// 支持 LF / CRLF / CR 换行、"data:" 后可省略空格、多行 data、event / id / retry 字段与注释行，单行长度不受 64KB 限制。
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// MaxLineSize 单行最大长度，超过时 Decoder 返回 bufio.ErrTooLong
+const MaxLineSize = 64 << 20
+
+// Event 一条 SSE 消息；Event 为空时按规范视为 message
+type Event struct {
+	Event string
+	ID    string
+	Retry int // 毫秒，0 表示未设置
+	Data  string
+}
+
+// Decoder 从上游响应体中逐条读取事件
+type Decoder struct {
+	scanner *bufio.Scanner
+	// lastID 按规范 id 在事件之间保持，直到被新的 id 字段覆盖
+	lastID string
+	first  bool
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), MaxLineSize)
+	scanner.Split(scanLines)
+	return &Decoder{scanner: scanner, first: true}
+}
+
+// LastEventID 最近一次收到的 id
+func (d *Decoder) LastEventID() string {
+	return d.lastID
+}
+
+// Next 返回下一条事件，流结束时返回 io.EOF。没有 data 的事件块按规范不派发（只更新 id / retry）；
+// 流末尾缺少空行的最后一条事件仍会返回，不少上游在结束时不写空行
+func (d *Decoder) Next() (Event, error) {
+	var ev Event
+	var data strings.Builder
+	hasData := false
+	for d.scanner.Scan() {
+		line := d.scanner.Text()
+		if d.first {
+			d.first = false
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if line == "" {
+			if hasData {
+				ev.ID = d.lastID
+				ev.Data = strings.TrimSuffix(data.String(), "\n")
+				return ev, nil
+			}
+			ev = Event{}
+			continue
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		field, value, found := strings.Cut(line, ":")
+		if found {
+			value = strings.TrimPrefix(value, " ")
+		}
+		switch field {
+		case "event":
+			ev.Event = value
+		case "data":
+			hasData = true
+			data.WriteString(value)
+			data.WriteByte('\n')
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				d.lastID = value
+			}
+		case "retry":
+			if isDigits(value) {
+				ev.Retry, _ = strconv.Atoi(value)
+			}
+		}
+	}
+	if err := d.scanner.Err(); err != nil {
+		return Event{}, err
+	}
+	if hasData {
+		ev.ID = d.lastID
+		ev.Data = strings.TrimSuffix(data.String(), "\n")
+		return ev, nil
+	}
+	return Event{}, io.EOF
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// scanLines 按 CRLF、LF 或单独的 CR 切分行；CR 位于缓冲末尾时多读一段，确认后面是否跟着 LF
+func scanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		if data[i] == '\n' {
+			return i + 1, data[:i], nil
+		}
+		if i+1 < len(data) {
+			if data[i+1] == '\n' {
+				return i + 2, data[:i], nil
+			}
+			return i + 1, data[:i], nil
+		}
+		if atEOF {
+			return i + 1, data[:i], nil
+		}
+		return 0, nil, nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// Encode 写出一条事件；多行 data 拆成多个 data 字段，CR 统一为换行
+func Encode(w io.Writer, ev Event) error {
+	var b strings.Builder
+	if ev.ID != "" {
+		b.WriteString("id: " + singleLine(ev.ID) + "\n")
+	}
+	if ev.Event != "" {
+		b.WriteString("event: " + singleLine(ev.Event) + "\n")
+	}
+	if ev.Retry > 0 {
+		b.WriteString("retry: " + strconv.Itoa(ev.Retry) + "\n")
+	}
+	data := strings.ReplaceAll(strings.ReplaceAll(ev.Data, "\r\n", "\n"), "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// EncodeComment 写出注释行，常用作保活
+func EncodeComment(w io.Writer, comment string) error {
+	_, err := io.WriteString(w, ": "+singleLine(comment)+"\n\n")
+	return err
+}
+
+func singleLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
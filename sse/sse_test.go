@@ -0,0 +1,109 @@
+package sse
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func decodeAll(r io.Reader) ([]Event, error) {
+	d := NewDecoder(r)
+	var events []Event
+	for {
+		ev, err := d.Next()
+		if err == io.EOF {
+			return events, nil
+		}
+		if err != nil {
+			return events, err
+		}
+		events = append(events, ev)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	long := strings.Repeat("x", 200<<10)
+	cases := []struct {
+		name  string
+		input string
+		want  []Event
+	}{
+		{"space after colon", "data: {\"a\":1}\n\n", []Event{{Data: `{"a":1}`}}},
+		{"no space", "data:{\"a\":1}\n\n", []Event{{Data: `{"a":1}`}}},
+		{"only one space stripped", "data:  x\n\n", []Event{{Data: " x"}}},
+		{"multi-line data", "data: a\ndata: b\ndata\n\n", []Event{{Data: "a\nb\n"}}},
+		{"event name", "event: message_start\ndata: {}\n\nevent: ping\ndata: {}\n\n",
+			[]Event{{Event: "message_start", Data: "{}"}, {Event: "ping", Data: "{}"}}},
+		{"crlf", "event: x\r\ndata: 1\r\n\r\ndata: 2\r\n\r\n", []Event{{Event: "x", Data: "1"}, {Data: "2"}}},
+		{"cr only", "data: 1\r\rdata: 2\r\r", []Event{{Data: "1"}, {Data: "2"}}},
+		{"comments and unknown fields", ": PING\n\nfoo: bar\ndata: x\n\n", []Event{{Data: "x"}}},
+		{"id persists", "id: 7\ndata: a\n\ndata: b\n\nid\ndata: c\n\n", []Event{{ID: "7", Data: "a"}, {ID: "7", Data: "b"}, {Data: "c"}}},
+		{"retry", "retry: 3000\ndata: a\n\nretry: soon\ndata: b\n\n", []Event{{Retry: 3000, Data: "a"}, {Data: "b"}}},
+		{"event without data is dropped", "event: ping\n\ndata: a\n\n", []Event{{Data: "a"}}},
+		{"bom", "\ufeffdata: a\n\n", []Event{{Data: "a"}}},
+		{"missing trailing blank line", "data: a\n\ndata: b", []Event{{Data: "a"}, {Data: "b"}}},
+		{"done marker", "data: [DONE]\n\n", []Event{{Data: "[DONE]"}}},
+		{"long line", "data: " + long + "\n\n", []Event{{Data: long}}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := decodeAll(strings.NewReader(tc.input))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("got %+v", got)
+			}
+			if len(tc.input) > 1<<10 {
+				return
+			}
+			// 逐字节读取时 CRLF 被拆开也要得到同样结果
+			got, err = decodeAll(iotest.OneByteReader(strings.NewReader(tc.input)))
+			if err != nil || !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("one byte reader: got %+v, %v", got, err)
+			}
+		})
+	}
+}
+
+func TestDecoderLineTooLong(t *testing.T) {
+	input := "data: " + strings.Repeat("x", MaxLineSize) + "\n\n"
+	if _, err := decodeAll(strings.NewReader(input)); !errors.Is(err, bufio.ErrTooLong) {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	cases := []struct {
+		ev   Event
+		want string
+	}{
+		{Event{Data: `{"a":1}`}, "data: {\"a\":1}\n\n"},
+		{Event{Event: "message_stop", Data: "{}"}, "event: message_stop\ndata: {}\n\n"},
+		{Event{ID: "3", Retry: 1000, Data: "a\r\nb\rc"}, "id: 3\nretry: 1000\ndata: a\ndata: b\ndata: c\n\n"},
+		{Event{Event: "bad\nname", Data: ""}, "event: badname\ndata: \n\n"},
+	}
+	for _, tc := range cases {
+		var b strings.Builder
+		if err := Encode(&b, tc.ev); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != tc.want {
+			t.Errorf("Encode(%+v) = %q", tc.ev, b.String())
+		}
+		// 编码结果能原样解析回来（CR 会统一为换行）
+		got, _ := decodeAll(strings.NewReader(b.String()))
+		if len(got) != 1 || got[0].Event != singleLine(tc.ev.Event) {
+			t.Errorf("round trip %+v = %+v", tc.ev, got)
+		}
+	}
+	var b strings.Builder
+	_ = EncodeComment(&b, "PING")
+	if b.String() != ": PING\n\n" {
+		t.Errorf("comment = %q", b.String())
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+
+	"ollamaproxy/sse"
+)
+
+// standIn 测试共用的上游替身：按顺序记录收到的请求，响应内容由各测试的 reply 决定
+type standIn struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests []standInRequest
+}
+
+// standInRequest 替身收到的一个请求，URI 含查询参数
+type standInRequest struct {
+	Method string
+	Path   string
+	URI    string
+	Header http.Header
+	Body   []byte
+}
+
+// newStandIn reply 写出第 n 个请求（从 1 开始）的响应，r.Body 仍可读取
+func newStandIn(reply func(w http.ResponseWriter, r *http.Request, n int)) *standIn {
+	s := &standIn{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		s.mu.Lock()
+		s.requests = append(s.requests, standInRequest{Method: r.Method, Path: r.URL.Path, URI: r.URL.RequestURI(), Header: r.Header.Clone(), Body: body})
+		n := len(s.requests)
+		s.mu.Unlock()
+		reply(w, r, n)
+	}))
+	return s
+}
+
+// reset 清空记录，之后的请求重新从 1 计数
+func (s *standIn) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+}
+
+func (s *standIn) received() []standInRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]standInRequest(nil), s.requests...)
+}
+
+func (s *standIn) paths() []string {
+	var paths []string
+	for _, r := range s.received() {
+		paths = append(paths, r.Path)
+	}
+	return paths
+}
+
+// bodies 请求体解码为 map
+func (s *standIn) bodies() []map[string]interface{} {
+	return standInBodies[map[string]interface{}](s)
+}
+
+// standInBodies 请求体按给定类型解码
+func standInBodies[T any](s *standIn) []T {
+	var bodies []T
+	for _, r := range s.received() {
+		var body T
+		_ = json.Unmarshal(r.Body, &body)
+		bodies = append(bodies, body)
+	}
+	return bodies
+}
+
+// writeSSE 以 SSE 写出 data 事件并刷新
+func writeSSE(w http.ResponseWriter, data ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, d := range data {
+		_ = sse.Encode(w, sse.Event{Data: d})
+	}
+	w.(http.Flusher).Flush()
+}
+
+// writeClaudeSSE 写出 Anthropic 事件，事件名取自 data 的 type 字段
+func writeClaudeSSE(w http.ResponseWriter, data ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, d := range data {
+		var ev struct {
+			Type string `json:"type"`
+		}
+		_ = json.Unmarshal([]byte(d), &ev)
+		_ = sse.Encode(w, sse.Event{Event: ev.Type, Data: d})
+	}
+	w.(http.Flusher).Flush()
+}
+
+// openaiChunk OpenAI 流式分片，delta 为 JSON 对象，finish 为空时不结束
+func openaiChunk(delta, finish string) string {
+	if finish == "" {
+		return fmt.Sprintf(`{"choices":[{"index":0,"delta":%s}]}`, delta)
+	}
+	return fmt.Sprintf(`{"choices":[{"index":0,"delta":%s,"finish_reason":%q}]}`, delta, finish)
+}
+
+// openaiContent 只带文本的 OpenAI 流式分片
+func openaiContent(content, finish string) string {
+	text, _ := json.Marshal(content)
+	return openaiChunk(`{"content":`+string(text)+`}`, finish)
+}
+
+// openaiUsage OpenAI 流末尾的用量分片
+func openaiUsage(prompt, completion int) string {
+	return fmt.Sprintf(`{"choices":[],"usage":{"prompt_tokens":%d,"completion_tokens":%d,"total_tokens":%d}}`, prompt, completion, prompt+completion)
+}
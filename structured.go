@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// 结构化输出：response_format 为 json_object / json_schema（Ollama 为 format）时，OpenAI 兼容上游与 Gemini 原生支持；
+// Anthropic、Bedrock、Dify、JetBrains 不支持，在提示词中加入格式要求，代理侧校验返回的 JSON，
+// 不符合时带上校验错误重新请求，最多 structuredOutputAttempts 次。
+
+const structuredOutputAttempts = 3
+
+// structuredOutputRequested 请求要求输出 JSON
+func structuredOutputRequested(req *ChatCompletionRequest) bool {
+	return req.ResponseFormat != nil && (req.ResponseFormat.Type == "json_object" || req.ResponseFormat.Type == "json_schema")
+}
+
+// jsonSchemaFormat schema 包装为 json_schema 格式，Ollama format 与 Gemini responseSchema 没有名称
+func jsonSchemaFormat(schema json.RawMessage) *ChatCompletionResponseFormat {
+	return &ChatCompletionResponseFormat{
+		Type:       "json_schema",
+		JSONSchema: &ChatCompletionResponseFormatJSONSchema{Name: "response", Schema: schema, Strict: true},
+	}
+}
+
+// formatSchema json_schema 中的 schema，json_object 或未提供 schema 时为 nil
+func formatSchema(format *ChatCompletionResponseFormat) (interface{}, error) {
+	if format == nil || format.Type != "json_schema" || format.JSONSchema == nil || len(format.JSONSchema.Schema) == 0 {
+		return nil, nil
+	}
+	var schema interface{}
+	if err := json.Unmarshal(format.JSONSchema.Schema, &schema); err != nil {
+		return nil, fmt.Errorf("invalid json_schema.schema: %v", err)
+	}
+	return schema, nil
+}
+
+func structuredOutputInstruction(format *ChatCompletionResponseFormat) string {
+	text := "Respond with a single JSON value only. Do not wrap it in markdown code fences and do not add any other text."
+	if format.JSONSchema != nil && len(format.JSONSchema.Schema) > 0 {
+		text += " The JSON must conform to this JSON Schema:\n" + string(format.JSONSchema.Schema)
+	}
+	return text
+}
+
+// emulateStructuredOutput 不支持结构化输出的后端在 ChatStream 开头调用：去掉 response_format 后由 provider 完成对话，
+// 输出缓冲到校验通过后再一次发出
+func emulateStructuredOutput(ctx context.Context, provider ChatProvider, req *ChatCompletionRequest, emit func(ChatEvent) error) error {
+	schema, err := formatSchema(req.ResponseFormat)
+	if err != nil {
+		return &UpstreamError{StatusCode: http.StatusBadRequest, Body: err.Error()}
+	}
+	inner := *req
+	inner.ResponseFormat = nil
+	// 格式要求放在原有 system 消息之后
+	system := 0
+	for system < len(req.Messages) && (req.Messages[system].Role == ChatMessageRoleSystem || req.Messages[system].Role == ChatMessageRoleDeveloper) {
+		system++
+	}
+	inner.Messages = make([]ChatCompletionMessage, 0, len(req.Messages)+1)
+	inner.Messages = append(inner.Messages, req.Messages[:system]...)
+	inner.Messages = append(inner.Messages, ChatCompletionMessage{Role: ChatMessageRoleSystem, Content: structuredOutputInstruction(req.ResponseFormat)})
+	inner.Messages = append(inner.Messages, req.Messages[system:]...)
+
+	var usage *Usage
+	for attempt := 1; ; attempt++ {
+		result := chatResult{}
+		var streamed []ChatEvent
+		err := provider.ChatStream(ctx, &inner, func(ev ChatEvent) error {
+			result.add(ev)
+			streamed = append(streamed, ev)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		var attemptUsage *Usage
+		for _, ev := range streamed {
+			if ev.Usage != nil {
+				attemptUsage = ev.Usage
+			}
+		}
+		if attemptUsage != nil {
+			usage = addUsage(usage, attemptUsage)
+		}
+		// 模型选择调用工具时不是最终回答，原样输出
+		if len(result.ToolCalls) > 0 {
+			for _, ev := range streamed {
+				if ev.Usage != nil {
+					ev.Usage = usage
+				}
+				if err := emit(ev); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		text := stripCodeFence(result.Content)
+		problems := validateJSONText(text, schema)
+		if len(problems) == 0 {
+			if len(result.Header) > 0 {
+				if err := emit(ChatEvent{Header: result.Header}); err != nil {
+					return err
+				}
+			}
+			return emit(ChatEvent{
+				Reasoning:           result.Reasoning,
+				Content:             text,
+				FinishReason:        result.FinishReason,
+				Usage:               usage,
+				ContentFilter:       result.ContentFilter,
+				PromptFilterResults: result.PromptFilterResults,
+			})
+		}
+		if attempt >= structuredOutputAttempts {
+			return &UpstreamError{
+				StatusCode: http.StatusUnprocessableEntity,
+				Body:       fmt.Sprintf("model output did not match response_format after %d attempts: %s", attempt, strings.Join(problems, "; ")),
+			}
+		}
+		// 上一次的回答放在追加的 user 消息里而不是单独的 assistant 轮次，只发送最后一轮用户输入的 Dify 也能拿到完整上下文
+		inner.Messages = append(inner.Messages[:len(inner.Messages):len(inner.Messages)], ChatCompletionMessage{
+			Role: ChatMessageRoleUser,
+			Content: "A previous reply to this request was rejected.\nReply:\n" + result.Content + "\nProblems:\n- " + strings.Join(problems, "\n- ") +
+				"\nReply again with only the corrected JSON.",
+		})
+	}
+}
+
+// stripCodeFence 去掉模型常加的 ```json 代码块包裹
+func stripCodeFence(text string) string {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, "```") || !strings.HasSuffix(text, "```") || len(text) < 6 {
+		return text
+	}
+	text = strings.TrimSuffix(text[3:], "```")
+	if i := strings.IndexByte(text, '\n'); i >= 0 && !strings.ContainsAny(text[:i], "{[\"") {
+		text = text[i+1:]
+	}
+	return strings.TrimSpace(text)
+}
+
+// validateJSONText 校验文本是合法 JSON 且符合 schema（schema 为 nil 时只要求是 JSON 对象）
+func validateJSONText(text string, schema interface{}) []string {
+	var value interface{}
+	if err := json.Unmarshal([]byte(text), &value); err != nil {
+		return []string{"reply is not valid JSON: " + err.Error()}
+	}
+	if schema == nil {
+		if _, ok := value.(map[string]interface{}); !ok {
+			return []string{"$: expected a JSON object"}
+		}
+		return nil
+	}
+	v := schemaValidator{root: schema}
+	v.validate(value, schema, "$")
+	return v.problems
+}
+
+// maxSchemaProblems 校验错误会回传给模型，数量过多时只保留前面的
+const maxSchemaProblems = 20
+
+// schemaValidator JSON Schema 常用关键字的校验：type、enum、const、properties、required、additionalProperties、
+// items、prefixItems、长度与数值范围、pattern、anyOf / oneOf / allOf / not，以及文档内的 $ref
+type schemaValidator struct {
+	root     interface{}
+	problems []string
+	depth    int
+}
+
+func (v *schemaValidator) fail(path, format string, args ...interface{}) {
+	if len(v.problems) < maxSchemaProblems {
+		v.problems = append(v.problems, path+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+// matches 子 schema 是否通过，不记录错误，用于 anyOf / oneOf / not
+func (v *schemaValidator) matches(value, schema interface{}) bool {
+	sub := schemaValidator{root: v.root, depth: v.depth}
+	sub.validate(value, schema, "$")
+	return len(sub.problems) == 0
+}
+
+func (v *schemaValidator) resolve(ref string) (interface{}, bool) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, false
+	}
+	node := v.root
+	for _, token := range strings.Split(strings.TrimPrefix(ref, "#"), "/") {
+		if token == "" {
+			continue
+		}
+		token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if node, ok = m[token]; !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+func (v *schemaValidator) validate(value, schema interface{}, path string) {
+	if b, ok := schema.(bool); ok {
+		if !b {
+			v.fail(path, "no value is allowed here")
+		}
+		return
+	}
+	s, ok := schema.(map[string]interface{})
+	if !ok {
+		return
+	}
+	if ref, ok := s["$ref"].(string); ok {
+		target, found := v.resolve(ref)
+		if !found || v.depth > 32 {
+			v.fail(path, "cannot resolve $ref %s", ref)
+			return
+		}
+		v.depth++
+		v.validate(value, target, path)
+		v.depth--
+	}
+	if t, ok := s["type"]; ok && !matchesType(value, t) {
+		v.fail(path, "expected %s, got %s", typeNames(t), jsonTypeName(value))
+		return
+	}
+	if enum, ok := s["enum"].([]interface{}); ok {
+		found := false
+		for _, candidate := range enum {
+			if jsonEqual(value, candidate) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			data, _ := json.Marshal(enum)
+			v.fail(path, "must be one of %s", data)
+		}
+	}
+	if c, ok := s["const"]; ok && !jsonEqual(value, c) {
+		data, _ := json.Marshal(c)
+		v.fail(path, "must equal %s", data)
+	}
+	for _, sub := range schemaList(s["allOf"]) {
+		v.validate(value, sub, path)
+	}
+	if list := schemaList(s["anyOf"]); len(list) > 0 {
+		matched := false
+		for _, sub := range list {
+			if v.matches(value, sub) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.fail(path, "does not match any of the allowed schemas (anyOf)")
+		}
+	}
+	if list := schemaList(s["oneOf"]); len(list) > 0 {
+		count := 0
+		for _, sub := range list {
+			if v.matches(value, sub) {
+				count++
+			}
+		}
+		if count != 1 {
+			v.fail(path, "must match exactly one schema in oneOf, matched %d", count)
+		}
+	}
+	if not, ok := s["not"]; ok && v.matches(value, not) {
+		v.fail(path, "must not match the schema in not")
+	}
+
+	switch val := value.(type) {
+	case map[string]interface{}:
+		v.validateObject(val, s, path)
+	case []interface{}:
+		v.validateArray(val, s, path)
+	case string:
+		length := len([]rune(val))
+		if min, ok := s["minLength"].(float64); ok && float64(length) < min {
+			v.fail(path, "must be at least %v characters", min)
+		}
+		if max, ok := s["maxLength"].(float64); ok && float64(length) > max {
+			v.fail(path, "must be at most %v characters", max)
+		}
+		if pattern, ok := s["pattern"].(string); ok {
+			if re, err := regexp.Compile(pattern); err == nil && !re.MatchString(val) {
+				v.fail(path, "must match pattern %s", pattern)
+			}
+		}
+	case float64:
+		if min, ok := s["minimum"].(float64); ok && val < min {
+			v.fail(path, "must be >= %v", min)
+		}
+		if max, ok := s["maximum"].(float64); ok && val > max {
+			v.fail(path, "must be <= %v", max)
+		}
+		if min, ok := s["exclusiveMinimum"].(float64); ok && val <= min {
+			v.fail(path, "must be > %v", min)
+		}
+		if max, ok := s["exclusiveMaximum"].(float64); ok && val >= max {
+			v.fail(path, "must be < %v", max)
+		}
+		if m, ok := s["multipleOf"].(float64); ok && m > 0 {
+			if q := val / m; math.Abs(q-math.Round(q)) > 1e-9 {
+				v.fail(path, "must be a multiple of %v", m)
+			}
+		}
+	}
+}
+
+func (v *schemaValidator) validateObject(obj map[string]interface{}, s map[string]interface{}, path string) {
+	if required, ok := s["required"].([]interface{}); ok {
+		for _, name := range required {
+			if key, ok := name.(string); ok {
+				if _, present := obj[key]; !present {
+					v.fail(path, "missing required property %q", key)
+				}
+			}
+		}
+	}
+	properties, _ := s["properties"].(map[string]interface{})
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		child := path + "." + key
+		if sub, ok := properties[key]; ok {
+			v.validate(obj[key], sub, child)
+			continue
+		}
+		switch extra := s["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				v.fail(path, "unexpected property %q", key)
+			}
+		case map[string]interface{}:
+			v.validate(obj[key], extra, child)
+		}
+	}
+	if min, ok := s["minProperties"].(float64); ok && float64(len(obj)) < min {
+		v.fail(path, "must have at least %v properties", min)
+	}
+	if max, ok := s["maxProperties"].(float64); ok && float64(len(obj)) > max {
+		v.fail(path, "must have at most %v properties", max)
+	}
+}
+
+func (v *schemaValidator) validateArray(arr []interface{}, s map[string]interface{}, path string) {
+	prefix := schemaList(s["prefixItems"])
+	for i, item := range arr {
+		child := fmt.Sprintf("%s[%d]", path, i)
+		if i < len(prefix) {
+			v.validate(item, prefix[i], child)
+		} else if items, ok := s["items"]; ok {
+			v.validate(item, items, child)
+		}
+	}
+	if min, ok := s["minItems"].(float64); ok && float64(len(arr)) < min {
+		v.fail(path, "must have at least %v items", min)
+	}
+	if max, ok := s["maxItems"].(float64); ok && float64(len(arr)) > max {
+		v.fail(path, "must have at most %v items", max)
+	}
+	if unique, _ := s["uniqueItems"].(bool); unique {
+		for i := range arr {
+			for j := i + 1; j < len(arr); j++ {
+				if jsonEqual(arr[i], arr[j]) {
+					v.fail(path, "items %d and %d are equal, items must be unique", i, j)
+					return
+				}
+			}
+		}
+	}
+}
+
+func schemaList(v interface{}) []interface{} {
+	list, _ := v.([]interface{})
+	return list
+}
+
+func matchesType(value, t interface{}) bool {
+	switch t := t.(type) {
+	case string:
+		name := jsonTypeName(value)
+		return name == t || (t == "number" && name == "integer")
+	case []interface{}:
+		for _, item := range t {
+			if matchesType(value, item) {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+func typeNames(t interface{}) string {
+	if list, ok := t.([]interface{}); ok {
+		names := make([]string, 0, len(list))
+		for _, item := range list {
+			names = append(names, fmt.Sprint(item))
+		}
+		return strings.Join(names, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+func jsonTypeName(value interface{}) string {
+	switch val := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if val == math.Trunc(val) {
+			return "integer"
+		}
+		return "number"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func jsonEqual(a, b interface{}) bool {
+	x, _ := json.Marshal(a)
+	y, _ := json.Marshal(b)
+	return string(x) == string(y)
+}
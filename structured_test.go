@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testPersonSchema = `{
+	"type": "object",
+	"properties": {
+		"name": {"type": "string", "minLength": 1},
+		"age": {"type": "integer", "minimum": 0},
+		"tags": {"type": "array", "items": {"$ref": "#/$defs/tag"}}
+	},
+	"required": ["name", "age"],
+	"additionalProperties": false,
+	"$defs": {"tag": {"enum": ["a", "b"]}}
+}`
+
+func TestValidateJSONText(t *testing.T) {
+	var schema interface{}
+	if err := json.Unmarshal([]byte(testPersonSchema), &schema); err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		text     string
+		problems []string
+	}{
+		{`{"name":"Ann","age":3,"tags":["a"]}`, nil},
+		{`{"name":"Ann"}`, []string{`$: missing required property "age"`}},
+		{`{"name":"","age":1.5}`, []string{"$.age: expected integer, got number", "$.name: must be at least 1 characters"}},
+		{`{"name":"Ann","age":3,"tags":["c"],"x":1}`, []string{`$.tags[0]: must be one of ["a","b"]`, `$: unexpected property "x"`}},
+		{`[1]`, []string{"$: expected object, got array"}},
+	}
+	for _, tc := range cases {
+		problems := validateJSONText(tc.text, schema)
+		if strings.Join(problems, "|") != strings.Join(tc.problems, "|") {
+			t.Errorf("%s: problems = %q", tc.text, problems)
+		}
+	}
+	if problems := validateJSONText(`not json`, nil); len(problems) != 1 {
+		t.Errorf("invalid json: %q", problems)
+	}
+	if got := stripCodeFence("```json\n{\"a\":1}\n```"); got != `{"a":1}` {
+		t.Errorf("stripCodeFence = %q", got)
+	}
+}
+
+// claudeReplies 模拟 Anthropic 依次返回给定文本，用完后重复最后一条
+func claudeReplies(replies []string) *standIn {
+	return newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		text, _ := json.Marshal(replies[min(n, len(replies))-1])
+		writeClaudeSSE(w,
+			`{"type":"message_start","message":{"usage":{"input_tokens":10}}}`,
+			`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":`+string(text)+`}}`,
+			`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":5}}`)
+	})
+}
+
+func TestStructuredOutputEmulation(t *testing.T) {
+	server := claudeReplies([]string{`{"name":"Ann"}`, "```json\n{\"name\":\"Ann\",\"age\":3}\n```"})
+	defer server.Close()
+	XConfig = &Config{ChatType: "claude", APIURL: server.URL}
+	defer func() { XConfig = nil }()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/chat/completions", OpenaiHandler)
+
+	schema, _ := json.Marshal(json.RawMessage(testPersonSchema))
+	body := `{"model":"claude-test","messages":[{"role":"system","content":"be brief"},{"role":"user","content":"who?"}],` +
+		`"response_format":{"type":"json_schema","json_schema":{"name":"person","schema":` + string(schema) + `}}}`
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body)))
+	var out ChatCompletionResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &out)
+	if w.Code != 200 || out.Choices[0].Message.Content != `{"name":"Ann","age":3}` {
+		t.Fatalf("%d %s", w.Code, w.Body.String())
+	}
+	if out.Usage.PromptTokens != 20 || out.Usage.CompletionTokens != 10 {
+		t.Fatalf("usage = %+v", out.Usage)
+	}
+	bodies := standInBodies[ClaudeRequest](server)
+	if len(bodies) != 2 || !strings.HasPrefix(bodies[0].System, "be brief\n\nRespond with a single JSON value") {
+		t.Fatalf("system = %q", bodies[0].System)
+	}
+	retry := bodies[1].Messages[len(bodies[1].Messages)-1]
+	if retry.Role != "user" || !strings.Contains(retry.Content[len(retry.Content)-1].Text, `missing required property "age"`) {
+		t.Fatalf("retry = %+v", retry)
+	}
+
+	// 始终不符合时返回错误
+	refusing := claudeReplies([]string{"sorry"})
+	defer refusing.Close()
+	XConfig.APIURL = refusing.URL
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body)))
+	if attempts := len(refusing.received()); w.Code != http.StatusUnprocessableEntity || attempts != structuredOutputAttempts {
+		t.Fatalf("%d after %d attempts: %s", w.Code, attempts, w.Body.String())
+	}
+}
+
+func TestOllamaFormatForwarded(t *testing.T) {
+	server := reasoningStandIn()
+	defer server.Close()
+	XConfig = &Config{
+		ChatType:  "dify",
+		Providers: []ProviderConfig{{Name: "openai", Type: "openai", BaseUrl: server.URL, Models: []string{"gpt-test"}}},
+	}
+	defer func() { XConfig = nil }()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/chat", chatHandlerSteam)
+
+	for format, want := range map[string]string{
+		`"json"`: `{"type":"json_object"}`,
+		`{"type":"object","properties":{"a":{"type":"string"}}}`: `{"json_schema":{"name":"response","schema":{"properties":{"a":{"type":"string"}},"type":"object"},"strict":true},"type":"json_schema"}`,
+	} {
+		body := `{"model":"gpt-test","stream":false,"messages":[{"role":"user","content":"?"}],"format":` + format + `}`
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/api/chat", strings.NewReader(body)))
+		bodies := server.bodies()
+		got, _ := json.Marshal(bodies[len(bodies)-1]["response_format"])
+		if string(got) != want {
+			t.Fatalf("format %s: response_format = %s", format, got)
+		}
+	}
+}
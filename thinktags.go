@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// <think> 标签与结构化思考内容的互相转换：
+//   - parse：Qwen、R1 蒸馏等上游把思考写在 content 的 <think>…</think> 中，拆出来放到 reasoning
+//   - inline：部分 IDE 不认识 reasoning_content，把思考内容以 <think> 标签写回 content
+// 按 thinkTags 规则（入站路径、User-Agent、模型）逐请求决定，第一条匹配的规则生效。
+
+const (
+	thinkTagParse  = "parse"
+	thinkTagInline = "inline"
+
+	thinkOpenTag  = "<think>"
+	thinkCloseTag = "</think>"
+)
+
+// ThinkTagRule thinkTags 配置项，未填写的条件视为匹配
+type ThinkTagRule struct {
+	Route     string `json:"route"`     // 入站路径前缀，如 /imgreduce/openai
+	UserAgent string `json:"userAgent"` // User-Agent 包含的字符串，不区分大小写
+	Model     string `json:"model"`     // 请求模型名包含的字符串，不区分大小写
+	Mode      string `json:"mode"`      // parse / inline
+}
+
+func (r *ThinkTagRule) match(path, userAgent, model string) bool {
+	return requestRuleMatch(r.Route, r.UserAgent, r.Model, path, userAgent, model)
+}
+
+// requestRuleMatch 按入站路径前缀、User-Agent 与模型名匹配请求，thinkTags、continuation 等按请求生效的规则共用
+func requestRuleMatch(route, userAgentPart, modelPart, path, userAgent, model string) bool {
+	if route != "" && !strings.HasPrefix(path, route) {
+		return false
+	}
+	if userAgentPart != "" && !strings.Contains(strings.ToLower(userAgent), strings.ToLower(userAgentPart)) {
+		return false
+	}
+	if modelPart != "" && !strings.Contains(strings.ToLower(model), strings.ToLower(modelPart)) {
+		return false
+	}
+	return true
+}
+
+// thinkTagMode 当前请求适用的转换方式，没有匹配的规则时为空
+func thinkTagMode(c *gin.Context, model string) string {
+	if XConfig == nil {
+		return ""
+	}
+	for i := range XConfig.ThinkTags {
+		rule := &XConfig.ThinkTags[i]
+		if rule.match(c.Request.URL.Path, c.Request.UserAgent(), model) {
+			return rule.Mode
+		}
+	}
+	return ""
+}
+
+// withThinkTags 按当前请求的规则包装后端
+func withThinkTags(c *gin.Context, model string, provider ChatProvider) ChatProvider {
+	return withThinkTagMode(provider, thinkTagMode(c, model))
+}
+
+func withThinkTagMode(provider ChatProvider, mode string) ChatProvider {
+	switch mode {
+	case thinkTagParse, thinkTagInline:
+		return &thinkTagProvider{Inner: provider, Mode: mode}
+	default:
+		return provider
+	}
+}
+
+// thinkTagProvider 在后端输出上做标签转换，结束事件之前先输出缓冲中的内容
+type thinkTagProvider struct {
+	Inner ChatProvider
+	Mode  string
+}
+
+func (p *thinkTagProvider) ChatStream(ctx context.Context, req *ChatCompletionRequest, emit func(ChatEvent) error) error {
+	var convert func(ev ChatEvent, final bool) ChatEvent
+	if p.Mode == thinkTagParse {
+		parser := &thinkTagParser{}
+		convert = parser.event
+		// 从标签中拆出的思考同样要遵守客户端关闭思考的设置，后端的过滤看不到标签里的内容
+		if reasoningDisabled(req) {
+			emit = dropReasoning(emit)
+		}
+	} else {
+		renderer := &thinkTagRenderer{}
+		convert = renderer.event
+	}
+	finished := false
+	err := p.Inner.ChatStream(ctx, req, func(ev ChatEvent) error {
+		final := ev.FinishReason != ""
+		finished = finished || final
+		ev = convert(ev, final)
+		if ev.empty() {
+			return nil
+		}
+		return emit(ev)
+	})
+	if err != nil || finished {
+		return err
+	}
+	if ev := convert(ChatEvent{}, true); ev.hasOutput() {
+		return emit(ev)
+	}
+	return nil
+}
+
+// thinkTagParser 流式拆分 <think> 标签，标签可能被拆在多个分片中，疑似标签开头的尾部先缓冲
+type thinkTagParser struct {
+	inThink bool
+	pending string
+	// trim 标签后紧跟的换行不属于正文
+	trim bool
+}
+
+func (p *thinkTagParser) push(text string) (content, reasoning string) {
+	var cb, rb strings.Builder
+	write := func(s string) {
+		if p.trim {
+			s = strings.TrimLeft(s, "\r\n")
+			if s == "" {
+				return
+			}
+			p.trim = false
+		}
+		if p.inThink {
+			rb.WriteString(s)
+		} else {
+			cb.WriteString(s)
+		}
+	}
+	buf := p.pending + text
+	p.pending = ""
+	for {
+		tag := thinkOpenTag
+		if p.inThink {
+			tag = thinkCloseTag
+		}
+		if i := strings.Index(buf, tag); i >= 0 {
+			write(buf[:i])
+			buf = buf[i+len(tag):]
+			p.inThink = !p.inThink
+			p.trim = true
+			continue
+		}
+		for k := len(tag) - 1; k > 0; k-- {
+			if strings.HasSuffix(buf, tag[:k]) {
+				p.pending = buf[len(buf)-k:]
+				buf = buf[:len(buf)-k]
+				break
+			}
+		}
+		write(buf)
+		return cb.String(), rb.String()
+	}
+}
+
+// flush 流结束时缓冲的半个标签按普通文本输出
+func (p *thinkTagParser) flush() (content, reasoning string) {
+	pending := p.pending
+	p.pending = ""
+	if p.inThink {
+		return "", pending
+	}
+	return pending, ""
+}
+
+func (p *thinkTagParser) event(ev ChatEvent, final bool) ChatEvent {
+	content, reasoning := p.push(ev.Content)
+	if final {
+		c, r := p.flush()
+		content += c
+		reasoning += r
+	}
+	ev.Content = content
+	ev.Reasoning += reasoning
+	return ev
+}
+
+// thinkTagRenderer 把结构化思考内容写回 content，正文、工具调用或结束时闭合标签
+type thinkTagRenderer struct {
+	open bool
+}
+
+func (r *thinkTagRenderer) event(ev ChatEvent, final bool) ChatEvent {
+	var sb strings.Builder
+	if ev.Reasoning != "" {
+		if !r.open {
+			r.open = true
+			sb.WriteString(thinkOpenTag + "\n")
+		}
+		sb.WriteString(ev.Reasoning)
+		ev.Reasoning = ""
+	}
+	if r.open && (ev.Content != "" || len(ev.ToolCalls) > 0 || final) {
+		r.open = false
+		sb.WriteString("\n" + thinkCloseTag + "\n\n")
+	}
+	sb.WriteString(ev.Content)
+	ev.Content = sb.String()
+	return ev
+}
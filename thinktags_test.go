@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestThinkTagParser(t *testing.T) {
+	cases := []struct {
+		name      string
+		chunks    []string
+		content   string
+		reasoning string
+	}{
+		{"whole", []string{"<think>\nplan</think>\n\nanswer"}, "answer", "plan"},
+		{"split tags", []string{"<th", "ink>pl", "an</thi", "nk>", "\n\nans", "wer"}, "answer", "plan"},
+		{"no tags", []string{"a < b", " and c <", "d"}, "a < b and c <d", ""},
+		{"dangling prefix", []string{"x <thi"}, "x <thi", ""},
+		{"unclosed", []string{"<think>still thinking", "</thi"}, "", "still thinking</thi"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &thinkTagParser{}
+			var content, reasoning strings.Builder
+			for i, chunk := range tc.chunks {
+				ev := p.event(ChatEvent{Content: chunk}, i == len(tc.chunks)-1)
+				content.WriteString(ev.Content)
+				reasoning.WriteString(ev.Reasoning)
+			}
+			if content.String() != tc.content || reasoning.String() != tc.reasoning {
+				t.Fatalf("content = %q, reasoning = %q", content.String(), reasoning.String())
+			}
+		})
+	}
+}
+
+func TestThinkTagRenderer(t *testing.T) {
+	r := &thinkTagRenderer{}
+	var out strings.Builder
+	for _, ev := range []ChatEvent{{Reasoning: "a"}, {Reasoning: "b"}, {Content: "c"}, {FinishReason: FinishReasonStop}} {
+		out.WriteString(r.event(ev, ev.FinishReason != "").Content)
+	}
+	if out.String() != "<think>\nab\n</think>\n\nc" {
+		t.Fatalf("out = %q", out.String())
+	}
+	// 只有思考内容时在结束事件闭合
+	r = &thinkTagRenderer{}
+	out.Reset()
+	for _, ev := range []ChatEvent{{Reasoning: "a"}, {FinishReason: FinishReasonStop}} {
+		out.WriteString(r.event(ev, ev.FinishReason != "").Content)
+	}
+	if out.String() != "<think>\na\n</think>\n\n" {
+		t.Fatalf("out = %q", out.String())
+	}
+}
+
+func TestThinkTagRules(t *testing.T) {
+	tagged := newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		writeSSE(w, openaiContent("<thi", ""), openaiContent("nk>\nhmm</th", ""), openaiContent("ink>\n\nHi", ""),
+			openaiChunk(`{}`, "stop"), "[DONE]")
+	})
+	defer tagged.Close()
+	structured := reasoningStandIn()
+	defer structured.Close()
+
+	XConfig = &Config{
+		ChatType: "dify",
+		Providers: []ProviderConfig{
+			{Name: "qwen", Type: "openai", BaseUrl: tagged.URL, Models: []string{"qwen3"}},
+			{Name: "r1", Type: "openai", BaseUrl: structured.URL, Models: []string{"r1"}},
+		},
+		ThinkTags: []ThinkTagRule{
+			{Model: "qwen", Mode: thinkTagParse},
+			{UserAgent: "OldIDE", Mode: thinkTagInline},
+		},
+	}
+	defer func() { XConfig = nil }()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/api/chat", chatHandlerSteam)
+	router.POST("/v1/chat/completions", OpenaiHandler)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/chat", strings.NewReader(`{"model":"qwen3","stream":false,"messages":[{"role":"user","content":"?"}]}`)))
+	var resp OllamaResponse
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Message.Thinking != "hmm" || resp.Message.Content != "Hi" {
+		t.Fatalf("parse: %s", w.Body.String())
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("POST", "/api/chat", strings.NewReader(`{"model":"qwen3","stream":false,"think":false,"messages":[{"role":"user","content":"?"}]}`)))
+	resp = OllamaResponse{}
+	_ = json.Unmarshal(w.Body.Bytes(), &resp)
+	if resp.Message.Thinking != "" || resp.Message.Content != "Hi" {
+		t.Fatalf("parse with thinking disabled: %s", w.Body.String())
+	}
+
+	body := `{"model":"r1","messages":[{"role":"user","content":"?"}]}`
+	for _, ua := range []string{"OldIDE/1.0", "curl/8"} {
+		req := httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body))
+		req.Header.Set("User-Agent", ua)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var out ChatCompletionResponse
+		_ = json.Unmarshal(w.Body.Bytes(), &out)
+		msg := out.Choices[0].Message
+		if ua == "OldIDE/1.0" && (msg.Content != "<think>\nlet me think\n</think>\n\nParis" || msg.ReasoningContent != "") {
+			t.Fatalf("inline: %s", w.Body.String())
+		}
+		if ua == "curl/8" && (msg.Content != "Paris" || msg.ReasoningContent != "let me think") {
+			t.Fatalf("default: %s", w.Body.String())
+		}
+	}
+}
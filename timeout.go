@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 超时与保活：按 timeouts 规则为每个请求设置连接、首字节、分片间隔与总时长的超时；
+// 上游长时间没有输出时按入站协议给客户端发送保活，避免客户端或中间代理因空闲断开连接。
+
+// errUpstreamTimeout 上游超时，按规则取消请求的原因
+var errUpstreamTimeout = errors.New("upstream timeout")
+
+// configDuration 配置中的时长，支持 "30s"、"5m" 这样的字符串或秒数
+type configDuration time.Duration
+
+func (d *configDuration) UnmarshalJSON(data []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(data, &seconds); err == nil {
+		*d = configDuration(seconds * float64(time.Second))
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	value, err := time.ParseDuration(text)
+	if err != nil {
+		return err
+	}
+	*d = configDuration(value)
+	return nil
+}
+
+// TimeoutRule timeouts 配置项，匹配条件与 thinkTags 相同，第一条匹配的规则生效；时长为 0 表示不限制
+type TimeoutRule struct {
+	Route     string         `json:"route"`
+	UserAgent string         `json:"userAgent"`
+	Model     string         `json:"model"`
+	Connect   configDuration `json:"connect"`   // 建立 TCP 连接
+	FirstByte configDuration `json:"firstByte"` // 发出请求到收到第一段响应内容
+	Idle      configDuration `json:"idle"`      // 两段响应内容之间
+	Total     configDuration `json:"total"`     // 整个请求，续写、结构化输出重试等多次上游请求合计
+	Keepalive configDuration `json:"keepalive"` // 客户端多久没有收到数据时发送保活，默认 10s，负数关闭
+	// Ollama NDJSON 流是否发送空内容的心跳分片，部分客户端不能处理，默认关闭
+	NDJSONHeartbeat bool `json:"ndjsonHeartbeat"`
+}
+
+func (r *TimeoutRule) match(path, userAgent, model string) bool {
+	return requestRuleMatch(r.Route, r.UserAgent, r.Model, path, userAgent, model)
+}
+
+// keepaliveInterval 保活间隔，0 表示不发送
+func (r *TimeoutRule) keepaliveInterval() time.Duration {
+	switch {
+	case r.Keepalive < 0:
+		return 0
+	case r.Keepalive == 0:
+		return DefaultPingInterval
+	}
+	return time.Duration(r.Keepalive)
+}
+
+// timeoutRule 当前请求适用的规则，没有匹配的规则时为零值：不限制超时，保活使用默认间隔
+func timeoutRule(c *gin.Context, model string) TimeoutRule {
+	if XConfig != nil {
+		for _, rule := range XConfig.Timeouts {
+			if rule.match(c.Request.URL.Path, c.Request.UserAgent(), model) {
+				return rule
+			}
+		}
+	}
+	return TimeoutRule{}
+}
+
+// upstreamClock 记录一个客户端请求对应的上游连接活动，由 upstreamTransport 更新，clientWatch 据此判断首字节与空闲超时
+type upstreamClock struct {
+	connect time.Duration
+
+	mu sync.Mutex
+	// waiting 已发出请求、尚未收到响应内容；since 为发出请求的时间，收到内容后为最近一次收到的时间
+	waiting bool
+	since   time.Time
+}
+
+func (k *upstreamClock) request() {
+	k.mu.Lock()
+	k.waiting, k.since = true, time.Now()
+	k.mu.Unlock()
+}
+
+func (k *upstreamClock) received() {
+	k.mu.Lock()
+	k.waiting, k.since = false, time.Now()
+	k.mu.Unlock()
+}
+
+// sent 是否已经发出过上游请求
+func (k *upstreamClock) sent() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return !k.since.IsZero()
+}
+
+// check 按规则判断是否超时，还没有发出过请求时不计时
+func (k *upstreamClock) check(rule *TimeoutRule, now time.Time) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.since.IsZero() {
+		return nil
+	}
+	elapsed := now.Sub(k.since)
+	if k.waiting && rule.FirstByte > 0 && elapsed > time.Duration(rule.FirstByte) {
+		return fmt.Errorf("%w (first byte %s)", errUpstreamTimeout, time.Duration(rule.FirstByte))
+	}
+	if !k.waiting && rule.Idle > 0 && elapsed > time.Duration(rule.Idle) {
+		return fmt.Errorf("%w (idle %s)", errUpstreamTimeout, time.Duration(rule.Idle))
+	}
+	return nil
+}
+
+type upstreamClockKey struct{}
+
+func withUpstreamClock(ctx context.Context, clock *upstreamClock) context.Context {
+	return context.WithValue(ctx, upstreamClockKey{}, clock)
+}
+
+func upstreamClockFrom(ctx context.Context) *upstreamClock {
+	clock, _ := ctx.Value(upstreamClockKey{}).(*upstreamClock)
+	return clock
+}
+
+// upstreamTransport 把请求 context 中的 upstreamClock 接到连接上：按规则限制建连时间，记录请求发出与响应内容到达的时间
+type upstreamTransport struct {
+	base *http.Transport
+}
+
+func newUpstreamTransport(base *http.Transport) *upstreamTransport {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	base.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if clock := upstreamClockFrom(ctx); clock != nil && clock.connect > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, clock.connect)
+			defer cancel()
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}
+	return &upstreamTransport{base: base}
+}
+
+func (t *upstreamTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	clock := upstreamClockFrom(req.Context())
+	if clock == nil {
+		return t.base.RoundTrip(req)
+	}
+	clock.request()
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &clockBody{ReadCloser: resp.Body, clock: clock}
+	return resp, nil
+}
+
+type clockBody struct {
+	io.ReadCloser
+	clock *upstreamClock
+}
+
+func (b *clockBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.clock.received()
+	}
+	return n, err
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// silentStandIn 先沉默 delay 再输出第一段，之后沉默 idle 再结束；请求被取消时立即返回
+func silentStandIn(delay, idle time.Duration) *standIn {
+	return newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		wait := func(d time.Duration) bool {
+			select {
+			case <-time.After(d):
+				return true
+			case <-r.Context().Done():
+				return false
+			}
+		}
+		if !wait(delay) {
+			return
+		}
+		writeSSE(w, openaiContent("Hi", ""))
+		if !wait(idle) {
+			return
+		}
+		writeSSE(w, openaiChunk(`{}`, "stop"), "[DONE]")
+	})
+}
+
+func TestTimeoutRules(t *testing.T) {
+	var rules []TimeoutRule
+	if err := json.Unmarshal([]byte(`[{"model":"slow","firstByte":"150ms","keepalive":-1},{"model":"idle","idle":0.15},{"keepalive":"100ms"}]`), &rules); err != nil {
+		t.Fatal(err)
+	}
+	if time.Duration(rules[1].Idle) != 150*time.Millisecond || rules[0].keepaliveInterval() != 0 {
+		t.Fatalf("rules = %+v", rules)
+	}
+	server := silentStandIn(400*time.Millisecond, 400*time.Millisecond)
+	defer server.Close()
+	XConfig = &Config{
+		Providers: []ProviderConfig{{Name: "openai", Type: "openai", BaseUrl: server.URL, Models: []string{"gpt-test", "slow", "idle"}}},
+		Timeouts:  rules,
+	}
+	defer func() { XConfig = nil }()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.POST("/v1/chat/completions", OpenaiHandler)
+	chat := func(model string, stream bool) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		body := fmt.Sprintf(`{"model":%q,"stream":%v,"messages":[{"role":"user","content":"hi"}]}`, model, stream)
+		router.ServeHTTP(w, httptest.NewRequest("POST", "/v1/chat/completions", strings.NewReader(body)))
+		return w
+	}
+
+	// 上游沉默时发送 SSE 注释保活，内容不受影响
+	w := chat("gpt-test", true)
+	if !strings.HasPrefix(w.Body.String(), ": PING\n\n") || !strings.Contains(w.Body.String(), `"content":"Hi"`) ||
+		!strings.HasSuffix(w.Body.String(), "data: [DONE]\n\n") {
+		t.Fatalf("keepalive stream:\n%s", w.Body.String())
+	}
+
+	// 首字节超时返回 504
+	w = chat("slow", false)
+	if w.Code != http.StatusGatewayTimeout || !strings.Contains(w.Body.String(), "first byte") {
+		t.Fatalf("first byte timeout: %d %s", w.Code, w.Body.String())
+	}
+
+	// 分片间隔超时：已经开始的流以错误分片结束
+	w = chat("idle", true)
+	if !strings.Contains(w.Body.String(), `"content":"Hi"`) || !strings.Contains(w.Body.String(), "upstream timeout (idle") {
+		t.Fatalf("idle timeout:\n%s", w.Body.String())
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -69,12 +70,12 @@ func MockOllamaResponse() *OllamaResponse {
 	return &msg
 }
 
-func getModelsByUrl() (*ModelList, error) {
+func getModelsByUrl(ctx context.Context) (*ModelList, error) {
 	if XConfig == nil {
 		return nil, fmt.Errorf("XConfig is nil")
 	}
 	client := &http.Client{}
-	req, err := http.NewRequest("GET", XConfig.ModelsURL, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", XConfig.ModelsURL, nil)
 	if err != nil {
 		log.Printf("创建请求失败: %v", err)
 		return nil, err
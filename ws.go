@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+// /ws/chat：SSE 会被部分代理缓冲，浏览器工具可以改用 WebSocket。一个连接上可以同时进行多个生成，按请求 id 区分：
+//
+//	客户端 → {"type":"request","id":"r1","format":"openai","body":{...}}   format 为 openai（默认）或 ollama，body 为对应格式的请求
+//	客户端 → {"type":"cancel","id":"r1"}                                   取消生成并中止上游请求
+//	服务端 → {"type":"chunk","id":"r1","data":{...}}                       data 与 SSE / NDJSON 流中的分片相同
+//	服务端 → {"type":"done","id":"r1"} / {"type":"cancelled","id":"r1"} / {"type":"error","id":"r1","error":"..."}
+
+// errWSCancelled 客户端发送 cancel 取消生成
+var errWSCancelled = errors.New("cancelled by client")
+
+// wsDefaultMaxGenerations 每个连接默认允许同时进行的生成数
+const wsDefaultMaxGenerations = 8
+
+// WebSocketConfig /ws/chat 配置
+type WebSocketConfig struct {
+	// Origins 允许的浏览器来源（如 https://app.example.com），"*" 为不限；未配置时只允许与 Host 相同的来源
+	Origins        []string `json:"origins"`
+	MaxGenerations int      `json:"maxGenerations"` // 每个连接同时进行的生成数，默认 8
+}
+
+func wsMaxGenerations() int {
+	if XConfig != nil && XConfig.WebSocket.MaxGenerations > 0 {
+		return XConfig.WebSocket.MaxGenerations
+	}
+	return wsDefaultMaxGenerations
+}
+
+// checkWSOrigin 浏览器跨站发起的 WebSocket 不受同源策略限制，需要校验 Origin；不带 Origin 的非浏览器客户端直接放行
+func checkWSOrigin(_ *websocket.Config, r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	if XConfig != nil {
+		for _, allowed := range XConfig.WebSocket.Origins {
+			if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+				return nil
+			}
+		}
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return nil
+	}
+	log.Printf("拒绝 WebSocket 连接: origin=%s host=%s\n", origin, r.Host)
+	return fmt.Errorf("origin not allowed: %s", origin)
+}
+
+type wsFrame struct {
+	Type   string          `json:"type"`
+	ID     string          `json:"id"`
+	Format string          `json:"format,omitempty"`
+	Body   json.RawMessage `json:"body,omitempty"`
+	Data   interface{}     `json:"data,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// wsSession 一个 WebSocket 连接，active 为进行中的生成
+type wsSession struct {
+	c    *gin.Context
+	conn *websocket.Conn
+
+	mu     sync.Mutex
+	active map[string]*clientWatch
+	wg     sync.WaitGroup
+}
+
+// WSChatHandler GET /ws/chat
+func WSChatHandler(c *gin.Context) {
+	server := websocket.Server{
+		// Handshake 返回错误时以 403 拒绝连接
+		Handshake: checkWSOrigin,
+		Handler: func(conn *websocket.Conn) {
+			s := &wsSession{c: c, conn: conn, active: map[string]*clientWatch{}}
+			s.serve()
+		},
+	}
+	server.ServeHTTP(c.Writer, c.Request)
+}
+
+func (s *wsSession) serve() {
+	defer s.wg.Wait()
+	for {
+		var frame wsFrame
+		if err := websocket.JSON.Receive(s.conn, &frame); err != nil {
+			// 连接断开时取消所有进行中的生成
+			s.mu.Lock()
+			for _, watch := range s.active {
+				watch.cancel(errClientGone)
+			}
+			s.mu.Unlock()
+			return
+		}
+		switch frame.Type {
+		case "request":
+			s.start(frame)
+		case "cancel":
+			s.mu.Lock()
+			if watch, ok := s.active[frame.ID]; ok {
+				watch.cancel(errWSCancelled)
+			}
+			s.mu.Unlock()
+		default:
+			s.send(wsFrame{Type: "error", ID: frame.ID, Error: fmt.Sprintf("unknown frame type %q", frame.Type)})
+		}
+	}
+}
+
+// send 写出一帧，websocket.Conn 支持并发写
+func (s *wsSession) send(frame wsFrame) error {
+	return websocket.JSON.Send(s.conn, frame)
+}
+
+func (s *wsSession) start(frame wsFrame) {
+	if frame.ID == "" {
+		s.send(wsFrame{Type: "error", Error: "id is required"})
+		return
+	}
+	var req *ChatCompletionRequest
+	var stream wsStream
+	switch frame.Format {
+	case "", "openai":
+		var input ChatCompletionRequest
+		if err := json.Unmarshal(frame.Body, &input); err != nil {
+			s.send(wsFrame{Type: "error", ID: frame.ID, Error: "Invalid request: " + err.Error()})
+			return
+		}
+		out := input
+		req, stream = &out, wsOpenaiStream{newOpenaiStream(&input)}
+	case "ollama":
+		var input OllamaChatRequest
+		if err := json.Unmarshal(frame.Body, &input); err != nil {
+			s.send(wsFrame{Type: "error", ID: frame.ID, Error: "Invalid request: " + err.Error()})
+			return
+		}
+		req, stream = OllamaToChatRequest(&input), &wsOllamaStream{model: input.Model, start: time.Now()}
+	default:
+		s.send(wsFrame{Type: "error", ID: frame.ID, Error: fmt.Sprintf("unknown format %q", frame.Format)})
+		return
+	}
+	model := req.Model
+	provider, upstreamModel, err := selectProvider(model)
+	if err != nil {
+		s.send(wsFrame{Type: "error", ID: frame.ID, Error: err.Error()})
+		return
+	}
+	provider = withThinkTags(s.c, model, withContinuation(s.c, model, provider))
+	req.Model = upstreamModel
+	req.Stream = true
+
+	s.mu.Lock()
+	if _, ok := s.active[frame.ID]; ok {
+		s.mu.Unlock()
+		s.send(wsFrame{Type: "error", ID: frame.ID, Error: "request id is already in use: " + frame.ID})
+		return
+	}
+	if limit := wsMaxGenerations(); len(s.active) >= limit {
+		s.mu.Unlock()
+		s.send(wsFrame{Type: "error", ID: frame.ID, Error: fmt.Sprintf("too many concurrent generations on this connection (max %d)", limit)})
+		return
+	}
+	watch := watchClient(s.c, model)
+	s.active[frame.ID] = watch
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			delete(s.active, frame.ID)
+			s.mu.Unlock()
+		}()
+		err := provider.ChatStream(watch.ctx, req, watch.emit(func(ev ChatEvent) error {
+			data, ok := stream.chunk(ev)
+			if !ok {
+				return nil
+			}
+			return s.send(wsFrame{Type: "chunk", ID: frame.ID, Data: data})
+		}))
+		if watch.gone() {
+			s.send(wsFrame{Type: "cancelled", ID: frame.ID})
+			return
+		}
+		if err = watch.failure(err); err != nil {
+			log.Println("WebSocket stream error:", err)
+			s.send(wsFrame{Type: "error", ID: frame.ID, Error: err.Error()})
+			return
+		}
+		if data := stream.final(); data != nil {
+			s.send(wsFrame{Type: "chunk", ID: frame.ID, Data: data})
+		}
+		s.send(wsFrame{Type: "done", ID: frame.ID})
+	}()
+}
+
+// wsStream 把中立事件渲染为对应格式的分片，final 为正常结束时还需要补发的分片
+type wsStream interface {
+	chunk(ev ChatEvent) (interface{}, bool)
+	final() interface{}
+}
+
+// wsOpenaiStream 与 OpenAI SSE 流相同的 chat.completion.chunk
+type wsOpenaiStream struct {
+	*openaiStream
+}
+
+func (s wsOpenaiStream) chunk(ev ChatEvent) (interface{}, bool) {
+	// 响应头在 WebSocket 握手后无法再发送，只看数据
+	if !ev.hasPayload() {
+		return nil, false
+	}
+	return s.openaiStream.chunk(ev), true
+}
+
+func (s wsOpenaiStream) final() interface{} {
+	return nil
+}
+
+// wsOllamaStream 与 /api/chat 流相同的分片，结束分片带统计字段
+type wsOllamaStream struct {
+	model      string
+	start      time.Time
+	firstToken time.Time
+	usage      Usage
+	finished   bool
+}
+
+func (s *wsOllamaStream) chunk(ev ChatEvent) (interface{}, bool) {
+	if ev.Usage != nil {
+		s.usage = *ev.Usage
+	}
+	if !ev.hasOutput() {
+		return nil, false
+	}
+	if s.firstToken.IsZero() {
+		s.firstToken = time.Now()
+	}
+	msg := ChatEventToOllama(ev, s.model)
+	if ev.FinishReason != "" {
+		s.finished = true
+		finishOllama(msg, ev.FinishReason, s.usage, s.start, s.firstToken)
+	} else if ev.Content == "" && ev.Reasoning == "" && len(ev.ToolCalls) == 0 {
+		return nil, false
+	}
+	return msg, true
+}
+
+func (s *wsOllamaStream) final() interface{} {
+	if s.finished {
+		return nil
+	}
+	msg := ChatEventToOllama(ChatEvent{}, s.model)
+	finishOllama(msg, FinishReasonStop, s.usage, s.start, s.firstToken)
+	return msg
+}
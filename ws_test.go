@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/websocket"
+)
+
+func TestWSChat(t *testing.T) {
+	cancelled := make(chan struct{}, 1)
+	upstream := newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		var body ChatCompletionRequest
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		writeSSE(w, openaiContent("Hello", ""))
+		if body.Messages[0].Content == "block" {
+			<-r.Context().Done()
+			cancelled <- struct{}{}
+			return
+		}
+		writeSSE(w, openaiChunk(`{}`, "stop"), "[DONE]")
+	})
+	defer upstream.Close()
+	XConfig = &Config{Providers: []ProviderConfig{{Name: "openai", Type: "openai", BaseUrl: upstream.URL, Models: []string{"gpt-test"}}}}
+	defer func() { XConfig = nil }()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ws/chat", WSChatHandler)
+	proxy := httptest.NewServer(router)
+	defer proxy.Close()
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(proxy.URL, "http")+"/ws/chat", "", proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	send := func(v string) {
+		if _, err := conn.Write([]byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 两个生成复用同一连接：a 会一直等待直到被取消，b 正常结束
+	send(`{"type":"request","id":"a","body":{"model":"gpt-test","messages":[{"role":"user","content":"block"}]}}`)
+	send(`{"type":"request","id":"b","format":"ollama","body":{"model":"gpt-test","messages":[{"role":"user","content":"hi"}]}}`)
+
+	frames := map[string][]string{}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	for finished := 0; finished < 2; {
+		var frame struct {
+			Type string          `json:"type"`
+			ID   string          `json:"id"`
+			Data json.RawMessage `json:"data"`
+		}
+		if err := websocket.JSON.Receive(conn, &frame); err != nil {
+			t.Fatalf("%v, frames = %v", err, frames)
+		}
+		switch {
+		case frame.Type != "chunk":
+			finished++
+		case frame.ID == "a":
+			var chunk ChatCompletionStreamResponse
+			_ = json.Unmarshal(frame.Data, &chunk)
+			if chunk.Model != "gpt-test" || chunk.Choices[0].Delta.Content != "Hello" {
+				t.Fatalf("openai chunk = %s", frame.Data)
+			}
+			send(`{"type":"cancel","id":"a"}`)
+		default:
+			var chunk OllamaResponse
+			_ = json.Unmarshal(frame.Data, &chunk)
+			frame.Type = fmt.Sprintf("chunk:%s:%v", chunk.Message.Content, chunk.Done)
+		}
+		frames[frame.ID] = append(frames[frame.ID], frame.Type)
+	}
+	if got := strings.Join(frames["a"], ","); got != "chunk,cancelled" {
+		t.Fatalf("openai frames = %s", got)
+	}
+	if got := strings.Join(frames["b"], ","); got != "chunk:Hello:false,chunk::true,done" {
+		t.Fatalf("ollama frames = %s", got)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("upstream request was not cancelled")
+	}
+}
+
+func TestWSChatLimits(t *testing.T) {
+	upstream := newStandIn(func(w http.ResponseWriter, r *http.Request, n int) {
+		writeSSE(w, openaiContent("Hello", ""))
+		<-r.Context().Done()
+	})
+	defer upstream.Close()
+	XConfig = &Config{
+		Providers: []ProviderConfig{{Name: "openai", Type: "openai", BaseUrl: upstream.URL, Models: []string{"gpt-test"}}},
+		WebSocket: WebSocketConfig{Origins: []string{"https://app.example.com"}, MaxGenerations: 1},
+	}
+	defer func() { XConfig = nil }()
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.GET("/ws/chat", WSChatHandler)
+	proxy := httptest.NewServer(router)
+	defer proxy.Close()
+	wsURL := "ws" + strings.TrimPrefix(proxy.URL, "http") + "/ws/chat"
+
+	// 跨站来源被拒绝，同源与配置的来源放行
+	if _, err := websocket.Dial(wsURL, "", "https://evil.example.com"); err == nil {
+		t.Fatal("cross-site origin accepted")
+	}
+	conn, err := websocket.Dial(wsURL, "", "https://app.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// 超过每个连接的并发数时返回错误帧
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_ = websocket.Message.Send(conn, `{"type":"request","id":"a","body":{"model":"gpt-test","messages":[{"role":"user","content":"hi"}]}}`)
+	var frame wsFrame
+	if err := websocket.JSON.Receive(conn, &frame); err != nil || frame.Type != "chunk" || frame.ID != "a" {
+		t.Fatalf("first frame = %+v, %v", frame, err)
+	}
+	_ = websocket.Message.Send(conn, `{"type":"request","id":"b","body":{"model":"gpt-test","messages":[{"role":"user","content":"hi"}]}}`)
+	frame = wsFrame{}
+	if err := websocket.JSON.Receive(conn, &frame); err != nil || frame.Type != "error" || frame.ID != "b" || !strings.Contains(frame.Error, "max 1") {
+		t.Fatalf("second frame = %+v, %v", frame, err)
+	}
+	_ = websocket.Message.Send(conn, `{"type":"cancel","id":"a"}`)
+	frame = wsFrame{}
+	if err := websocket.JSON.Receive(conn, &frame); err != nil || frame.Type != "cancelled" {
+		t.Fatalf("cancel frame = %+v, %v", frame, err)
+	}
+}